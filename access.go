@@ -0,0 +1,177 @@
+package main
+
+import (
+  "crypto/rand"
+  "encoding/base32"
+  "log/slog"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// --------- Access Control ---------
+
+// AccessSettings decides who may use the bot. The deny list always
+// wins; admins are always allowed otherwise.
+type AccessSettings struct {
+  Policy string  `json:"policy,omitempty"` // "open" (default), "allowlist" or "invite"
+  Allow  []int64 `json:"allow,omitempty"`  // User or chat IDs let in under "allowlist" and "invite"
+  Deny   []int64 `json:"deny,omitempty"`   // User or chat IDs that are always turned away
+}
+
+func (a *AccessSettings) validate() error {
+  switch a.Policy {
+  case "":
+    a.Policy = "open"
+  case "open", "allowlist", "invite":
+  default:
+    return fieldErrorf("access.policy", "access.policy 无效: %q（可选 open、allowlist 或 invite）", a.Policy)
+  }
+  for _, l := range []struct {
+    field string
+    ids   []int64
+  }{{"access.allow", a.Allow}, {"access.deny", a.Deny}} {
+    if containsID(l.ids, 0) {
+      return fieldErrorf(l.field, "%s 包含无效的 ID: 0", l.field)
+    }
+  }
+  return nil
+}
+
+// Invite is a single-use code created by an admin with /invite and
+// redeemed with /start <code>.
+type Invite struct {
+  Created time.Time `json:"created"`
+  By      int64     `json:"by"` // Admin user ID
+}
+
+const maxInvitesPerCommand = 20
+
+func containsID(ids []int64, id int64) bool {
+  for _, x := range ids {
+    if x == id {
+      return true
+    }
+  }
+  return false
+}
+
+// hasAccess reports whether userID may use the bot in chatID.
+func hasAccess(chatID, userID int64) bool {
+  cfg := config()
+  a := cfg.Access
+  if denied(chatID, userID) {
+    return false
+  }
+  if cfg.isAdmin(userID) {
+    return true
+  }
+  switch a.Policy {
+  case "allowlist":
+    return containsID(a.Allow, userID) || containsID(a.Allow, chatID)
+  case "invite":
+    return containsID(a.Allow, userID) || containsID(a.Allow, chatID) || invited(chatID) || invited(userID)
+  }
+  return true
+}
+
+func denied(chatID, userID int64) bool {
+  deny := config().Access.Deny
+  return containsID(deny, userID) || containsID(deny, chatID)
+}
+
+// invited reports whether the chat redeemed an invite code. A user's ID
+// is also their private chat's ID, so a user who redeemed a code in
+// private may use the bot in groups as well.
+func invited(chatID int64) bool {
+  store.mu.Lock()
+  defer store.mu.Unlock()
+  ud, ok := store.Reminder[strconv.FormatInt(chatID, 10)]
+  return ok && ud.Invited
+}
+
+// redeemInvite uses up code and lets the chat in. It reports whether
+// the code was valid.
+func redeemInvite(chatID int64, code string) bool {
+  code = strings.ToUpper(strings.TrimSpace(code))
+  store.mu.Lock()
+  _, ok := store.Invites[code]
+  if ok {
+    delete(store.Invites, code)
+    userDataLocked(chatID).Invited = true
+  }
+  store.mu.Unlock()
+  if ok {
+    saveStorage()
+    slog.Info("invite redeemed", "chat_id", chatID)
+  }
+  return ok
+}
+
+// createInvites stores n new invite codes and returns them.
+func createInvites(adminID int64, n int) []string {
+  codes := make([]string, n)
+  store.mu.Lock()
+  for i := range codes {
+    buf := make([]byte, 5)
+    rand.Read(buf)
+    codes[i] = base32.StdEncoding.EncodeToString(buf)
+    store.Invites[codes[i]] = Invite{Created: time.Now().UTC(), By: adminID}
+  }
+  store.mu.Unlock()
+  saveStorage()
+  return codes
+}
+
+// knownLang returns the chat's language without creating UserData, which
+// must not be stored for people who are turned away.
+func knownLang(chatID int64) string {
+  store.mu.Lock()
+  defer store.mu.Unlock()
+  if ud, ok := store.Reminder[strconv.FormatInt(chatID, 10)]; ok && (ud.Lang == "en" || ud.Lang == "zh") {
+    return ud.Lang
+  }
+  return config().DefaultLang
+}
+
+// rejectionText returns the message for someone turned away.
+func rejectionText(chatID, userID int64) string {
+  lang := knownLang(chatID)
+  if config().Access.Policy == "invite" && !denied(chatID, userID) {
+    return tr(lang, "access_invite_only")
+  }
+  return tr(lang, "access_denied")
+}
+
+// admitMessage enforces the access policy for an incoming message,
+// redeeming an invite code sent with /start. It reports whether the
+// message may be handled.
+func admitMessage(msg *InMessage) bool {
+  if hasAccess(msg.ChatID, msg.UserID) {
+    return true
+  }
+  code := msg.CommandArguments()
+  if msg.IsCommand() && msg.Command() == "start" && code != "" &&
+    config().Access.Policy == "invite" && !denied(msg.ChatID, msg.UserID) {
+    if redeemInvite(msg.ChatID, code) {
+      sendText(msg.ChatID, "invite_accepted")
+      return true
+    }
+    msg.log().Info("invalid invite code")
+    messenger.SendText(msg.ChatID, tr(knownLang(msg.ChatID), "invite_invalid"))
+    return false
+  }
+  msg.log().Info("access denied")
+  messenger.SendText(msg.ChatID, rejectionText(msg.ChatID, msg.UserID))
+  return false
+}
+
+// admitCallback enforces the access policy for a button press.
+func admitCallback(q *InCallback) bool {
+  if hasAccess(q.ChatID, q.UserID) {
+    return true
+  }
+  q.log().Info("access denied")
+  messenger.AnswerCallback(q.ID, stripHTML(rejectionText(q.ChatID, q.UserID)))
+  return false
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "log/slog"
+  "os"
+  "reflect"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+// --------- Admin Commands ---------
+
+// broadcastInterval spaces out /broadcast messages so that regular
+// traffic keeps most of the global send rate.
+const broadcastInterval = 200 * time.Millisecond
+
+// restartOnly are settings /reload cannot apply to a running bot.
+var restartOnly = map[string]bool{
+  "token": true, "mode": true, "data_path": true, "storage": true, "log_format": true, "debug": true,
+  "metrics_listen": true, "api_listen": true, "poll_timeout": true, "workers": true,
+}
+
+// handleAdminCommand runs msg if it is an admin command. The caller has
+// checked that the sender is an admin.
+func handleAdminCommand(msg *InMessage) bool {
+  chatID := msg.ChatID
+  args := strings.TrimSpace(msg.CommandArguments())
+  switch msg.Command() {
+  case "stats":
+    sendStats(chatID)
+    audit(msg, "ok")
+
+  case "broadcast":
+    if args == "" {
+      sendText(chatID, "admin_broadcast_usage")
+      return true
+    }
+    chats := activeChats()
+    sendText(chatID, "admin_broadcast_started", Count{len(chats), "chats"})
+    audit(msg, fmt.Sprintf("started for %d chats", len(chats)))
+    go broadcast(msg, chats, args)
+
+  case "user":
+    id, err := strconv.ParseInt(args, 10, 64)
+    if err != nil {
+      sendText(chatID, "admin_user_usage")
+      return true
+    }
+    dump, ok := inspectChat(id)
+    if !ok {
+      sendText(chatID, "admin_chat_not_found", id)
+      audit(msg, "not found")
+      return true
+    }
+    name := fmt.Sprintf("chat-%d.json", id)
+    if _, err := messenger.SendDocument(chatID, name, dump, tr(getUserData(chatID).Lang, "admin_user_dump", id)); err != nil {
+      msg.log().Error("send chat dump failed", "target", id, "err", err)
+      audit(msg, "send failed")
+      return true
+    }
+    audit(msg, "ok")
+
+  case "purge":
+    id, err := strconv.ParseInt(args, 10, 64)
+    if err != nil {
+      sendText(chatID, "admin_purge_usage")
+      return true
+    }
+    if !purgeChat(id) {
+      sendText(chatID, "admin_chat_not_found", id)
+      audit(msg, "not found")
+      return true
+    }
+    sendText(chatID, "admin_purged", id)
+    audit(msg, "purged")
+
+  case "invite":
+    n := 1
+    if args != "" {
+      var err error
+      if n, err = strconv.Atoi(args); err != nil || n < 1 || n > maxInvitesPerCommand {
+        sendText(chatID, "admin_invite_usage")
+        return true
+      }
+    }
+    var lines []string
+    for _, code := range createInvites(msg.UserID, n) {
+      line := render("`%s`", code)
+      if bot != nil {
+        line += render("  `https://t.me/%s?start=%s`", bot.Self.UserName, code)
+      }
+      lines = append(lines, line)
+    }
+    sendText(chatID, "admin_invites", HTML(strings.Join(lines, "\n")))
+    audit(msg, fmt.Sprintf("%d codes", n))
+
+  case "reload":
+    ignored, err := reloadConfig()
+    if err != nil {
+      sendText(chatID, "admin_reload_failed", err.Error())
+      audit(msg, "failed: "+err.Error())
+      return true
+    }
+    sendText(chatID, "admin_reloaded")
+    if len(ignored) > 0 {
+      sendText(chatID, "admin_reload_restart", strings.Join(ignored, ", "))
+    }
+    audit(msg, "ok")
+
+  default:
+    return false
+  }
+  return true
+}
+
+func sendStats(chatID int64) {
+  lang := getUserData(chatID).Lang
+  var active, inactive, once, cron int
+  store.mu.Lock()
+  for _, ud := range store.Reminder {
+    if ud.InactiveSince != nil {
+      inactive++
+    } else {
+      active++
+    }
+    for _, r := range ud.Reminders {
+      if r.CronExpr != "" {
+        cron++
+      } else {
+        once++
+      }
+    }
+  }
+  sessions := len(store.Sessions)
+  fires := make(map[string]int, len(store.Fires))
+  for day, n := range store.Fires {
+    fires[day] = n
+  }
+  store.mu.Unlock()
+
+  text := tr(lang, "admin_stats", active+inactive, active, inactive, once+cron, once, cron, sessions)
+  now := time.Now().UTC()
+  for i := 0; i < 7; i++ {
+    day := now.AddDate(0, 0, -i).Format("2006-01-02")
+    text += render("\n`%s`  %d", day, fires[day])
+  }
+  messenger.SendText(chatID, text)
+}
+
+// activeChats returns the chats that can still be reached, in ID order.
+func activeChats() []int64 {
+  store.mu.Lock()
+  defer store.mu.Unlock()
+  var ids []int64
+  for key, ud := range store.Reminder {
+    if ud.InactiveSince != nil {
+      continue
+    }
+    if id, err := strconv.ParseInt(key, 10, 64); err == nil {
+      ids = append(ids, id)
+    }
+  }
+  sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+  return ids
+}
+
+// broadcast sends text to chats one at a time and reports to the admin
+// when done. Chats that turn out to be unreachable are deactivated by
+// the messenger as usual.
+func broadcast(msg *InMessage, chats []int64, text string) {
+  sent, failed := 0, 0
+  for i, id := range chats {
+    if i > 0 {
+      time.Sleep(broadcastInterval)
+    }
+    if _, err := messenger.SendText(id, markup(text)); err != nil {
+      slog.Warn("broadcast failed", "chat_id", id, "err", err)
+      failed++
+    } else {
+      sent++
+    }
+  }
+  sendText(msg.ChatID, "admin_broadcast_done", sent, failed)
+  audit(msg, fmt.Sprintf("done: %d sent, %d failed", sent, failed))
+}
+
+// inspectChat returns the chat's stored data as indented JSON, with
+// channel secrets masked.
+func inspectChat(chatID int64) ([]byte, bool) {
+  key := strconv.FormatInt(chatID, 10)
+  store.mu.Lock()
+  ud, ok := store.Reminder[key]
+  var view struct {
+    UserData
+    Session *Session `json:"session,omitempty"`
+  }
+  if ok {
+    view.UserData = ud.clone()
+    if view.Reminders == nil {
+      view.Reminders = []Reminder{}
+    }
+    if s, ok := store.Sessions[key]; ok {
+      view.Session = s.clone()
+    }
+  }
+  store.mu.Unlock()
+  if !ok {
+    return nil, false
+  }
+  for i := range view.Channels {
+    if view.Channels[i].Secret != "" {
+      view.Channels[i].Secret = "***"
+    }
+  }
+  bs, err := json.MarshalIndent(view, "", "  ")
+  if err != nil {
+    return []byte(err.Error()), true
+  }
+  return bs, true
+}
+
+// reloadConfig reads the configuration again and applies it, together
+// with the locale overrides. Changed settings that need a restart keep
+// their current value and are returned.
+func reloadConfig() ([]string, error) {
+  cfg, err := loadConfig(os.Args[1:])
+  if err != nil {
+    return nil, err
+  }
+  old := config()
+  var ignored []string
+  for _, f := range configFields {
+    if !restartOnly[f.name] && !strings.HasPrefix(f.name, "webhook.") {
+      continue
+    }
+    cur := reflect.ValueOf(f.ptr(old)).Elem()
+    next := reflect.ValueOf(f.ptr(cfg)).Elem()
+    if !reflect.DeepEqual(cur.Interface(), next.Interface()) {
+      ignored = append(ignored, f.name)
+      next.Set(cur)
+    }
+  }
+  if _, err := loadLocales(cfg.LocalesDir); err != nil {
+    return nil, err
+  }
+  setConfig(cfg)
+  logLevel.Set(parseLevel(cfg.LogLevel))
+  updateSecrets(cfg)
+  return ignored, nil
+}
+
+// --------- Audit Log ---------
+
+type auditEntry struct {
+  Time    time.Time `json:"time"`
+  AdminID int64     `json:"admin_id"`
+  ChatID  int64     `json:"chat_id"`
+  Command string    `json:"command"`
+  Args    string    `json:"args,omitempty"`
+  Result  string    `json:"result"`
+}
+
+var auditMu sync.Mutex
+
+// audit records an admin action in the log and, unless audit_log is "-",
+// appends it to the audit file as a JSON line.
+func audit(msg *InMessage, result string) {
+  e := auditEntry{
+    Time:    time.Now().UTC(),
+    AdminID: msg.UserID,
+    ChatID:  msg.ChatID,
+    Command: msg.Command(),
+    Args:    msg.CommandArguments(),
+    Result:  result,
+  }
+  slog.Info("admin action", "admin_id", e.AdminID, "chat_id", e.ChatID, "command", e.Command, "result", e.Result)
+  path := config().AuditLog
+  if path == "-" {
+    return
+  }
+  bs, _ := json.Marshal(e)
+  auditMu.Lock()
+  defer auditMu.Unlock()
+  f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+  if err != nil {
+    slog.Error("write audit log failed", "path", path, "err", err)
+    return
+  }
+  defer f.Close()
+  if _, err := f.Write(append(bs, '\n')); err != nil {
+    slog.Error("write audit log failed", "path", path, "err", err)
+  }
+}
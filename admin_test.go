@@ -0,0 +1,50 @@
+package main
+
+import (
+  "encoding/json"
+  "sync"
+  "testing"
+  "time"
+)
+
+func TestInspectChatSnapshot(t *testing.T) {
+  setupTest(t)
+  const chatID = 71
+  if _, ok := inspectChat(chatID); ok {
+    t.Fatal("dump of a chat without data")
+  }
+  updateUserData(chatID, func(ud *UserData) {
+    ud.Reminders = append(ud.Reminders, Reminder{ID: 501, Name: "Gym", Date: "01/05/2030", Time: "7:00 am"})
+    ud.Archive = append(ud.Archive, Reminder{ID: 502, Name: "Flight"})
+    ud.Channels = append(ud.Channels, Channel{Name: "hook", Kind: "webhook", Target: "https://example.com", Secret: "s3cret"})
+  })
+
+  // Fires are recorded on archived reminders while the dump is taken.
+  var wg sync.WaitGroup
+  wg.Add(1)
+  go func() {
+    defer wg.Done()
+    for i := 0; i < 500; i++ {
+      recordFire(chatID, 502, FireRecord{Scheduled: time.Now().UTC(), Status: fireDelivered})
+    }
+  }()
+  var dump []byte
+  for i := 0; i < 500; i++ {
+    dump, _ = inspectChat(chatID)
+  }
+  wg.Wait()
+
+  var view struct {
+    UserData
+    Session *Session `json:"session"`
+  }
+  if err := json.Unmarshal(dump, &view); err != nil {
+    t.Fatal(err)
+  }
+  if len(view.Reminders) != 1 || len(view.Archive) != 1 || view.Channels[0].Secret != "***" {
+    t.Fatalf("dump %s", dump)
+  }
+  if getUserData(chatID).Channels[0].Secret != "s3cret" {
+    t.Fatal("masking the dump changed the stored secret")
+  }
+}
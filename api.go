@@ -0,0 +1,463 @@
+package main
+
+import (
+  "crypto/rand"
+  "crypto/sha256"
+  _ "embed"
+  "encoding/base64"
+  "encoding/hex"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "log/slog"
+  "net/http"
+  "strconv"
+  "strings"
+  "time"
+  "unicode/utf8"
+
+  "github.com/gorhill/cronexpr"
+)
+
+// --------- HTTP API ---------
+
+// The API lets other tools manage a chat's reminders. Each chat has at
+// most one token, issued with /token; only its SHA-256 is stored.
+
+// APIToken is a stored API token.
+type APIToken struct {
+  ChatID  int64     `json:"chat_id"`
+  UserID  int64     `json:"user_id"` // Who issued it
+  Created time.Time `json:"created"`
+}
+
+const maxAPIBody = 64 << 10
+
+//go:embed openapi.json
+var openAPIDoc []byte
+
+func hashToken(tok string) string {
+  sum := sha256.Sum256([]byte(tok))
+  return hex.EncodeToString(sum[:])
+}
+
+// issueToken replaces the chat's API token with a new one and returns it.
+func issueToken(chatID, userID int64) string {
+  buf := make([]byte, 24)
+  rand.Read(buf)
+  tok := "rb_" + base64.RawURLEncoding.EncodeToString(buf)
+  store.mu.Lock()
+  revokeTokensLocked(chatID)
+  store.APITokens[hashToken(tok)] = APIToken{ChatID: chatID, UserID: userID, Created: time.Now().UTC()}
+  store.mu.Unlock()
+  saveStorage()
+  slog.Info("api token issued", "chat_id", chatID, "user_id", userID)
+  return tok
+}
+
+// revokeTokens drops the chat's API token and reports whether it had one.
+func revokeTokens(chatID int64) bool {
+  store.mu.Lock()
+  n := revokeTokensLocked(chatID)
+  store.mu.Unlock()
+  if n > 0 {
+    saveStorage()
+  }
+  return n > 0
+}
+
+// revokeTokensLocked is revokeTokens without saving. store.mu must be
+// held.
+func revokeTokensLocked(chatID int64) int {
+  n := 0
+  for h, t := range store.APITokens {
+    if t.ChatID == chatID {
+      delete(store.APITokens, h)
+      n++
+    }
+  }
+  return n
+}
+
+// handleTokenCommand implements /token and /token revoke.
+func handleTokenCommand(chatID, userID int64, args string) {
+  if config().APIListen == "" {
+    sendText(chatID, "token_disabled")
+    return
+  }
+  switch strings.TrimSpace(args) {
+  case "":
+    sendText(chatID, "token_issued", issueToken(chatID, userID))
+  case "revoke":
+    if revokeTokens(chatID) {
+      sendText(chatID, "token_revoked")
+    } else {
+      sendText(chatID, "token_none")
+    }
+  default:
+    sendText(chatID, "token_usage")
+  }
+}
+
+// startAPIServer serves the API on addr.
+func startAPIServer(addr string) {
+  mux := http.NewServeMux()
+  mux.HandleFunc("/api/v1/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(openAPIDoc)
+  })
+  mux.HandleFunc("/api/v1/reminders", apiAuth(handleAPIReminders))
+  mux.HandleFunc("/api/v1/reminders/", apiAuth(handleAPIReminder))
+  mux.HandleFunc("/ics/", handleFeed)
+  srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+  go func() {
+    if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+      fatal("api server failed", "err", err)
+    }
+  }()
+  slog.Info("api server listening", "listen", addr)
+}
+
+// apiError is the body of every error response.
+type apiError struct {
+  Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, format string, a ...interface{}) {
+  writeJSON(w, status, apiError{Error: fmt.Sprintf(format, a...)})
+}
+
+// apiAuth resolves the bearer token to its chat and applies the access
+// policy and the chat's command rate limit.
+func apiAuth(h func(w http.ResponseWriter, r *http.Request, chatID int64)) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    tok := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+    store.mu.Lock()
+    t, ok := store.APITokens[hashToken(tok)]
+    store.mu.Unlock()
+    if tok == "" || !ok {
+      w.Header().Set("WWW-Authenticate", "Bearer")
+      writeAPIError(w, http.StatusUnauthorized, "missing or invalid token")
+      return
+    }
+    if !hasAccess(t.ChatID, t.UserID) {
+      writeAPIError(w, http.StatusForbidden, "access denied")
+      return
+    }
+    if ok, _ := commandLimiter.allow(t.ChatID, config().Limits.commandsPerMinute(), time.Minute); !ok {
+      w.Header().Set("Retry-After", "60")
+      writeAPIError(w, http.StatusTooManyRequests, "rate limit exceeded")
+      return
+    }
+    slog.Debug("api request", "chat_id", t.ChatID, "method", r.Method, "path", r.URL.Path)
+    r.Body = http.MaxBytesReader(w, r.Body, maxAPIBody)
+    h(w, r, t.ChatID)
+  }
+}
+
+// apiReminder is a reminder as the API shows it. One-time reminders have
+// Date and Time in the chat's local time, recurring ones Cron and TZ.
+type apiReminder struct {
+  ID       int               `json:"id"`
+  Name     string            `json:"name"`
+  Info     string            `json:"info,omitempty"`
+  Date     string            `json:"date,omitempty"` // "2006-01-02"
+  Time     string            `json:"time,omitempty"` // "15:04"
+  Cron     string            `json:"cron,omitempty"`
+  TZ       string            `json:"tz,omitempty"`
+  Paused   bool              `json:"paused"`
+  OverrideDND bool           `json:"override_dnd,omitempty"`
+  Priority string            `json:"priority,omitempty"` // low, high or urgent; empty is normal
+  Channels []string          `json:"channels,omitempty"`
+  Failures []DeliveryFailure `json:"failures,omitempty"`
+}
+
+// apiReminderInput is the body of a create or update. Fields left out
+// of an update keep their value.
+type apiReminderInput struct {
+  Name     *string   `json:"name"`
+  Info     *string   `json:"info"`
+  Date     *string   `json:"date"`
+  Time     *string   `json:"time"`
+  Cron     *string   `json:"cron"`
+  TZ       *string   `json:"tz"`
+  Channels *[]string `json:"channels"`
+  OverrideDND *bool  `json:"override_dnd"`
+  Priority *string   `json:"priority"`
+}
+
+func toAPIReminder(r Reminder) apiReminder {
+  out := apiReminder{
+    ID:       r.ID,
+    Name:     r.Name,
+    Info:     r.OptInfo,
+    Cron:     r.CronOriginal,
+    TZ:       r.TZ,
+    Paused:   r.Paused,
+    OverrideDND: r.OverrideDND,
+    Priority: r.Priority,
+    Channels: r.Channels,
+    Failures: r.Failures,
+  }
+  if r.CronExpr == "" {
+    if at, err := reminderWallClock(r); err == nil {
+      out.Date, out.Time = at.Format("2006-01-02"), at.Format("15:04")
+    } else {
+      out.Date, out.Time = r.Date, r.Time
+    }
+  }
+  return out
+}
+
+// apply updates r from in and checks the result against the chat's
+// limits and channels.
+func (in apiReminderInput) apply(chatID int64, r *Reminder) error {
+  if in.Name != nil {
+    r.Name = strings.TrimSpace(*in.Name)
+  }
+  if in.Info != nil {
+    r.OptInfo = *in.Info
+  }
+  if in.Channels != nil {
+    r.Channels = *in.Channels
+  }
+  if in.OverrideDND != nil {
+    r.OverrideDND = *in.OverrideDND
+  }
+  if in.Priority != nil {
+    if !validPriority(*in.Priority) {
+      return errors.New("priority must be low, normal, high or urgent")
+    }
+    r.Priority = storedPriority(*in.Priority)
+  }
+  cur := toAPIReminder(*r)
+  date, clock, spec, tz := cur.Date, cur.Time, cur.Cron, cur.TZ
+  if in.Date != nil || in.Time != nil {
+    // Switching to a one-time reminder.
+    spec, tz = "", ""
+    if in.Date != nil {
+      date = *in.Date
+    }
+    if in.Time != nil {
+      clock = *in.Time
+    }
+  }
+  if in.Cron != nil {
+    if in.Date != nil || in.Time != nil {
+      return errors.New("give either date and time or cron, not both")
+    }
+    date, clock, spec = "", "", strings.TrimSpace(*in.Cron)
+  }
+  if in.TZ != nil {
+    tz = *in.TZ
+  }
+
+  max := config().Limits.maxTextLength()
+  switch {
+  case r.Name == "":
+    return errors.New("name is required")
+  case utf8.RuneCountInString(r.Name) > max || utf8.RuneCountInString(r.OptInfo) > max:
+    return fmt.Errorf("name and info are limited to %d characters", max)
+  }
+  ud := getUserData(chatID)
+  for _, name := range r.Channels {
+    if findChannel(ud, name) < 0 {
+      return fmt.Errorf("no channel named %q", name)
+    }
+  }
+
+  if spec == "" {
+    at, err := time.Parse("2006-01-02 15:04", date+" "+clock)
+    if err != nil {
+      return errors.New(`date and time must look like "2006-01-02" and "15:04"`)
+    }
+    r.Date, r.Time = at.Format("02/01/2006"), at.Format("3:04 pm")
+    r.CronExpr, r.CronOriginal, r.TZ = "", "", ""
+    return nil
+  }
+  if tz == "" {
+    tz = "UTC"
+  }
+  loc, err := time.LoadLocation(tz)
+  if err != nil {
+    return fmt.Errorf("unknown time zone %q", tz)
+  }
+  expr, err := cronexpr.Parse(spec)
+  if err != nil {
+    return fmt.Errorf("invalid cron expression: %v", err)
+  }
+  if !cronIntervalOK(expr, loc) {
+    return fmt.Errorf("cron reminders may fire at most once every %d minutes", int(config().Limits.minCronInterval()/time.Minute))
+  }
+  r.Date, r.Time = "", ""
+  r.CronExpr, r.CronOriginal, r.TZ = spec, spec, tz
+  return nil
+}
+
+// readInput decodes the request body, answering 400 if it is not valid.
+func readInput(w http.ResponseWriter, r *http.Request) (apiReminderInput, bool) {
+  var in apiReminderInput
+  dec := json.NewDecoder(r.Body)
+  dec.DisallowUnknownFields()
+  if err := dec.Decode(&in); err != nil {
+    writeAPIError(w, http.StatusBadRequest, "invalid JSON body: %v", err)
+    return in, false
+  }
+  return in, true
+}
+
+// findReminder returns the chat's reminder with id.
+func findReminder(chatID int64, id int) (Reminder, bool) {
+  for _, r := range getUserData(chatID).Reminders {
+    if r.ID == id {
+      return r, true
+    }
+  }
+  return Reminder{}, false
+}
+
+// replaceReminder stores r in place of the reminder with the same ID and
+// reschedules it. It reports false if the reminder is gone.
+func replaceReminder(chatID int64, r Reminder) bool {
+  found := false
+  active := true
+  updateUserData(chatID, func(ud *UserData) {
+    active = ud.InactiveSince == nil
+    list := make([]Reminder, len(ud.Reminders))
+    for i, cur := range ud.Reminders {
+      if cur.ID == r.ID {
+        cur, found = r, true
+      }
+      list[i] = cur
+    }
+    ud.Reminders = list
+  })
+  if found {
+    cancelJob(r.ID)
+    if active {
+      scheduleReminder(chatID, r)
+    }
+  }
+  return found
+}
+
+// handleAPIReminders serves GET and POST /api/v1/reminders.
+func handleAPIReminders(w http.ResponseWriter, r *http.Request, chatID int64) {
+  switch r.Method {
+  case http.MethodGet:
+    out := []apiReminder{}
+    for _, rem := range getUserData(chatID).Reminders {
+      out = append(out, toAPIReminder(rem))
+    }
+    writeJSON(w, http.StatusOK, out)
+
+  case http.MethodPost:
+    in, ok := readInput(w, r)
+    if !ok {
+      return
+    }
+    if max := config().Limits.maxReminders(); len(getUserData(chatID).Reminders) >= max {
+      writeAPIError(w, http.StatusConflict, "limit of %d reminders reached", max)
+      return
+    }
+    rem := Reminder{ID: int(time.Now().UnixNano() % 1e6)}
+    if err := in.apply(chatID, &rem); err != nil {
+      writeAPIError(w, http.StatusBadRequest, "%v", err)
+      return
+    }
+    active := true
+    updateUserData(chatID, func(ud *UserData) {
+      active = ud.InactiveSince == nil
+      ud.Reminders = append(ud.Reminders, rem)
+    })
+    if active {
+      scheduleReminder(chatID, rem)
+    }
+    slog.Info("reminder created through api", "chat_id", chatID, "reminder_id", rem.ID)
+    writeJSON(w, http.StatusCreated, toAPIReminder(rem))
+
+  default:
+    w.Header().Set("Allow", "GET, POST")
+    writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+  }
+}
+
+// handleAPIReminder serves /api/v1/reminders/{id} and its actions.
+func handleAPIReminder(w http.ResponseWriter, r *http.Request, chatID int64) {
+  rest := strings.TrimPrefix(r.URL.Path, "/api/v1/reminders/")
+  idPart, action, _ := strings.Cut(rest, "/")
+  id, err := strconv.Atoi(idPart)
+  if err != nil {
+    writeAPIError(w, http.StatusNotFound, "not found")
+    return
+  }
+  rem, ok := findReminder(chatID, id)
+  if !ok {
+    writeAPIError(w, http.StatusNotFound, "no reminder %d", id)
+    return
+  }
+
+  allowed := "GET, PATCH, DELETE"
+  if action != "" {
+    allowed = "POST"
+  }
+  switch {
+  case action == "" && r.Method == http.MethodGet:
+    writeJSON(w, http.StatusOK, toAPIReminder(rem))
+
+  case action == "" && r.Method == http.MethodPatch:
+    in, ok := readInput(w, r)
+    if !ok {
+      return
+    }
+    if err := in.apply(chatID, &rem); err != nil {
+      writeAPIError(w, http.StatusBadRequest, "%v", err)
+      return
+    }
+    // A changed reminder gets a fresh start.
+    rem.Failures = nil
+    if !replaceReminder(chatID, rem) {
+      writeAPIError(w, http.StatusNotFound, "no reminder %d", id)
+      return
+    }
+    writeJSON(w, http.StatusOK, toAPIReminder(rem))
+
+  case action == "" && r.Method == http.MethodDelete:
+    deleteReminder(chatID, id, false)
+    w.WriteHeader(http.StatusNoContent)
+
+  case (action == "pause" || action == "resume") && r.Method == http.MethodPost:
+    rem.Paused = action == "pause"
+    if !replaceReminder(chatID, rem) {
+      writeAPIError(w, http.StatusNotFound, "no reminder %d", id)
+      return
+    }
+    writeJSON(w, http.StatusOK, toAPIReminder(rem))
+
+  case action == "test" && r.Method == http.MethodPost:
+    if ok, _ := fireLimiter.allow(chatID, config().Limits.firesPerHour(), time.Hour); !ok {
+      writeAPIError(w, http.StatusTooManyRequests, "limit of notifications per hour reached")
+      return
+    }
+    text := notificationText(chatID, rem)
+    if _, err := messenger.SendText(chatID, text); err != nil {
+      writeAPIError(w, http.StatusBadGateway, "send failed: %s", redact(err.Error()))
+      return
+    }
+    notifyChannels(chatID, rem, text)
+    w.WriteHeader(http.StatusNoContent)
+
+  case action != "" && action != "pause" && action != "resume" && action != "test":
+    writeAPIError(w, http.StatusNotFound, "not found")
+
+  default:
+    w.Header().Set("Allow", allowed)
+    writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+  }
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+  "context"
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/base64"
+  "errors"
+  "fmt"
+  "log/slog"
+  "runtime/debug"
+  "strconv"
+  "strings"
+)
+
+// --------- Callback Data ---------
+
+// Button data has the form "<version>;<prefix>;<args...>;<mac>". The MAC
+// is an HMAC of the chat ID and everything before it, keyed from the bot
+// token, so data cannot be forged or replayed in another chat. Data of
+// another version, such as keyboards sent before an upgrade, is reported
+// as expired.
+
+const (
+  callbackVersion = "1"
+  callbackMACSize = 8  // Bytes of the HMAC kept in the data
+  maxCallbackData = 64 // Telegram's limit, in bytes
+)
+
+// Callback prefixes, each routed to an entry of callbackHandlers.
+const (
+  cbNoop     = "NOP"  // Labels that do nothing when pressed
+  cbCancel   = "CXL"  // <index>
+  cbLang     = "LANG" // <en|zh>
+  cbCalendar = "CAL"  // DAY <year> <month> <day>, PREV|NEXT <year> <month>
+  cbClock    = "CLK"  // <action> <hour> <minute> <am|pm>
+  cbAskInfo  = "INFO" // <yes|no>
+  cbUTC      = "UTC"  // PLUS|MINUS|OKAY <offset>
+  cbImport   = "IMP"  // ok|no <batch>
+  cbForget   = "FGT"  // yes|no
+  cbFire     = "FIRE" // ack|snooze <reminder id>
+  cbRestore  = "RST"  // <reminder id>
+  cbAgenda   = "AGD"  // edit|later <reminder id>
+  cbPriority = "PRIO" // low|normal|high|urgent
+)
+
+var (
+  errCallbackExpired   = errors.New("callback data of an old version")
+  errCallbackForged    = errors.New("callback signature mismatch")
+  errCallbackMalformed = errors.New("malformed callback data")
+  errCallbackStale     = errors.New("callback does not match the session")
+  errCallbackPanic     = errors.New("callback handler panicked")
+)
+
+// callback is decoded, verified button data.
+type callback struct {
+  Prefix string
+  Args   []string
+}
+
+// callbackHandler handles one prefix. The caller answers the callback
+// query according to the returned error.
+type callbackHandler func(q *InCallback, cb callback) error
+
+func callbackMAC(chatID int64, payload string) string {
+  key := sha256.Sum256([]byte("callback:" + config().Token))
+  mac := hmac.New(sha256.New, key[:])
+  fmt.Fprintf(mac, "%d;%s", chatID, payload)
+  return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:callbackMACSize])
+}
+
+// encodeCallback returns signed button data for chatID.
+func encodeCallback(chatID int64, prefix string, args ...interface{}) string {
+  parts := []string{callbackVersion, prefix}
+  for _, a := range args {
+    parts = append(parts, fmt.Sprint(a))
+  }
+  payload := strings.Join(parts, ";")
+  data := payload + ";" + callbackMAC(chatID, payload)
+  if len(data) > maxCallbackData {
+    slog.Error("callback data too long", "chat_id", chatID, "prefix", prefix, "len", len(data))
+  }
+  return data
+}
+
+// callbackButton is newButton with signed data.
+func callbackButton(chatID int64, text, prefix string, args ...interface{}) Button {
+  return newButton(text, encodeCallback(chatID, prefix, args...))
+}
+
+// decodeCallback verifies data pressed in chatID and splits it.
+func decodeCallback(chatID int64, data string) (callback, error) {
+  i := strings.LastIndexByte(data, ';')
+  if i < 0 {
+    return callback{}, errCallbackExpired
+  }
+  payload, mac := data[:i], data[i+1:]
+  parts := strings.Split(payload, ";")
+  if parts[0] != callbackVersion || len(parts) < 2 {
+    return callback{}, errCallbackExpired
+  }
+  if !hmac.Equal([]byte(mac), []byte(callbackMAC(chatID, payload))) {
+    return callback{}, errCallbackForged
+  }
+  return callback{Prefix: parts[1], Args: parts[2:]}, nil
+}
+
+// callbackArgs reads a callback's arguments in order. The first missing
+// or invalid argument is kept in err and later reads return zero values.
+type callbackArgs struct {
+  args []string
+  err  error
+}
+
+func (cb callback) args() *callbackArgs {
+  return &callbackArgs{args: cb.Args}
+}
+
+func (a *callbackArgs) next() (string, bool) {
+  if a.err != nil {
+    return "", false
+  }
+  if len(a.args) == 0 {
+    a.err = errCallbackMalformed
+    return "", false
+  }
+  s := a.args[0]
+  a.args = a.args[1:]
+  return s, true
+}
+
+// int reads an integer in [min, max].
+func (a *callbackArgs) int(min, max int) int {
+  s, ok := a.next()
+  if !ok {
+    return 0
+  }
+  n, err := strconv.Atoi(s)
+  if err != nil || n < min || n > max {
+    a.err = errCallbackMalformed
+    return 0
+  }
+  return n
+}
+
+// word reads one of the allowed strings.
+func (a *callbackArgs) word(allowed ...string) string {
+  s, ok := a.next()
+  if !ok {
+    return ""
+  }
+  for _, w := range allowed {
+    if s == w {
+      return s
+    }
+  }
+  a.err = errCallbackMalformed
+  return ""
+}
+
+// end reports the first error, or errCallbackMalformed if arguments are
+// left over.
+func (a *callbackArgs) end() error {
+  if a.err == nil && len(a.args) > 0 {
+    a.err = errCallbackMalformed
+  }
+  return a.err
+}
+
+// runCallback calls h, turning a panic into errCallbackPanic so that one
+// bad button cannot take the bot down.
+func runCallback(h callbackHandler, q *InCallback, cb callback) (err error) {
+  defer func() {
+    if p := recover(); p != nil {
+      q.log().Error("callback handler panicked", "prefix", cb.Prefix, "panic", p, "stack", string(debug.Stack()))
+      err = errCallbackPanic
+    }
+  }()
+  return h(q, cb)
+}
+
+// answerCallback answers q according to the handler's result.
+func answerCallback(q *InCallback, err error) {
+  switch {
+  case err == nil:
+    messenger.AnswerCallback(q.ID, "")
+  case errors.Is(err, errCallbackPanic):
+    messenger.AnswerCallback(q.ID, stripHTML(tr(knownLang(q.ChatID), "callback_failed")))
+  default:
+    level := slog.LevelDebug
+    if errors.Is(err, errCallbackForged) || errors.Is(err, errCallbackMalformed) {
+      level = slog.LevelWarn
+    }
+    q.log().Log(context.Background(), level, "callback rejected", "data", q.Data, "err", err)
+    messenger.AnswerCallback(q.ID, stripHTML(tr(knownLang(q.ChatID), "callback_expired")))
+  }
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+  "errors"
+  "strings"
+  "testing"
+)
+
+func TestCallbackRoundTrip(t *testing.T) {
+  setupTest(t)
+  const chatID = 31
+
+  data := encodeCallback(chatID, cbFire, actionSnooze, 42)
+  cb, err := decodeCallback(chatID, data)
+  if err != nil {
+    t.Fatal(err)
+  }
+  a := cb.args()
+  action := a.word(actionAck, actionSnooze)
+  rid := a.int(1, 1e6)
+  if err := a.end(); err != nil || cb.Prefix != cbFire || action != actionSnooze || rid != 42 {
+    t.Fatalf("decoded %+v: %s %d, %v", cb, action, rid, err)
+  }
+
+  tampered := strings.Replace(data, ";42;", ";43;", 1)
+  for name, tc := range map[string]struct {
+    chatID int64
+    data   string
+    want   error
+  }{
+    "other chat":  {chatID + 1, data, errCallbackForged},
+    "tampered":    {chatID, tampered, errCallbackForged},
+    "old version": {chatID, "0" + data[1:], errCallbackExpired},
+    "unsigned":    {chatID, "FIRE", errCallbackExpired},
+  } {
+    if _, err := decodeCallback(tc.chatID, tc.data); !errors.Is(err, tc.want) {
+      t.Errorf("%s: got %v, want %v", name, err, tc.want)
+    }
+  }
+
+  cfg := *config()
+  cfg.Token = "456:other"
+  setConfig(&cfg)
+  if _, err := decodeCallback(chatID, data); !errors.Is(err, errCallbackForged) {
+    t.Errorf("data signed with the old token: got %v", err)
+  }
+}
+
+func TestCallbackArgsRejectExtra(t *testing.T) {
+  setupTest(t)
+  cb, err := decodeCallback(1, encodeCallback(1, cbLang, "en", "zh"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  a := cb.args()
+  a.word("en", "zh")
+  if err := a.end(); !errors.Is(err, errCallbackMalformed) {
+    t.Fatalf("left-over argument: got %v", err)
+  }
+}
+
+func TestHandleCallbackAnswers(t *testing.T) {
+  fm := setupTest(t)
+  const chatID = 32
+  expired := stripHTML(tr("en", "callback_expired"))
+
+  // A calendar day pressed after the wizard was abandoned is stale.
+  stale := sentMessage{ChatID: chatID, ID: 1, Keyboard: CreateCalendar(chatID, 2030, 1)}
+  handleCallback(stale.press(t, "15"))
+  // Data of one chat pressed in another is forged.
+  other := sentMessage{ChatID: chatID + 1, ID: 2, Keyboard: CreateCalendar(chatID, 2030, 1)}
+  handleCallback(other.press(t, "15"))
+
+  if len(fm.answers) != 2 || fm.answers[0] != expired || fm.answers[1] != expired {
+    t.Fatalf("answers %q, want two %q", fm.answers, expired)
+  }
+  if got := fm.messages(chatID); len(got) != 0 {
+    t.Fatalf("rejected buttons sent %d messages", len(got))
+  }
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+  "bytes"
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "log/slog"
+  "mime"
+  "net"
+  "net/http"
+  "net/smtp"
+  "net/url"
+  "strconv"
+  "strings"
+  "syscall"
+  "time"
+)
+
+// --------- Notification Channels ---------
+
+// Channel is an extra delivery target configured by a user with /channels.
+// Reminders go to the chat and, in addition, to every channel of the user
+// (or only to the ones listed in Reminder.Channels).
+type Channel struct {
+  Name   string `json:"name"`
+  Kind   string `json:"kind"`             // "email", "webhook", "ntfy" or "gotify"
+  Target string `json:"target"`           // Email address or endpoint URL
+  Secret string `json:"secret,omitempty"` // HMAC key (webhook) or access token (ntfy, gotify)
+}
+
+// SMTPSettings configures the outgoing mail server used by email channels.
+type SMTPSettings struct {
+  Host     string `json:"host"`
+  Port     int    `json:"port"`
+  Username string `json:"username"`
+  Password string `json:"password"`
+  From     string `json:"from"`
+}
+
+// Notification is what a channel delivers; it is also the JSON body posted
+// to webhook channels.
+type Notification struct {
+  ChatID     int64     `json:"chat_id"`
+  ReminderID int       `json:"reminder_id"`
+  Title      string    `json:"title"`
+  Text       string    `json:"text"`
+  FiredAt    time.Time `json:"fired_at"`
+}
+
+const (
+  channelAttempts   = 5
+  channelBackoff    = 2 * time.Second
+  channelMaxBackoff = time.Minute
+  signatureHeader   = "X-Reminder-Signature"
+)
+
+// channelHTTP dials only public addresses, so that a chat cannot point a
+// channel at the bot's own network. Proxies are not used: the check would
+// see the proxy's address instead of the target's.
+var channelHTTP = &http.Client{
+  Timeout: 15 * time.Second,
+  Transport: &http.Transport{
+    DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: channelDialControl}).DialContext,
+    TLSHandshakeTimeout: 10 * time.Second,
+    MaxIdleConns:        10,
+    IdleConnTimeout:     90 * time.Second,
+  },
+}
+
+// errBlockedAddress is returned for channel targets on a private network.
+var errBlockedAddress = errors.New("address is not reachable for channels")
+
+// cgnat is the carrier-grade NAT range, not covered by IsPrivate.
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// blockedIP reports whether channels may not reach ip: loopback, private,
+// link-local (which includes cloud metadata at 169.254.169.254) and other
+// non-public addresses, unless channel_allow_networks lets it in.
+func blockedIP(ip net.IP) bool {
+  for _, n := range config().ChannelAllowNetworks {
+    if _, allowed, err := net.ParseCIDR(n); err == nil && allowed.Contains(ip) {
+      return false
+    }
+  }
+  return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+    ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || cgnat.Contains(ip)
+}
+
+// channelDialControl refuses connections to blocked addresses. It runs
+// after name resolution, for every connection including redirects.
+func channelDialControl(network, address string, _ syscall.RawConn) error {
+  host, _, err := net.SplitHostPort(address)
+  if err != nil {
+    return err
+  }
+  if ip := net.ParseIP(host); ip == nil || blockedIP(ip) {
+    return errBlockedAddress
+  }
+  return nil
+}
+
+// permanentError marks a delivery failure that retrying cannot fix.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func validChannelName(name string) bool {
+  if name == "" || len(name) > 32 {
+    return false
+  }
+  for _, r := range name {
+    if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+      return false
+    }
+  }
+  return true
+}
+
+// validate checks that a channel is complete and usable.
+func (c Channel) validate() error {
+  if !validChannelName(c.Name) {
+    return fmt.Errorf("invalid name %q", c.Name)
+  }
+  switch c.Kind {
+  case "email":
+    if config().SMTP.Host == "" {
+      return errSMTPNotConfigured
+    }
+    if !strings.Contains(c.Target, "@") || strings.ContainsAny(c.Target, " \r\n<>") {
+      return fmt.Errorf("invalid email address %q", c.Target)
+    }
+  case "webhook", "ntfy", "gotify":
+    u, err := url.Parse(c.Target)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+      return fmt.Errorf("invalid URL %q", c.Target)
+    }
+    // Names are checked again when dialing, after they are resolved.
+    if ip := net.ParseIP(strings.Trim(u.Hostname(), "[]")); ip != nil && blockedIP(ip) || strings.EqualFold(u.Hostname(), "localhost") {
+      return fmt.Errorf("%s: %w", u.Hostname(), errBlockedAddress)
+    }
+    if c.Kind == "gotify" && c.Secret == "" {
+      return fmt.Errorf("gotify needs an application token")
+    }
+  default:
+    return fmt.Errorf("unknown channel kind %q", c.Kind)
+  }
+  return nil
+}
+
+var errSMTPNotConfigured = errors.New("smtp is not configured")
+
+// send performs a single delivery attempt.
+func (c Channel) send(n Notification) error {
+  switch c.Kind {
+  case "email":
+    return sendEmail(c.Target, n)
+  case "webhook":
+    body, _ := json.Marshal(n)
+    req, err := http.NewRequest(http.MethodPost, c.Target, bytes.NewReader(body))
+    if err != nil {
+      return permanentError{err}
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if c.Secret != "" {
+      mac := hmac.New(sha256.New, []byte(c.Secret))
+      mac.Write(body)
+      req.Header.Set(signatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+    }
+    return doChannelRequest(req)
+  case "ntfy":
+    req, err := http.NewRequest(http.MethodPost, c.Target, strings.NewReader(n.Text))
+    if err != nil {
+      return permanentError{err}
+    }
+    req.Header.Set("Title", mimeHeader(n.Title)) // ntfy decodes RFC 2047
+    req.Header.Set("Tags", "alarm_clock")
+    if c.Secret != "" {
+      req.Header.Set("Authorization", "Bearer "+c.Secret)
+    }
+    return doChannelRequest(req)
+  case "gotify":
+    body, _ := json.Marshal(map[string]interface{}{"title": n.Title, "message": n.Text, "priority": 5})
+    req, err := http.NewRequest(http.MethodPost, strings.TrimRight(c.Target, "/")+"/message", bytes.NewReader(body))
+    if err != nil {
+      return permanentError{err}
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("X-Gotify-Key", c.Secret)
+    return doChannelRequest(req)
+  }
+  return permanentError{fmt.Errorf("unknown channel kind %q", c.Kind)}
+}
+
+// doChannelRequest sends req and classifies the response: 5xx, 429 and
+// network errors are retried, other 4xx are permanent.
+func doChannelRequest(req *http.Request) error {
+  resp, err := channelHTTP.Do(req)
+  if errors.Is(err, errBlockedAddress) {
+    // Without the resolved address, which the chat should not learn.
+    return permanentError{fmt.Errorf("%s: %w", req.URL.Host, errBlockedAddress)}
+  }
+  if err != nil {
+    return err
+  }
+  defer resp.Body.Close()
+  if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+    return nil
+  }
+  err = fmt.Errorf("%s: HTTP %d", req.URL.Host, resp.StatusCode)
+  if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+    return err
+  }
+  return permanentError{err}
+}
+
+func sendEmail(to string, n Notification) error {
+  cfg := config().SMTP
+  if cfg.Host == "" {
+    return permanentError{errSMTPNotConfigured}
+  }
+  port := cfg.Port
+  if port == 0 {
+    port = 587
+  }
+  from := cfg.From
+  if from == "" {
+    from = cfg.Username
+  }
+  var msg bytes.Buffer
+  fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\n", from, to, mimeHeader(n.Title))
+  fmt.Fprintf(&msg, "Date: %s\r\nMIME-Version: 1.0\r\n", n.FiredAt.Format(time.RFC1123Z))
+  msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+  msg.WriteString(strings.ReplaceAll(n.Text, "\n", "\r\n"))
+  msg.WriteString("\r\n")
+
+  var auth smtp.Auth
+  if cfg.Username != "" {
+    auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+  }
+  addr := net.JoinHostPort(cfg.Host, strconv.Itoa(port))
+  return smtp.SendMail(addr, auth, from, []string{to}, msg.Bytes())
+}
+
+// mimeHeader encodes a header value as UTF-8 when it is not plain ASCII.
+func mimeHeader(s string) string {
+  s = strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+  return mime.BEncoding.Encode("UTF-8", s)
+}
+
+// deliver sends n through c, retrying transient failures with exponential
+// backoff. Each channel is retried independently of the others.
+func deliver(c Channel, n Notification, attempts int) error {
+  backoff := channelBackoff
+  var err error
+  for i := 1; i <= attempts; i++ {
+    if err = c.send(n); err == nil {
+      return nil
+    }
+    var perm permanentError
+    if errors.As(err, &perm) || i == attempts {
+      break
+    }
+    slog.Warn("channel delivery failed, retrying", "chat_id", n.ChatID, "reminder_id", n.ReminderID, "channel", c.Name, "attempt", i, "err", err, "retry_in", backoff)
+    time.Sleep(backoff)
+    backoff *= 2
+    if backoff > channelMaxBackoff {
+      backoff = channelMaxBackoff
+    }
+  }
+  return err
+}
+
+// reminderChannels returns the channels r should be delivered to.
+func reminderChannels(ud UserData, r Reminder) []Channel {
+  if len(r.Channels) == 0 {
+    return append([]Channel(nil), ud.Channels...)
+  }
+  var out []Channel
+  for _, c := range ud.Channels {
+    for _, name := range r.Channels {
+      if c.Name == name {
+        out = append(out, c)
+      }
+    }
+  }
+  return out
+}
+
+// notifyChannels fans a fired reminder out to the user's extra channels.
+func notifyChannels(chatID int64, r Reminder, text string) {
+  ud := getUserData(chatID)
+  // Cron jobs hold a copy taken at start; pick up later /channels use edits.
+  for _, cur := range ud.Reminders {
+    if cur.ID == r.ID {
+      r = cur
+      break
+    }
+  }
+  n := Notification{
+    ChatID:     chatID,
+    ReminderID: r.ID,
+    Title:      r.Name,
+    Text:       stripHTML(text),
+    FiredAt:    time.Now().UTC(),
+  }
+  for _, c := range reminderChannels(ud, r) {
+    go func(c Channel) {
+      if err := deliver(c, n, channelAttempts); err != nil {
+        metrics.deliveryFailures.inc(c.Kind)
+        recordFailure(chatID, r.ID, c.Name, err)
+      }
+    }(c)
+  }
+}
+
+func findChannel(ud UserData, name string) int {
+  for i, c := range ud.Channels {
+    if c.Name == name {
+      return i
+    }
+  }
+  return -1
+}
+
+// handleChannelsCommand implements /channels and its subcommands.
+func handleChannelsCommand(chatID int64, args string) {
+  ud := getUserData(chatID)
+  f := strings.Fields(args)
+  if len(f) == 0 {
+    if len(ud.Channels) == 0 {
+      sendText(chatID, "channels_empty")
+      return
+    }
+    text := tr(ud.Lang, "channels_header")
+    for _, c := range ud.Channels {
+      text += render("\n• `%s` %s → %s", c.Name, c.Kind, c.Target)
+    }
+    messenger.SendText(chatID, text)
+    return
+  }
+
+  switch f[0] {
+  case "add":
+    if len(f) < 4 {
+      sendText(chatID, "channels_usage")
+      return
+    }
+    c := Channel{Kind: f[1], Name: f[2], Target: f[3]}
+    if len(f) > 4 {
+      c.Secret = f[4]
+    }
+    if findChannel(ud, c.Name) >= 0 {
+      sendText(chatID, "channel_exists", c.Name)
+      return
+    }
+    if err := c.validate(); err != nil {
+      if err == errSMTPNotConfigured {
+        sendText(chatID, "smtp_not_configured")
+      } else {
+        sendText(chatID, "channel_invalid", err.Error())
+      }
+      return
+    }
+    updateUserData(chatID, func(ud *UserData) {
+      ud.Channels = append(ud.Channels, c)
+    })
+    updateSecrets(config())
+    sendText(chatID, "channel_added", c.Name)
+
+  case "remove", "rm":
+    if len(f) < 2 {
+      sendText(chatID, "channels_usage")
+      return
+    }
+    if findChannel(ud, f[1]) < 0 {
+      sendText(chatID, "channel_not_found", f[1])
+      return
+    }
+    // A reminder left without channels would go to all of them.
+    var only []string
+    for i, r := range ud.Reminders {
+      if len(r.Channels) > 0 && len(removeString(r.Channels, f[1])) == 0 {
+        only = append(only, strconv.Itoa(i+1))
+      }
+    }
+    if len(only) > 0 {
+      sendText(chatID, "channel_in_use", f[1], strings.Join(only, ", "))
+      return
+    }
+    updateUserData(chatID, func(ud *UserData) {
+      var kept []Channel
+      for _, c := range ud.Channels {
+        if c.Name != f[1] {
+          kept = append(kept, c)
+        }
+      }
+      ud.Channels = kept
+      list := make([]Reminder, len(ud.Reminders))
+      for j, r := range ud.Reminders {
+        r.Channels = removeString(r.Channels, f[1])
+        list[j] = r
+      }
+      ud.Reminders = list
+    })
+    sendText(chatID, "channel_removed", f[1])
+
+  case "test":
+    if len(f) < 2 {
+      sendText(chatID, "channels_usage")
+      return
+    }
+    i := findChannel(ud, f[1])
+    if i < 0 {
+      sendText(chatID, "channel_not_found", f[1])
+      return
+    }
+    c := ud.Channels[i]
+    n := Notification{ChatID: chatID, Title: "Test", Text: stripHTML(tr(ud.Lang, "channel_test_body")), FiredAt: time.Now().UTC()}
+    go func() {
+      if err := deliver(c, n, 1); err != nil {
+        sendText(chatID, "channel_test_failed", c.Name, err.Error())
+      } else {
+        sendText(chatID, "channel_test_ok", c.Name)
+      }
+    }()
+
+  case "use":
+    // /channels use <reminder#> <name,name|all>
+    if len(f) < 3 {
+      sendText(chatID, "channels_usage")
+      return
+    }
+    idx, err := strconv.Atoi(f[1])
+    if err != nil || idx < 1 || idx > len(ud.Reminders) {
+      sendText(chatID, "invalid_index")
+      return
+    }
+    var names []string
+    if f[2] != "all" {
+      for _, name := range strings.Split(f[2], ",") {
+        if findChannel(ud, name) < 0 {
+          sendText(chatID, "channel_not_found", name)
+          return
+        }
+        names = append(names, name)
+      }
+    }
+    id := ud.Reminders[idx-1].ID
+    updateUserData(chatID, func(ud *UserData) {
+      for j := range ud.Reminders {
+        if ud.Reminders[j].ID == id {
+          ud.Reminders[j].Channels = names
+        }
+      }
+    })
+    sendText(chatID, "channel_use_set", idx, f[2])
+
+  default:
+    sendText(chatID, "channels_usage")
+  }
+}
+
+// removeString returns a new slice without s, leaving list untouched.
+func removeString(list []string, s string) []string {
+  var out []string
+  for _, v := range list {
+    if v != s {
+      out = append(out, v)
+    }
+  }
+  return out
+}
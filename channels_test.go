@@ -0,0 +1,45 @@
+package main
+
+import (
+  "errors"
+  "mime"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+)
+
+func TestNtfyTitleHeader(t *testing.T) {
+  setupTest(t)
+  titles := make(chan string, 1)
+  srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    titles <- r.Header.Get("Title")
+  }))
+  defer srv.Close()
+  c := Channel{Name: "phone", Kind: "ntfy", Target: srv.URL + "/topic"}
+
+  // The test server is on loopback, which channels may not reach.
+  var perm permanentError
+  if err := c.send(Notification{Title: "x", Text: "y"}); !errors.As(err, &perm) || !errors.Is(err, errBlockedAddress) {
+    t.Fatalf("loopback target: got %v, want a permanent errBlockedAddress", err)
+  }
+
+  cfg := *config()
+  cfg.ChannelAllowNetworks = []string{"127.0.0.0/8", "::1/128"}
+  setConfig(&cfg)
+  for _, title := range []string{"Standup", "Zahnarzt\r\nüber Mittag", "交房租"} {
+    if err := c.send(Notification{Title: title, Text: "due"}); err != nil {
+      t.Fatalf("%q: %v", title, err)
+    }
+    got, err := new(mime.WordDecoder).DecodeHeader(<-titles)
+    if err != nil {
+      t.Fatal(err)
+    }
+    want := title
+    if title == "Zahnarzt\r\nüber Mittag" {
+      want = "Zahnarzt  über Mittag"
+    }
+    if got != want {
+      t.Errorf("title %q arrived as %q", want, got)
+    }
+  }
+}
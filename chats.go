@@ -0,0 +1,139 @@
+package main
+
+import (
+  "context"
+  "log/slog"
+  "strconv"
+  "time"
+)
+
+// --------- Inactive Chats ---------
+
+// A chat becomes inactive when the bot can no longer send to it: the user
+// blocked the bot, deleted their account, or the group is gone. Its jobs
+// are suspended and the chat is purged after a grace period, unless the
+// user comes back with /start first.
+
+const (
+  defaultInactiveGrace  = 30 * 24 * time.Hour
+  inactiveSweepInterval = time.Hour
+)
+
+// deactivateChat marks the chat unreachable and suspends its schedules.
+// It is called by the messenger when a send fails for good.
+func deactivateChat(chatID int64, cause error) {
+  var ids []int
+  changed := false
+  store.mu.Lock()
+  if ud, ok := store.Reminder[strconv.FormatInt(chatID, 10)]; ok && ud.InactiveSince == nil {
+    now := time.Now().UTC()
+    ud.InactiveSince = &now
+    for _, r := range ud.Reminders {
+      ids = append(ids, r.ID)
+    }
+    changed = true
+  }
+  store.mu.Unlock()
+  if !changed {
+    return
+  }
+  saveStorage()
+  for _, id := range ids {
+    cancelJob(id)
+  }
+  slog.Warn("chat unreachable, reminders suspended", "chat_id", chatID, "reminders", len(ids), "err", cause)
+}
+
+// reactivateChat clears the inactive mark and reschedules the chat's
+// reminders. It reports whether the chat was inactive.
+func reactivateChat(chatID int64) bool {
+  wasInactive := false
+  updateUserData(chatID, func(ud *UserData) {
+    wasInactive = ud.InactiveSince != nil
+    ud.InactiveSince = nil
+  })
+  if !wasInactive {
+    return false
+  }
+  ud := getUserData(chatID)
+  for _, r := range ud.Reminders {
+    scheduleReminder(chatID, r)
+  }
+  slog.Info("chat active again, reminders resumed", "chat_id", chatID, "reminders", len(ud.Reminders))
+  return true
+}
+
+// purgeChat deletes everything stored for the chat, including its
+// session, API tokens, calendar feeds and a pending import, and stops its
+// jobs. A chat may have some of these without any reminder data, so each
+// is cleared on its own. It reports whether anything was deleted.
+func purgeChat(chatID int64) bool {
+  key := strconv.FormatInt(chatID, 10)
+  store.mu.Lock()
+  ud, found := store.Reminder[key]
+  var ids []int
+  if found {
+    for _, r := range ud.Reminders {
+      ids = append(ids, r.ID)
+    }
+    delete(store.Reminder, key)
+  }
+  if _, ok := store.Sessions[key]; ok {
+    delete(store.Sessions, key)
+    found = true
+  }
+  if revokeTokensLocked(chatID)+revokeFeedLocked(chatID) > 0 {
+    found = true
+  }
+  store.mu.Unlock()
+  importMu.Lock()
+  if _, ok := pendingImports[chatID]; ok {
+    delete(pendingImports, chatID)
+    found = true
+  }
+  importMu.Unlock()
+  if !found {
+    return false
+  }
+  saveStorage()
+  for _, id := range ids {
+    cancelJob(id)
+  }
+  return true
+}
+
+// purgeInactiveChats deletes chats that have been unreachable for longer
+// than the configured grace period, until ctx is done.
+func purgeInactiveChats(ctx context.Context) {
+  tick := time.NewTicker(inactiveSweepInterval)
+  defer tick.Stop()
+  for {
+    grace := config().inactiveGrace()
+    var expired []int64
+    store.mu.Lock()
+    for key, ud := range store.Reminder {
+      if ud.InactiveSince == nil || time.Since(*ud.InactiveSince) <= grace {
+        continue
+      }
+      id, err := strconv.ParseInt(key, 10, 64)
+      if err != nil {
+        slog.Error("invalid chat key in store", "key", key, "err", err)
+        continue
+      }
+      expired = append(expired, id)
+    }
+    store.mu.Unlock()
+    // The same path as /forgetme and /purge, so nothing is left behind.
+    for _, id := range expired {
+      if purgeChat(id) {
+        slog.Info("inactive chat purged", "chat_id", id, "grace", grace)
+      }
+    }
+
+    select {
+    case <-ctx.Done():
+      return
+    case <-tick.C:
+    }
+  }
+}
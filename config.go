@@ -0,0 +1,431 @@
+package main
+
+import (
+  "encoding/json"
+  "errors"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "net"
+  "net/url"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "sync/atomic"
+  "time"
+)
+
+// --------- Config ---------
+
+// Config is read from the config file, then overridden by REMINDERBOT_*
+// environment variables, then by command-line flags.
+type Config struct {
+  Token   string          `json:"token"`
+  Mode    string          `json:"mode,omitempty"` // "polling" (default), "webhook" or "console"
+  Webhook WebhookSettings `json:"webhook"`
+  SMTP    SMTPSettings    `json:"smtp"` // Outgoing mail server for email channels
+  Access  AccessSettings  `json:"access"` // Who may use the bot
+  Limits  LimitSettings   `json:"limits"` // Per-chat quotas and rate limits
+
+  DataPath    string  `json:"data_path,omitempty"`    // Storage file, default "reminder.json"
+  Storage     string  `json:"storage,omitempty"`      // "json" (default) or "memory"
+  LogLevel    string  `json:"log_level,omitempty"`    // "trace", "debug", "info" (default), "warn" or "error"
+  LogFormat   string  `json:"log_format,omitempty"`   // "text" (default) or "json"
+  Debug       bool    `json:"debug,omitempty"`        // Log raw Bot API traffic, at trace level
+  DefaultLang string  `json:"default_lang,omitempty"` // Language of new chats, "en" (default) or "zh"
+  DefaultUTC  int     `json:"default_utc,omitempty"`  // UTC offset of new chats
+  AdminIDs    []int64 `json:"admin_ids,omitempty"`    // Telegram user IDs allowed to run admin commands
+  ChannelAllowNetworks []string `json:"channel_allow_networks,omitempty"` // CIDRs channels may reach although private, e.g. a LAN ntfy server
+  MetricsListen string `json:"metrics_listen,omitempty"` // Address serving /healthz, /readyz and /metrics, off when empty
+  APIListen     string `json:"api_listen,omitempty"`     // Address serving the HTTP API, off when empty
+  APIPublicURL  string `json:"api_public_url,omitempty"` // URL the API is reachable at from outside, for calendar feed links
+  LocalesDir    string `json:"locales_dir,omitempty"`    // Directory of <lang>.json message overrides
+  AuditLog      string `json:"audit_log,omitempty"`      // Admin audit log, default audit.log next to data_path; "-" to only log it
+
+  NotifyLeadMinutes int `json:"notify_lead_minutes,omitempty"` // Minutes before a one-time appointment to notify, default 10
+  PollTimeout       int `json:"poll_timeout,omitempty"`        // Long polling timeout in seconds, default 60
+  ShutdownTimeout   int `json:"shutdown_timeout,omitempty"`    // Seconds to finish shutdown, default 8
+  Workers           int `json:"workers,omitempty"`             // Update handler goroutines, default 8
+  InactiveGraceDays int `json:"inactive_grace_days,omitempty"` // Days before an unreachable chat is purged, default 30
+}
+
+// currentConfig holds the configuration in effect. /reload swaps it
+// while other goroutines read it, so it is only accessed through config
+// and setConfig.
+var currentConfig atomic.Pointer[Config]
+
+func init() {
+  // Defaults until main loads the real configuration.
+  currentConfig.Store(defaultConfig())
+}
+
+// config returns the configuration in effect. It must not be modified.
+func config() *Config {
+  return currentConfig.Load()
+}
+
+func setConfig(c *Config) {
+  currentConfig.Store(c)
+}
+
+const (
+  defaultConfigPath  = "config.json"
+  defaultNotifyLead  = 10 * time.Minute
+  defaultPollTimeout = 60
+  envPrefix          = "REMINDERBOT_"
+)
+
+func defaultConfig() *Config {
+  return &Config{
+    Mode:        "polling",
+    DataPath:    "reminder.json",
+    Storage:     "json",
+    LogLevel:    "info",
+    LogFormat:   "text",
+    DefaultLang: "en",
+  }
+}
+
+// configField describes one setting that can be overridden from the
+// environment and the command line. Its environment variable and flag
+// names are derived from the JSON name: "webhook.secret_token" becomes
+// REMINDERBOT_WEBHOOK_SECRET_TOKEN and -webhook-secret-token.
+type configField struct {
+  name  string
+  usage string
+  ptr   func(c *Config) interface{}
+}
+
+var configFields = []configField{
+  {"token", "Telegram bot token", func(c *Config) interface{} { return &c.Token }},
+  {"mode", "polling, webhook or console", func(c *Config) interface{} { return &c.Mode }},
+  {"data_path", "storage file", func(c *Config) interface{} { return &c.DataPath }},
+  {"storage", "storage backend: json or memory", func(c *Config) interface{} { return &c.Storage }},
+  {"log_level", "trace, debug, info, warn or error", func(c *Config) interface{} { return &c.LogLevel }},
+  {"log_format", "text or json", func(c *Config) interface{} { return &c.LogFormat }},
+  {"debug", "log raw Bot API traffic at trace level", func(c *Config) interface{} { return &c.Debug }},
+  {"default_lang", "language of new chats: en or zh", func(c *Config) interface{} { return &c.DefaultLang }},
+  {"default_utc", "UTC offset of new chats", func(c *Config) interface{} { return &c.DefaultUTC }},
+  {"admin_ids", "comma-separated admin user IDs", func(c *Config) interface{} { return &c.AdminIDs }},
+  {"channel_allow_networks", "comma-separated private networks channels may reach", func(c *Config) interface{} { return &c.ChannelAllowNetworks }},
+  {"metrics_listen", "address for /healthz, /readyz and /metrics", func(c *Config) interface{} { return &c.MetricsListen }},
+  {"api_listen", "address for the HTTP API", func(c *Config) interface{} { return &c.APIListen }},
+  {"api_public_url", "public URL of the HTTP API", func(c *Config) interface{} { return &c.APIPublicURL }},
+  {"locales_dir", "directory of <lang>.json message overrides", func(c *Config) interface{} { return &c.LocalesDir }},
+  {"audit_log", "admin audit log file, - to disable", func(c *Config) interface{} { return &c.AuditLog }},
+  {"notify_lead_minutes", "minutes before an appointment to notify", func(c *Config) interface{} { return &c.NotifyLeadMinutes }},
+  {"poll_timeout", "long polling timeout in seconds", func(c *Config) interface{} { return &c.PollTimeout }},
+  {"shutdown_timeout", "seconds to finish shutdown", func(c *Config) interface{} { return &c.ShutdownTimeout }},
+  {"workers", "update handler goroutines", func(c *Config) interface{} { return &c.Workers }},
+  {"inactive_grace_days", "days before an unreachable chat is purged", func(c *Config) interface{} { return &c.InactiveGraceDays }},
+  {"webhook.url", "public HTTPS webhook URL", func(c *Config) interface{} { return &c.Webhook.URL }},
+  {"webhook.listen", "webhook listen address", func(c *Config) interface{} { return &c.Webhook.Listen }},
+  {"webhook.secret_token", "webhook secret token", func(c *Config) interface{} { return &c.Webhook.SecretToken }},
+  {"webhook.cert_file", "webhook TLS certificate", func(c *Config) interface{} { return &c.Webhook.CertFile }},
+  {"webhook.key_file", "webhook TLS key", func(c *Config) interface{} { return &c.Webhook.KeyFile }},
+  {"webhook.upload_cert", "upload the certificate to Telegram", func(c *Config) interface{} { return &c.Webhook.UploadCert }},
+  {"webhook.max_connections", "webhook max connections", func(c *Config) interface{} { return &c.Webhook.MaxConnections }},
+  {"access.policy", "open, allowlist or invite", func(c *Config) interface{} { return &c.Access.Policy }},
+  {"access.allow", "comma-separated user or chat IDs to let in", func(c *Config) interface{} { return &c.Access.Allow }},
+  {"access.deny", "comma-separated user or chat IDs to turn away", func(c *Config) interface{} { return &c.Access.Deny }},
+  {"limits.max_reminders", "reminders a chat may keep", func(c *Config) interface{} { return &c.Limits.MaxReminders }},
+  {"limits.max_text_length", "characters in a reminder text", func(c *Config) interface{} { return &c.Limits.MaxTextLength }},
+  {"limits.min_cron_interval", "minutes between cron fires", func(c *Config) interface{} { return &c.Limits.MinCronInterval }},
+  {"limits.fires_per_hour", "notifications per chat and hour", func(c *Config) interface{} { return &c.Limits.FiresPerHour }},
+  {"limits.commands_per_minute", "commands per chat and minute", func(c *Config) interface{} { return &c.Limits.CommandsPerMinute }},
+  {"smtp.host", "SMTP server host", func(c *Config) interface{} { return &c.SMTP.Host }},
+  {"smtp.port", "SMTP server port", func(c *Config) interface{} { return &c.SMTP.Port }},
+  {"smtp.username", "SMTP user name", func(c *Config) interface{} { return &c.SMTP.Username }},
+  {"smtp.password", "SMTP password", func(c *Config) interface{} { return &c.SMTP.Password }},
+  {"smtp.from", "SMTP sender address", func(c *Config) interface{} { return &c.SMTP.From }},
+}
+
+func (f configField) env() string {
+  return envPrefix + strings.ToUpper(strings.ReplaceAll(f.name, ".", "_"))
+}
+
+func (f configField) flag() string {
+  return strings.NewReplacer(".", "-", "_", "-").Replace(f.name)
+}
+
+// setField parses value into the field ptr points to.
+func setField(ptr interface{}, value string) error {
+  switch p := ptr.(type) {
+  case *string:
+    *p = value
+  case *int:
+    n, err := strconv.Atoi(strings.TrimSpace(value))
+    if err != nil {
+      return err
+    }
+    *p = n
+  case *bool:
+    b, err := strconv.ParseBool(strings.TrimSpace(value))
+    if err != nil {
+      return err
+    }
+    *p = b
+  case *[]int64:
+    var ids []int64
+    for _, s := range strings.Split(value, ",") {
+      if s = strings.TrimSpace(s); s == "" {
+        continue
+      }
+      id, err := strconv.ParseInt(s, 10, 64)
+      if err != nil {
+        return err
+      }
+      ids = append(ids, id)
+    }
+    *p = ids
+  case *[]string:
+    var list []string
+    for _, s := range strings.Split(value, ",") {
+      if s = strings.TrimSpace(s); s != "" {
+        list = append(list, s)
+      }
+    }
+    *p = list
+  }
+  return nil
+}
+
+// flagValue records a flag until the file and environment are applied.
+type flagValue struct {
+  value  string
+  isBool bool
+}
+
+func (v *flagValue) String() string     { return v.value }
+func (v *flagValue) Set(s string) error { v.value = s; return nil }
+func (v *flagValue) IsBoolFlag() bool   { return v.isBool }
+
+// loadConfig builds the configuration from the config file, the
+// environment and args, in that order of precedence. The file is optional
+// unless its path was given explicitly with -config or REMINDERBOT_CONFIG.
+func loadConfig(args []string) (*Config, error) {
+  fs := flag.NewFlagSet("reminder-bot", flag.ContinueOnError)
+  path := fs.String("config", defaultConfigPath, "config file (env "+envPrefix+"CONFIG)")
+  flags := make(map[string]*flagValue)
+  for _, f := range configFields {
+    _, isBool := f.ptr(&Config{}).(*bool)
+    v := &flagValue{isBool: isBool}
+    flags[f.name] = v
+    fs.Var(v, f.flag(), f.usage+" (env "+f.env()+")")
+  }
+  if err := fs.Parse(args); err != nil {
+    return nil, err
+  }
+  explicit := false
+  fs.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "config" })
+  if p, ok := os.LookupEnv(envPrefix + "CONFIG"); ok && !explicit {
+    *path, explicit = p, true
+  }
+
+  cfg := defaultConfig()
+  sources := make(map[string]string) // Where each overridden field was set
+  bs, err := ioutil.ReadFile(*path)
+  switch {
+  case err == nil:
+    if err := json.Unmarshal(bs, cfg); err != nil {
+      return nil, fmt.Errorf("%s 格式错误: %w", *path, err)
+    }
+  case errors.Is(err, os.ErrNotExist) && !explicit:
+    // Configuration may come from the environment and flags alone.
+  default:
+    return nil, err
+  }
+
+  for _, f := range configFields {
+    if v, ok := os.LookupEnv(f.env()); ok {
+      if err := setField(f.ptr(cfg), v); err != nil {
+        return nil, fmt.Errorf("%s 无效: %q，请检查环境变量 %s", f.name, v, f.env())
+      }
+      sources[f.name] = "环境变量 " + f.env()
+    }
+  }
+  var flagErr error
+  fs.Visit(func(fl *flag.Flag) {
+    for _, f := range configFields {
+      if fl.Name == f.flag() && flagErr == nil {
+        if err := setField(f.ptr(cfg), flags[f.name].value); err != nil {
+          flagErr = fmt.Errorf("%s 无效: %q，请检查参数 -%s", f.name, flags[f.name].value, f.flag())
+        }
+        sources[f.name] = "参数 -" + f.flag()
+      }
+    }
+  })
+  if flagErr != nil {
+    return nil, flagErr
+  }
+
+  source := func(name string) string {
+    if s, ok := sources[name]; ok {
+      return s
+    }
+    return *path
+  }
+  if err := cfg.validate(source); err != nil {
+    return nil, err
+  }
+  return cfg, nil
+}
+
+// fieldError is a validation error of one field, so that the message can
+// name where that field was set.
+type fieldError struct {
+  Field string
+  Msg   string
+}
+
+func (e *fieldError) Error() string { return e.Msg }
+
+func fieldErrorf(field, format string, a ...interface{}) error {
+  return &fieldError{Field: field, Msg: fmt.Sprintf(format, a...)}
+}
+
+// validate checks every field and fills in defaults for empty ones.
+// source names where a field was set, for error messages.
+func (c *Config) validate(source func(name string) string) error {
+  // withSource names where the field of a fieldError was set.
+  withSource := func(err error) error {
+    var fe *fieldError
+    if errors.As(err, &fe) {
+      return fmt.Errorf("%w，请检查 %s", err, source(fe.Field))
+    }
+    return err
+  }
+  for _, f := range []struct {
+    name string
+    n    int
+  }{
+    {"notify_lead_minutes", c.NotifyLeadMinutes},
+    {"poll_timeout", c.PollTimeout},
+    {"shutdown_timeout", c.ShutdownTimeout},
+    {"workers", c.Workers},
+    {"inactive_grace_days", c.InactiveGraceDays},
+    {"limits.max_reminders", c.Limits.MaxReminders},
+    {"limits.max_text_length", c.Limits.MaxTextLength},
+    {"limits.min_cron_interval", c.Limits.MinCronInterval},
+    {"limits.fires_per_hour", c.Limits.FiresPerHour},
+    {"limits.commands_per_minute", c.Limits.CommandsPerMinute},
+  } {
+    if f.n < 0 {
+      return fmt.Errorf("%s 不能为负数，请检查 %s", f.name, source(f.name))
+    }
+  }
+  if c.DataPath == "" {
+    c.DataPath = "reminder.json"
+  }
+  if c.AuditLog == "" {
+    c.AuditLog = filepath.Join(filepath.Dir(c.DataPath), "audit.log")
+  }
+  switch c.Storage {
+  case "":
+    c.Storage = "json"
+  case "json", "memory":
+  default:
+    return fmt.Errorf("storage 无效: %q（可选 json 或 memory），请检查 %s", c.Storage, source("storage"))
+  }
+  switch c.LogLevel {
+  case "":
+    c.LogLevel = "info"
+  case "trace", "debug", "info", "warn", "error":
+  default:
+    return fmt.Errorf("log_level 无效: %q（可选 trace、debug、info、warn 或 error），请检查 %s", c.LogLevel, source("log_level"))
+  }
+  switch c.LogFormat {
+  case "":
+    c.LogFormat = "text"
+  case "text", "json":
+  default:
+    return fmt.Errorf("log_format 无效: %q（可选 text 或 json），请检查 %s", c.LogFormat, source("log_format"))
+  }
+  switch c.DefaultLang {
+  case "":
+    c.DefaultLang = "en"
+  case "en", "zh":
+  default:
+    return fmt.Errorf("default_lang 无效: %q（可选 en 或 zh），请检查 %s", c.DefaultLang, source("default_lang"))
+  }
+  if c.DefaultUTC < -12 || c.DefaultUTC > 14 {
+    return fmt.Errorf("default_utc 超出范围: %d（-12 到 +14），请检查 %s", c.DefaultUTC, source("default_utc"))
+  }
+  if err := c.Access.validate(); err != nil {
+    return withSource(err)
+  }
+  for _, id := range c.AdminIDs {
+    if id <= 0 {
+      return fmt.Errorf("admin_ids 包含无效的用户 ID: %d，请检查 %s", id, source("admin_ids"))
+    }
+  }
+  for _, n := range c.ChannelAllowNetworks {
+    if _, _, err := net.ParseCIDR(n); err != nil {
+      return fmt.Errorf("channel_allow_networks 包含无效的网段: %q（如 192.168.1.0/24），请检查 %s", n, source("channel_allow_networks"))
+    }
+  }
+  if c.APIPublicURL != "" {
+    u, err := url.Parse(c.APIPublicURL)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+      return fmt.Errorf("api_public_url 必须是 http 或 https 地址: %q，请检查 %s", c.APIPublicURL, source("api_public_url"))
+    }
+  }
+
+  switch c.Mode {
+  case "console":
+    // The console front end never talks to Telegram.
+    return nil
+  case "", "polling":
+    c.Mode = "polling"
+  case "webhook":
+    if err := c.Webhook.validate(); err != nil {
+      return withSource(err)
+    }
+  default:
+    return fmt.Errorf("mode 无效: %q（可选 polling、webhook 或 console），请检查 %s", c.Mode, source("mode"))
+  }
+  if c.Token == "" {
+    return fmt.Errorf("token 为空，请检查 %s（也可用环境变量 %sTOKEN 或参数 -token）", source("token"), envPrefix)
+  }
+  return nil
+}
+
+func (c *Config) shutdownTimeout() time.Duration {
+  if c.ShutdownTimeout <= 0 {
+    return defaultShutdownTimeout
+  }
+  return time.Duration(c.ShutdownTimeout) * time.Second
+}
+
+func (c *Config) inactiveGrace() time.Duration {
+  if c.InactiveGraceDays <= 0 {
+    return defaultInactiveGrace
+  }
+  return time.Duration(c.InactiveGraceDays) * 24 * time.Hour
+}
+
+// notifyLead is how long before a one-time appointment the notification
+// fires.
+func (c *Config) notifyLead() time.Duration {
+  if c.NotifyLeadMinutes <= 0 {
+    return defaultNotifyLead
+  }
+  return time.Duration(c.NotifyLeadMinutes) * time.Minute
+}
+
+func (c *Config) pollTimeout() int {
+  if c.PollTimeout <= 0 {
+    return defaultPollTimeout
+  }
+  return c.PollTimeout
+}
+
+func (c *Config) isAdmin(userID int64) bool {
+  for _, id := range c.AdminIDs {
+    if id == userID {
+      return true
+    }
+  }
+  return false
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+func TestConfigErrorNamesSource(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "config.json")
+  for _, tc := range []struct {
+    name string
+    file string
+    env  map[string]string
+    args []string
+    want string // Field and where it was set
+  }{
+    {"policy in file", `{"access": {"policy": "closed"}}`, nil, nil, "access.policy 无效: \"closed\"（可选 open、allowlist 或 invite），请检查 " + path},
+    {"deny in env", `{"access": {"policy": "invite"}}`, map[string]string{"REMINDERBOT_ACCESS_DENY": "5,0"}, nil, "access.deny 包含无效的 ID: 0，请检查 环境变量 REMINDERBOT_ACCESS_DENY"},
+    {"allow in flag", `{}`, nil, []string{"-access-allow", "0"}, "access.allow 包含无效的 ID: 0，请检查 参数 -access-allow"},
+    {"webhook url in env", `{"mode": "webhook"}`, map[string]string{"REMINDERBOT_WEBHOOK_URL": "http://bot.example.com"}, nil, "webhook.url 必须是 https 地址: \"http://bot.example.com\"，请检查 环境变量 REMINDERBOT_WEBHOOK_URL"},
+  } {
+    t.Run(tc.name, func(t *testing.T) {
+      if err := os.WriteFile(path, []byte(tc.file), 0644); err != nil {
+        t.Fatal(err)
+      }
+      t.Setenv("REMINDERBOT_CONFIG", path)
+      t.Setenv("REMINDERBOT_TOKEN", "123:test")
+      for k, v := range tc.env {
+        t.Setenv(k, v)
+      }
+      _, err := loadConfig(tc.args)
+      if err == nil || !strings.Contains(err.Error(), tc.want) {
+        t.Fatalf("got %v, want %q", err, tc.want)
+      }
+    })
+  }
+}
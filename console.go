@@ -0,0 +1,225 @@
+package main
+
+import (
+  "bufio"
+  "context"
+  "fmt"
+  "io"
+  "log/slog"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "sync"
+)
+
+// --------- Console Adapter ---------
+
+// consoleChatID is the chat the console front end plays.
+const consoleChatID int64 = 1
+
+// consoleMessenger prints outgoing messages to a terminal. Keyboard
+// buttons are numbered row by row; type ":<n>" to press button n of the
+// last keyboard or ":<msg>.<n>" for an older message, and "@<path>" to
+// send a local file.
+type consoleMessenger struct {
+  mu        sync.Mutex
+  w         io.Writer
+  nextMsgID int
+  nextCbID  int
+  lastKbMsg int
+  keyboards map[int]Keyboard
+}
+
+func newConsoleMessenger(w io.Writer) *consoleMessenger {
+  return &consoleMessenger{w: w, keyboards: make(map[int]Keyboard)}
+}
+
+func (c *consoleMessenger) print(chatID int64, msgID int, verb, text string, kb Keyboard) {
+  fmt.Fprintf(c.w, "\n[chat %d #%d %s]\n%s\n", chatID, msgID, verb, stripHTML(text))
+  n := 1
+  for _, row := range kb {
+    var cells []string
+    for _, b := range row {
+      cells = append(cells, fmt.Sprintf("(%d) %s", n, b.Text))
+      n++
+    }
+    fmt.Fprintf(c.w, "  %s\n", strings.Join(cells, "  "))
+  }
+}
+
+func (c *consoleMessenger) SendText(chatID int64, text string) (int, error) {
+  return c.SendKeyboard(chatID, text, nil)
+}
+
+func (c *consoleMessenger) SendKeyboard(chatID int64, text string, kb Keyboard) (int, error) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.nextMsgID++
+  id := c.nextMsgID
+  c.setKeyboard(id, kb)
+  c.print(chatID, id, "sent", text, kb)
+  return id, nil
+}
+
+func (c *consoleMessenger) SendSilent(chatID int64, text string, kb Keyboard) (int, error) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.nextMsgID++
+  id := c.nextMsgID
+  c.setKeyboard(id, kb)
+  c.print(chatID, id, "sent silently", text, kb)
+  return id, nil
+}
+
+// SendDocument prints the file's name and size, then its content.
+func (c *consoleMessenger) SendDocument(chatID int64, name string, data []byte, caption string) (int, error) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.nextMsgID++
+  id := c.nextMsgID
+  c.print(chatID, id, "sent", fmt.Sprintf("📎 %s (%d bytes)\n%s", name, len(data), caption), nil)
+  fmt.Fprintf(c.w, "%s\n", data)
+  return id, nil
+}
+
+func (c *consoleMessenger) EditMessage(chatID int64, msgID int, text string, kb Keyboard) error {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.setKeyboard(msgID, kb)
+  c.print(chatID, msgID, "edited", text, kb)
+  return nil
+}
+
+func (c *consoleMessenger) EditKeyboard(chatID int64, msgID int, kb Keyboard) error {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.setKeyboard(msgID, kb)
+  if len(kb) == 0 {
+    c.print(chatID, msgID, "keyboard", "(removed)", nil)
+  } else {
+    c.print(chatID, msgID, "keyboard", "", kb)
+  }
+  return nil
+}
+
+func (c *consoleMessenger) PinMessage(chatID int64, msgID int) error {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.print(chatID, msgID, "pinned", "", nil)
+  return nil
+}
+
+func (c *consoleMessenger) UnpinMessage(chatID int64, msgID int) error {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.print(chatID, msgID, "unpinned", "", nil)
+  return nil
+}
+
+func (c *consoleMessenger) AnswerCallback(callbackID, text string) error {
+  if text != "" {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    fmt.Fprintf(c.w, "  <%s>\n", text)
+  }
+  return nil
+}
+
+func (c *consoleMessenger) setKeyboard(msgID int, kb Keyboard) {
+  if len(kb) == 0 {
+    delete(c.keyboards, msgID)
+    return
+  }
+  c.keyboards[msgID] = kb
+  c.lastKbMsg = msgID
+}
+
+// press resolves ":<n>" or ":<msg>.<n>" to a callback.
+func (c *consoleMessenger) press(chatID int64, ref string) (*InCallback, error) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  msgID, btn := c.lastKbMsg, ref
+  if i := strings.Index(ref, "."); i >= 0 {
+    id, err := strconv.Atoi(ref[:i])
+    if err != nil {
+      return nil, fmt.Errorf("bad message id %q", ref[:i])
+    }
+    msgID, btn = id, ref[i+1:]
+  }
+  n, err := strconv.Atoi(btn)
+  if err != nil || n < 1 {
+    return nil, fmt.Errorf("bad button number %q", btn)
+  }
+  kb, ok := c.keyboards[msgID]
+  if !ok {
+    return nil, fmt.Errorf("message #%d has no keyboard", msgID)
+  }
+  for _, row := range kb {
+    if n <= len(row) {
+      c.nextCbID++
+      return &InCallback{
+        ID:        "console-" + strconv.Itoa(c.nextCbID),
+        ChatID:    chatID,
+        UserID:    chatID,
+        MessageID: msgID,
+        Data:      row[n-1].Data,
+      }, nil
+    }
+    n -= len(row)
+  }
+  return nil, fmt.Errorf("message #%d has no button %s", msgID, btn)
+}
+
+// runConsole reads lines from r and feeds them to the handlers as if they
+// came from consoleChatID, until r is exhausted or ctx is cancelled.
+func runConsole(ctx context.Context, c *consoleMessenger, r io.Reader) {
+  lines := make(chan string)
+  go func() {
+    sc := bufio.NewScanner(r)
+    for sc.Scan() {
+      lines <- sc.Text()
+    }
+    close(lines)
+  }()
+  msgID := 0
+  for {
+    select {
+    case <-ctx.Done():
+      return
+    case line, ok := <-lines:
+      if !ok {
+        return
+      }
+      line = strings.TrimSpace(line)
+      if line == "" {
+        continue
+      }
+      if strings.HasPrefix(line, ":") {
+        q, err := c.press(consoleChatID, line[1:])
+        if err != nil {
+          fmt.Fprintf(c.w, "  <%v>\n", err)
+          continue
+        }
+        handleCallback(q)
+        continue
+      }
+      msgID++
+      in := &InMessage{ChatID: consoleChatID, UserID: consoleChatID, MessageID: msgID, Text: line}
+      if strings.HasPrefix(line, "@") {
+        path := line[1:]
+        fi, err := os.Stat(path)
+        if err != nil {
+          fmt.Fprintf(c.w, "  <%v>\n", err)
+          continue
+        }
+        in.Text = ""
+        in.Document = &InDocument{Name: filepath.Base(path), Size: int(fi.Size()), Fetch: func() ([]byte, error) {
+          return os.ReadFile(path)
+        }}
+      }
+      slog.Debug("message received", "chat_id", consoleChatID, "text", userText(in.Text))
+      handleMessage(in)
+    }
+  }
+}
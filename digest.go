@@ -0,0 +1,342 @@
+package main
+
+import (
+  "context"
+  "log/slog"
+  "math"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+  "unicode/utf8"
+
+  "github.com/gorhill/cronexpr"
+)
+
+// --------- Agenda ---------
+
+// /today and /week list what is due, and chats can subscribe to the same
+// list as a morning digest and a Sunday evening overview. Times are shown
+// in the chat's UTC offset, cron occurrences included.
+
+// DigestSettings is a chat's digest subscription.
+type DigestSettings struct {
+  Time       string `json:"time,omitempty"`        // "15:04" in the chat's offset; the daily digest is off when empty
+  Weekly     bool   `json:"weekly,omitempty"`      // Sunday evening overview of the coming week
+  LastDaily  string `json:"last_daily,omitempty"`  // Local date of the last daily digest
+  LastWeekly string `json:"last_weekly,omitempty"` // Local date of the last weekly overview
+}
+
+const (
+  digestInterval     = time.Minute
+  digestCatchUp      = 3 * time.Hour // A digest missed by longer, e.g. while down, is skipped
+  weeklyDigestHour   = 18
+  maxAgendaItems     = 60
+  maxAgendaButtons   = 8
+  agendaPostpone     = time.Hour
+  agendaButtonLength = 24 // Runes of the reminder name shown on a button
+)
+
+// agendaItem is one occurrence of a reminder.
+type agendaItem struct {
+  At time.Time // Wall clock in the chat's offset, in the UTC location
+  R  Reminder
+}
+
+// agenda returns the occurrences due from now until now plus span, in
+// order, and how many more there are beyond maxAgendaItems.
+func agenda(ud UserData, now time.Time, span time.Duration) ([]agendaItem, int) {
+  offset := time.Duration(ud.UTC) * time.Hour
+  end := now.Add(span)
+  var items []agendaItem
+  for _, r := range ud.Reminders {
+    if r.Paused || len(r.Failures) > 0 && r.CronExpr == "" {
+      continue
+    }
+    if r.CronExpr == "" {
+      at, err := reminderWallClock(r)
+      if err != nil {
+        continue
+      }
+      if evt := at.Add(-offset); !evt.Before(now) && evt.Before(end) {
+        items = append(items, agendaItem{at, r})
+      }
+      continue
+    }
+    loc, err := time.LoadLocation(r.TZ)
+    if err != nil {
+      continue
+    }
+    expr, err := cronexpr.Parse(r.CronOriginal)
+    if err != nil {
+      continue
+    }
+    for t, n := expr.Next(now.In(loc)), 0; !t.IsZero() && t.Before(end) && n <= maxAgendaItems; t, n = expr.Next(t), n+1 {
+      items = append(items, agendaItem{t.UTC().Add(offset), r})
+    }
+  }
+  sort.SliceStable(items, func(i, j int) bool { return items[i].At.Before(items[j].At) })
+  if len(items) > maxAgendaItems {
+    return items[:maxAgendaItems], len(items) - maxAgendaItems
+  }
+  return items, 0
+}
+
+// agendaMessage renders the agenda for span, grouped by hour for a day
+// and by day for a week, with buttons to edit or postpone the one-time
+// reminders in it.
+func agendaMessage(chatID int64, header string, span time.Duration) (string, Keyboard) {
+  ud := getUserData(chatID)
+  items, more := agenda(ud, time.Now().UTC(), span)
+  text := header
+  if len(items) == 0 {
+    return text + "\n\n" + tr(ud.Lang, "agenda_empty"), nil
+  }
+  byDay := span > 24*time.Hour
+  group := ""
+  var kb Keyboard
+  seen := make(map[int]bool)
+  for _, it := range items {
+    var g string
+    if byDay {
+      g = "*" + LocalDate(it.At).Localize(ud.Lang) + "*"
+    } else {
+      g = "*" + LocalTime(it.At.Truncate(time.Hour)).Localize(ud.Lang) + "*"
+    }
+    if g != group {
+      group = g
+      text += "\n\n" + markup(g)
+    }
+    line := render("\n   %s  %s", LocalTime(it.At).Localize(ud.Lang), it.R.Name)
+    if it.R.CronExpr != "" {
+      line += " 🔁"
+    }
+    text += line
+    if it.R.CronExpr == "" && !seen[it.R.ID] && len(kb) < maxAgendaButtons {
+      seen[it.R.ID] = true
+      kb = append(kb, newRow(
+        callbackButton(chatID, "✏️ "+shorten(it.R.Name, agendaButtonLength), cbAgenda, "edit", it.R.ID),
+        callbackButton(chatID, plainText(ud.Lang, "btn_postpone"), cbAgenda, "later", it.R.ID),
+      ))
+    }
+  }
+  if more > 0 {
+    text += "\n\n" + tr(ud.Lang, "agenda_more", Count{more, "reminders"})
+  }
+  return text, kb
+}
+
+// shorten cuts s to n runes, marking the cut.
+func shorten(s string, n int) string {
+  if utf8.RuneCountInString(s) <= n {
+    return s
+  }
+  return string([]rune(s)[:n-1]) + "…"
+}
+
+// sendAgenda sends the agenda for span, headed by the message key.
+func sendAgenda(chatID int64, key string, span time.Duration) {
+  text, kb := agendaMessage(chatID, tr(getUserData(chatID).Lang, key), span)
+  if kb == nil {
+    messenger.SendText(chatID, text)
+  } else {
+    messenger.SendKeyboard(chatID, text, kb)
+  }
+}
+
+// handleAgendaCallback handles an agenda's edit and postpone buttons. Edit
+// runs the date and time steps of the wizard again for the reminder.
+func handleAgendaCallback(q *InCallback, cb callback) error {
+  a := cb.args()
+  action := a.word("edit", "later")
+  rid := a.int(0, math.MaxInt32)
+  if err := a.end(); err != nil {
+    return err
+  }
+  r, ok := findReminder(q.ChatID, rid)
+  if !ok || r.CronExpr != "" {
+    return errCallbackStale
+  }
+  if action == "edit" {
+    s := getSession(q.ChatID)
+    resetSession(s)
+    // Delivery failures are about the old time and are not carried over.
+    s.Temp = Reminder{Name: r.Name, OptInfo: r.OptInfo, Channels: r.Channels, OverrideDND: r.OverrideDND, Priority: r.Priority, Paused: r.Paused, History: r.History}
+    s.EditID = r.ID
+    advanceSession(s, StageDate)
+    now := time.Now()
+    sendKeyboard(q.ChatID, CreateCalendar(q.ChatID, now.Year(), int(now.Month())), "edit_prompt_date", r.Name)
+    return nil
+  }
+
+  var at time.Time
+  active := true
+  updateUserData(q.ChatID, func(ud *UserData) {
+    for i := range ud.Reminders {
+      if ud.Reminders[i].ID == rid {
+        cur := &ud.Reminders[i]
+        t, err := reminderWallClock(*cur)
+        if err != nil {
+          return
+        }
+        at = t.Add(agendaPostpone)
+        cur.Date, cur.Time = at.Format("02/01/2006"), at.Format("3:04 pm")
+        r, active = *cur, ud.InactiveSince == nil
+        return
+      }
+    }
+  })
+  if at.IsZero() {
+    return errCallbackStale
+  }
+  cancelJob(rid)
+  // A paused or undelivered reminder keeps its new time but stays
+  // unscheduled, as it was.
+  if active {
+    scheduleReminder(q.ChatID, r)
+  }
+  sendText(q.ChatID, "postponed", r.Name, LocalDate(at), LocalTime(at))
+  return nil
+}
+
+// --------- Digest ---------
+
+func handleDigestCommand(chatID int64, args string) {
+  ud := getUserData(chatID)
+  f := strings.Fields(strings.ToLower(args))
+  local := time.Now().UTC().Add(time.Duration(ud.UTC) * time.Hour)
+  today := local.Format("2006-01-02")
+  set := func(fn func(d *DigestSettings)) {
+    updateUserData(chatID, func(ud *UserData) {
+      var d DigestSettings
+      if ud.Digest != nil {
+        d = *ud.Digest
+      }
+      fn(&d)
+      // Replaced rather than changed in place, copies may share it.
+      ud.Digest = &d
+    })
+  }
+  switch {
+  case len(f) == 0:
+  case len(f) == 1 && f[0] == "off":
+    set(func(d *DigestSettings) { d.Time = "" })
+  case len(f) == 2 && f[0] == "weekly" && (f[1] == "on" || f[1] == "off"):
+    set(func(d *DigestSettings) {
+      d.Weekly = f[1] == "on"
+      // Turned on after this Sunday's overview time, the first comes next week.
+      if d.Weekly && local.Weekday() == time.Sunday && local.Hour() >= weeklyDigestHour {
+        d.LastWeekly = today
+      }
+    })
+  case len(f) == 1:
+    t, err := time.Parse("15:04", f[0])
+    if err != nil {
+      sendText(chatID, "digest_usage")
+      return
+    }
+    set(func(d *DigestSettings) {
+      d.Time = t.Format("15:04")
+      // The latest time already passed counts as sent, the first comes next.
+      d.LastDaily = dailyDigestStart(local, t).Format("2006-01-02")
+    })
+  default:
+    sendText(chatID, "digest_usage")
+    return
+  }
+
+  ud = getUserData(chatID)
+  daily, weekly := plainText(ud.Lang, "digest_off"), plainText(ud.Lang, "digest_off")
+  if d := ud.Digest; d != nil {
+    if t, err := time.Parse("15:04", d.Time); err == nil {
+      daily = tr(ud.Lang, "digest_at", LocalTime(t))
+    }
+    if d.Weekly {
+      weekly = tr(ud.Lang, "digest_sunday", LocalTime(time.Date(0, 1, 1, weeklyDigestHour, 0, 0, 0, time.UTC)))
+    }
+  }
+  sendText(chatID, "digest_status", HTML(daily), HTML(weekly))
+}
+
+// dailyDigestStart returns the latest time of day at, at or before local.
+// A digest missed just before midnight is caught up after it, as the
+// previous day's.
+func dailyDigestStart(local, at time.Time) time.Time {
+  start := time.Date(local.Year(), local.Month(), local.Day(), at.Hour(), at.Minute(), 0, 0, time.UTC)
+  if local.Before(start) {
+    start = start.AddDate(0, 0, -1)
+  }
+  return start
+}
+
+// runDigests sends due digests every minute until ctx is done.
+func runDigests(ctx context.Context) {
+  tick := time.NewTicker(digestInterval)
+  defer tick.Stop()
+  for {
+    select {
+    case <-ctx.Done():
+      return
+    case now := <-tick.C:
+      sendDueDigests(now.UTC())
+    }
+  }
+}
+
+// sendDueDigests sends every daily digest and weekly overview due at now.
+func sendDueDigests(now time.Time) {
+  type due struct {
+    chatID        int64
+    daily, weekly bool
+    day, today    string // Local dates of the daily digest and of now
+  }
+  var list []due
+  store.mu.Lock()
+  for key, ud := range store.Reminder {
+    d := ud.Digest
+    if d == nil || ud.InactiveSince != nil {
+      continue
+    }
+    local := now.Add(time.Duration(ud.UTC) * time.Hour)
+    today := local.Format("2006-01-02")
+    dd := due{today: today}
+    if at, err := time.Parse("15:04", d.Time); err == nil {
+      start := dailyDigestStart(local, at)
+      dd.day = start.Format("2006-01-02")
+      dd.daily = d.LastDaily != dd.day && local.Sub(start) < digestCatchUp
+    }
+    if d.Weekly && d.LastWeekly != today && local.Weekday() == time.Sunday {
+      start := time.Date(local.Year(), local.Month(), local.Day(), weeklyDigestHour, 0, 0, 0, time.UTC)
+      dd.weekly = !local.Before(start) && local.Sub(start) < digestCatchUp
+    }
+    if dd.daily || dd.weekly {
+      dd.chatID, _ = strconv.ParseInt(key, 10, 64)
+      list = append(list, dd)
+    }
+  }
+  store.mu.Unlock()
+
+  for _, dd := range list {
+    updateUserData(dd.chatID, func(ud *UserData) {
+      if ud.Digest == nil {
+        return
+      }
+      d := *ud.Digest
+      if dd.daily {
+        d.LastDaily = dd.day
+      }
+      if dd.weekly {
+        d.LastWeekly = dd.today
+      }
+      ud.Digest = &d
+    })
+    if dd.daily {
+      slog.Debug("daily digest", "chat_id", dd.chatID)
+      sendAgenda(dd.chatID, "digest_daily", 24*time.Hour)
+    }
+    if dd.weekly {
+      slog.Debug("weekly digest", "chat_id", dd.chatID)
+      sendAgenda(dd.chatID, "digest_weekly", 7*24*time.Hour)
+    }
+  }
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+  "strings"
+  "testing"
+  "time"
+)
+
+func TestAgendaPostpone(t *testing.T) {
+  for _, tc := range []struct {
+    name      string
+    r         Reminder
+    scheduled bool
+  }{
+    {"active", Reminder{ID: 301}, true},
+    {"paused", Reminder{ID: 302, Paused: true}, false},
+    {"undelivered", Reminder{ID: 303, Failures: []DeliveryFailure{{Channel: "chat"}}}, false},
+  } {
+    t.Run(tc.name, func(t *testing.T) {
+      fm := setupTest(t)
+      const chatID = 51
+      r := tc.r
+      r.Name, r.Date, r.Time = "Call mum", "01/03/2030", "6:00 pm"
+      updateUserData(chatID, func(ud *UserData) {
+        ud.Reminders = append(ud.Reminders, r)
+      })
+      defer cancelJob(r.ID)
+
+      msg := sentMessage{ChatID: chatID, ID: 1, Keyboard: newKeyboard(newRow(callbackButton(chatID, "later", cbAgenda, "later", r.ID)))}
+      handleCallback(msg.press(t, "later"))
+
+      if got := getUserData(chatID).Reminders[0]; got.Date != "01/03/2030" || got.Time != "7:00 pm" {
+        t.Fatalf("postponed to %s %s, want 01/03/2030 7:00 pm", got.Date, got.Time)
+      }
+      schedMu.Lock()
+      _, scheduled := onceTimers[r.ID]
+      schedMu.Unlock()
+      if scheduled != tc.scheduled {
+        t.Fatalf("scheduled %v, want %v", scheduled, tc.scheduled)
+      }
+      if fm.answers[0] != "" {
+        t.Fatalf("answered %q", fm.answers[0])
+      }
+    })
+  }
+}
+
+func TestAgenda(t *testing.T) {
+  once := func(id int, name, date, clock string) Reminder {
+    return Reminder{ID: id, Name: name, Date: date, Time: clock}
+  }
+  cron := func(id int, name, spec, tz string) Reminder {
+    return Reminder{ID: id, Name: name, CronOriginal: spec, CronExpr: spec, TZ: tz}
+  }
+  for _, tc := range []struct {
+    name      string
+    reminders []Reminder
+    utc       int
+    now       time.Time
+    span      time.Duration
+    want      []string // Name and local wall clock, in order
+  }{
+    {
+      name: "one-time in the chat's offset",
+      reminders: []Reminder{
+        once(1, "Past", "01/03/2030", "7:00 pm"),      // 11:00 UTC, before now
+        once(2, "Breakfast", "02/03/2030", "8:00 am"), // 00:00 UTC the same day
+        once(3, "Late", "02/03/2030", "8:00 pm"),      // 12:00 UTC, just past the span
+      },
+      utc:  8,
+      now:  time.Date(2030, 3, 1, 12, 0, 0, 0, time.UTC),
+      span: 24 * time.Hour,
+      want: []string{"Breakfast 2030-03-02 08:00"},
+    },
+    {
+      name: "one-time west of UTC on the previous day",
+      reminders: []Reminder{
+        once(1, "Dinner", "01/03/2030", "9:00 pm"), // 02:00 UTC on the 2nd
+      },
+      utc:  -5,
+      now:  time.Date(2030, 3, 1, 23, 0, 0, 0, time.UTC),
+      span: 24 * time.Hour,
+      want: []string{"Dinner 2030-03-01 21:00"},
+    },
+    {
+      name: "paused and undelivered skipped",
+      reminders: []Reminder{
+        {ID: 1, Name: "Paused", Date: "02/03/2030", Time: "9:00 am", Paused: true},
+        {ID: 2, Name: "Failed", Date: "02/03/2030", Time: "10:00 am", Failures: []DeliveryFailure{{Channel: "chat"}}},
+        {ID: 3, Name: "Gym", CronOriginal: "0 11 * * *", CronExpr: "0 11 * * *", TZ: "UTC", Failures: []DeliveryFailure{{Channel: "chat"}}},
+        {ID: 4, Name: "Paused cron", CronOriginal: "0 12 * * *", CronExpr: "0 12 * * *", TZ: "UTC", Paused: true},
+      },
+      now:  time.Date(2030, 3, 2, 0, 0, 0, 0, time.UTC),
+      span: 24 * time.Hour,
+      want: []string{"Gym 2030-03-02 11:00"},
+    },
+    {
+      name: "cron across a DST change shown in the chat's offset",
+      reminders: []Reminder{
+        cron(1, "Standup", "0 9 * * *", "Europe/Berlin"), // 08:00 UTC in winter, 07:00 UTC from the 31st
+        once(2, "Dentist", "31/03/2030", "2:30 pm"),
+      },
+      utc:  8,
+      now:  time.Date(2030, 3, 30, 12, 0, 0, 0, time.UTC),
+      span: 48 * time.Hour,
+      want: []string{"Dentist 2030-03-31 14:30", "Standup 2030-03-31 15:00", "Standup 2030-04-01 15:00"},
+    },
+    {
+      name:      "cron in a fixed zone",
+      reminders: []Reminder{cron(1, "Rent", "0 10 1 * *", "Asia/Shanghai")},
+      utc:       -3,
+      now:       time.Date(2030, 3, 1, 0, 0, 0, 0, time.UTC),
+      span:      7 * 24 * time.Hour,
+      want:      []string{"Rent 2030-02-28 23:00"}, // 10:00 on the 1st in Shanghai, the evening before at UTC-3
+    },
+  } {
+    t.Run(tc.name, func(t *testing.T) {
+      items, more := agenda(UserData{UTC: tc.utc, Reminders: tc.reminders}, tc.now, tc.span)
+      var got []string
+      for _, it := range items {
+        got = append(got, it.R.Name+" "+it.At.Format("2006-01-02 15:04"))
+      }
+      if more != 0 || strings.Join(got, ", ") != strings.Join(tc.want, ", ") {
+        t.Fatalf("got %q (%d more), want %q", got, more, tc.want)
+      }
+    })
+  }
+}
+
+func TestAgendaCap(t *testing.T) {
+  ud := UserData{Reminders: []Reminder{{ID: 1, Name: "Tick", CronOriginal: "* * * * *", CronExpr: "* * * * *", TZ: "UTC"}}}
+  items, more := agenda(ud, time.Date(2030, 3, 1, 0, 0, 0, 0, time.UTC), 24*time.Hour)
+  if len(items) != maxAgendaItems || more == 0 {
+    t.Fatalf("%d items and %d more, want %d and some more", len(items), more, maxAgendaItems)
+  }
+}
+
+func TestSendDueDigests(t *testing.T) {
+  sunday := func(h, m int) time.Time { return time.Date(2030, 3, 3, h, m, 0, 0, time.UTC) }
+  for _, tc := range []struct {
+    name   string
+    d      DigestSettings
+    utc    int
+    now    time.Time // UTC
+    sent   []string  // Digest headers, in order
+    last   string    // LastDaily afterwards
+    weekly string    // LastWeekly afterwards
+  }{
+    {"not yet", DigestSettings{Time: "08:00"}, 2, sunday(5, 59), nil, "", ""},
+    {"on time in the offset", DigestSettings{Time: "08:00"}, 2, sunday(6, 0), []string{"digest_daily"}, "2030-03-03", ""},
+    {"caught up after downtime", DigestSettings{Time: "08:00"}, 2, sunday(8, 59), []string{"digest_daily"}, "2030-03-03", ""},
+    {"missed by too long", DigestSettings{Time: "08:00"}, 2, sunday(9, 0), nil, "", ""},
+    {"already sent today", DigestSettings{Time: "08:00", LastDaily: "2030-03-03"}, 2, sunday(7, 0), nil, "2030-03-03", ""},
+    {"sent yesterday", DigestSettings{Time: "08:00", LastDaily: "2030-03-02"}, 2, sunday(7, 0), []string{"digest_daily"}, "2030-03-03", ""},
+    {"local date behind UTC", DigestSettings{Time: "23:30"}, -5, sunday(4, 40), []string{"digest_daily"}, "2030-03-02", ""},
+    {"caught up after midnight", DigestSettings{Time: "23:00", LastDaily: "2030-03-01"}, 0, sunday(0, 30), []string{"digest_daily"}, "2030-03-02", ""},
+    {"yesterday's sent before midnight", DigestSettings{Time: "23:00", LastDaily: "2030-03-02"}, 0, sunday(0, 30), nil, "2030-03-02", ""},
+    {"missed before midnight by too long", DigestSettings{Time: "21:00", LastDaily: "2030-03-01"}, 0, sunday(0, 30), nil, "2030-03-01", ""},
+    {"weekly on Sunday evening", DigestSettings{Weekly: true}, 0, sunday(18, 5), []string{"digest_weekly"}, "", "2030-03-03"},
+    {"weekly not on Saturday", DigestSettings{Weekly: true}, 0, sunday(18, 5).AddDate(0, 0, -1), nil, "", ""},
+    {"weekly Sunday in the offset only", DigestSettings{Weekly: true}, 10, sunday(8, 0), []string{"digest_weekly"}, "", "2030-03-03"},
+    {"daily and weekly together", DigestSettings{Time: "18:00", Weekly: true}, 0, sunday(18, 0), []string{"digest_daily", "digest_weekly"}, "2030-03-03", "2030-03-03"},
+  } {
+    t.Run(tc.name, func(t *testing.T) {
+      fm := setupTest(t)
+      const chatID = 52
+      d := tc.d
+      updateUserData(chatID, func(ud *UserData) {
+        ud.UTC = tc.utc
+        ud.Digest = &d
+      })
+
+      // A second tick in the same minute sends nothing new.
+      sendDueDigests(tc.now)
+      sendDueDigests(tc.now)
+
+      var got []string
+      for _, m := range fm.messages(chatID) {
+        for _, key := range []string{"digest_daily", "digest_weekly"} {
+          if strings.HasPrefix(m.Text, tr("en", key)) {
+            got = append(got, key)
+          }
+        }
+      }
+      if strings.Join(got, ",") != strings.Join(tc.sent, ",") {
+        t.Fatalf("sent %q, want %q", got, tc.sent)
+      }
+      if d := getUserData(chatID).Digest; d.LastDaily != tc.last || d.LastWeekly != tc.weekly {
+        t.Fatalf("last daily %q weekly %q, want %q and %q", d.LastDaily, d.LastWeekly, tc.last, tc.weekly)
+      }
+    })
+  }
+}
+
+func TestSendDueDigestsSkipsInactiveChat(t *testing.T) {
+  fm := setupTest(t)
+  const chatID = 53
+  since := time.Date(2030, 3, 1, 0, 0, 0, 0, time.UTC)
+  updateUserData(chatID, func(ud *UserData) {
+    ud.Digest = &DigestSettings{Time: "08:00", Weekly: true}
+    ud.InactiveSince = &since
+  })
+  sendDueDigests(time.Date(2030, 3, 3, 18, 0, 0, 0, time.UTC))
+  if n := len(fm.messages(chatID)); n != 0 {
+    t.Fatalf("sent %d digests to an inactive chat", n)
+  }
+}
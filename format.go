@@ -0,0 +1,172 @@
+package main
+
+import (
+  "fmt"
+  "strings"
+  "time"
+)
+
+// --------- Locale Formatting ---------
+
+// Localizable values are rendered per language before being substituted
+// into a message template, so the same argument reads naturally in every
+// locale.
+type Localizable interface {
+  Localize(lang string) string
+}
+
+// LocalDate renders the date part of a wall-clock time.
+type LocalDate time.Time
+
+// LocalTime renders the time-of-day part of a wall-clock time.
+type LocalTime time.Time
+
+// LocalDuration renders a relative duration such as "in 2 hours".
+type LocalDuration time.Duration
+
+// LocalSpan renders a plain length of time such as "2 hours".
+type LocalSpan time.Duration
+
+// Count renders N followed by the correctly pluralized noun Key.
+type Count struct {
+  N   int
+  Key string
+}
+
+var weekdaysZh = [...]string{"周日", "周一", "周二", "周三", "周四", "周五", "周六"}
+
+func (d LocalDate) Localize(lang string) string {
+  t := time.Time(d)
+  switch lang {
+  case "zh":
+    return fmt.Sprintf("%d年%d月%d日 %s", t.Year(), t.Month(), t.Day(), weekdaysZh[t.Weekday()])
+  default:
+    return t.Format("Mon, Jan 2, 2006")
+  }
+}
+
+func (t LocalTime) Localize(lang string) string {
+  switch lang {
+  case "zh":
+    return time.Time(t).Format("15:04")
+  default:
+    return time.Time(t).Format("3:04 PM")
+  }
+}
+
+func (d LocalDuration) Localize(lang string) string {
+  parts := durationParts(time.Duration(d), lang)
+  if len(parts) == 0 {
+    return plainText(lang, "duration_now")
+  }
+  switch lang {
+  case "zh":
+    return strings.Join(parts, "") + "后"
+  default:
+    return "in " + strings.Join(parts, " ")
+  }
+}
+
+func (d LocalSpan) Localize(lang string) string {
+  parts := durationParts(time.Duration(d), lang)
+  if len(parts) == 0 {
+    return Count{0, "unit_minute"}.Localize(lang)
+  }
+  if lang == "zh" {
+    return strings.Join(parts, "")
+  }
+  return strings.Join(parts, " ")
+}
+
+// durationParts splits dur into localized days, hours and minutes.
+func durationParts(dur time.Duration, lang string) []string {
+  if dur < 0 {
+    dur = -dur
+  }
+  days := int(dur / (24 * time.Hour))
+  hours := int(dur % (24 * time.Hour) / time.Hour)
+  mins := int(dur % time.Hour / time.Minute)
+
+  var parts []string
+  if days > 0 {
+    parts = append(parts, Count{days, "unit_day"}.Localize(lang))
+  }
+  if hours > 0 {
+    parts = append(parts, Count{hours, "unit_hour"}.Localize(lang))
+  }
+  // Minutes only matter while the duration is still short.
+  if mins > 0 && days == 0 {
+    parts = append(parts, Count{mins, "unit_minute"}.Localize(lang))
+  }
+  return parts
+}
+
+func (c Count) Localize(lang string) string {
+  forms, ok := plurals[c.Key][lang]
+  if !ok {
+    forms = plurals[c.Key]["en"]
+  }
+  return fmt.Sprintf(forms[pluralCategory(lang, c.N)], c.N)
+}
+
+// Plural categories, following the CLDR names.
+const (
+  pluralOne = iota
+  pluralOther
+)
+
+// pluralCategory picks the plural form for n in the given language.
+func pluralCategory(lang string, n int) int {
+  switch lang {
+  case "zh":
+    // Chinese has no grammatical plural.
+    return pluralOther
+  default:
+    if n == 1 {
+      return pluralOne
+    }
+    return pluralOther
+  }
+}
+
+// plurals holds the forms of countable nouns indexed by plural category.
+var plurals = map[string]map[string][2]string{
+  "unit_day":    {"en": {"%d day", "%d days"}, "zh": {"%d天", "%d天"}},
+  "unit_hour":   {"en": {"%d hour", "%d hours"}, "zh": {"%d小时", "%d小时"}},
+  "unit_minute": {"en": {"%d minute", "%d minutes"}, "zh": {"%d分钟", "%d分钟"}},
+  "reminders":   {"en": {"%d reminder", "%d reminders"}, "zh": {"%d 条提醒", "%d 条提醒"}},
+  "chats":       {"en": {"%d chat", "%d chats"}, "zh": {"%d 个会话", "%d 个会话"}},
+  "failures":    {"en": {"%d delivery failed", "%d deliveries failed"}, "zh": {"%d 次发送失败", "%d 次发送失败"}},
+  "events":      {"en": {"%d event", "%d events"}, "zh": {"%d 个事件", "%d 个事件"}},
+  "channels":    {"en": {"%d channel", "%d channels"}, "zh": {"%d 个渠道", "%d 个渠道"}},
+  "notifications": {"en": {"%d notification", "%d notifications"}, "zh": {"%d 条通知", "%d 条通知"}},
+}
+
+// localizeArgs renders every Localizable argument for lang.
+func localizeArgs(lang string, a []interface{}) []interface{} {
+  out := make([]interface{}, len(a))
+  for i, v := range a {
+    if l, ok := v.(Localizable); ok {
+      out[i] = l.Localize(lang)
+    } else {
+      out[i] = v
+    }
+  }
+  return out
+}
+
+// plainText returns the message for key in lang without substitution.
+func plainText(lang, key string) string {
+  if s, ok := override(key, lang); ok {
+    return s
+  }
+  if s, ok := messages[key][lang]; ok {
+    return s
+  }
+  return messages[key]["en"]
+}
+
+// tr renders the message for key in lang with localized arguments.
+func tr(lang, key string, a ...interface{}) string {
+  return render(plainText(lang, key), localizeArgs(lang, a)...)
+}
@@ -1,9 +1,10 @@
 module ReminderBot
 
-go 1.19
+go 1.21
 
 require (
-	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1 // indirect
-	github.com/gorhill/cronexpr v0.0.0-20180427100037-88b0669f7d75 // indirect
-	github.com/robfig/cron/v3 v3.0.1 // indirect
+	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
+	github.com/gorhill/cronexpr v0.0.0-20180427100037-88b0669f7d75
 )
+
+require github.com/robfig/cron/v3 v3.0.1 // indirect
@@ -0,0 +1,109 @@
+package main
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "log/slog"
+  "net/http"
+  "os"
+  "path/filepath"
+  "sync/atomic"
+  "time"
+)
+
+// --------- Health & Metrics Endpoints ---------
+
+const healthCheckTimeout = 5 * time.Second
+
+// ready is set once updates are being handled and cleared when shutdown
+// begins.
+var ready atomic.Bool
+
+// startHealthServer serves /healthz, /readyz and /metrics on addr.
+func startHealthServer(addr string) {
+  mux := http.NewServeMux()
+  mux.HandleFunc("/healthz", handleHealthz)
+  mux.HandleFunc("/readyz", handleReadyz)
+  mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+    writeMetrics(w)
+  })
+  srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+  go func() {
+    if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+      fatal("health server failed", "err", err)
+    }
+  }()
+  slog.Info("health server listening", "listen", addr)
+}
+
+// handleHealthz checks that Telegram answers and the storage file can be
+// written. It responds 503 with the failing checks otherwise.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+  ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+  defer cancel()
+  checks := map[string]string{
+    "telegram": checkResult(checkTelegram(ctx)),
+    "storage":  checkResult(checkStorage()),
+  }
+  status := http.StatusOK
+  for _, res := range checks {
+    if res != "ok" {
+      status = http.StatusServiceUnavailable
+    }
+  }
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(checks)
+}
+
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+  if !ready.Load() {
+    http.Error(w, "not ready", http.StatusServiceUnavailable)
+    return
+  }
+  w.Write([]byte("ok\n"))
+}
+
+func checkResult(err error) string {
+  if err != nil {
+    return err.Error()
+  }
+  return "ok"
+}
+
+// checkTelegram calls getMe. The console front end has nothing to check.
+func checkTelegram(ctx context.Context) error {
+  if bot == nil {
+    return nil
+  }
+  done := make(chan error, 1)
+  go func() {
+    _, err := bot.GetMe()
+    done <- err
+  }()
+  select {
+  case err := <-done:
+    if err != nil {
+      // The error may quote the request URL, which holds the token.
+      return errors.New(redact(err.Error()))
+    }
+    return nil
+  case <-ctx.Done():
+    return errors.New("getMe timed out")
+  }
+}
+
+// checkStorage creates and removes a file next to the storage file.
+func checkStorage() error {
+  if config().Storage == "memory" {
+    return nil
+  }
+  f, err := os.CreateTemp(filepath.Dir(config().DataPath), ".healthz-*")
+  if err != nil {
+    return err
+  }
+  f.Close()
+  return os.Remove(f.Name())
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+  "log/slog"
+  "math"
+  "sort"
+  "strconv"
+  "time"
+)
+
+// --------- Fire History ---------
+
+// Every notification is recorded on its reminder: when it was due, when
+// it was sent, whether the chat got it, and what was pressed on it.
+// Delivered one-time reminders move to the chat's archive, from where
+// /archive can restore them.
+
+// FireRecord is one notification of a reminder.
+type FireRecord struct {
+  Scheduled time.Time  `json:"scheduled"`            // When the notification was due
+  Sent      time.Time  `json:"sent"`                 // When it was sent, or given up on
+  Status    string     `json:"status"`               // fireDelivered, fireFailed or fireLimited
+  Error     string     `json:"error,omitempty"`
+  MessageID int        `json:"message_id,omitempty"` // Of the notification in the chat
+  Action    string     `json:"action,omitempty"`     // actionAck or actionSnooze
+  ActionAt  *time.Time `json:"action_at,omitempty"`
+  Silent    bool       `json:"silent,omitempty"`     // Sent without a sound, for quiet hours or a low priority
+  Pinned    bool       `json:"pinned,omitempty"`
+}
+
+const (
+  fireDelivered = "delivered"
+  fireFailed    = "failed"
+  fireLimited   = "rate_limited"
+
+  actionAck    = "ack"
+  actionSnooze = "snooze"
+
+  maxHistory     = 20 // Fire records kept per reminder
+  maxArchive     = 30 // Archived reminders kept per chat
+  defaultHistory = 10
+  maxHistoryList = 50
+  snoozeDelay    = 10 * time.Minute
+)
+
+// fireReminder sends r's notification, with buttons to acknowledge or
+// snooze it, to the chat and its channels, and returns the record of it.
+// Its priority decides whether it is silent, pinned and repeated.
+func fireReminder(chatID int64, r Reminder, scheduled time.Time) FireRecord {
+  ud := getUserData(chatID)
+  // Cron jobs hold a copy taken at start; pick up later edits.
+  for _, cur := range ud.Reminders {
+    if cur.ID == r.ID {
+      r = cur
+      break
+    }
+  }
+  text := notificationText(chatID, r)
+  lang := ud.Lang
+  kb := newKeyboard(newRow(
+    callbackButton(chatID, plainText(lang, "btn_ack"), cbFire, actionAck, r.ID),
+    callbackButton(chatID, plainText(lang, "btn_snooze"), cbFire, actionSnooze, r.ID),
+  ))
+  send := messenger.SendKeyboard
+  silent := r.Priority == priorityLow || sendsSilently(ud, r)
+  if silent {
+    send = messenger.SendSilent
+  }
+  msgID, err := send(chatID, text, kb)
+  notifyChannels(chatID, r, text)
+  rec := FireRecord{Scheduled: scheduled.UTC(), Sent: time.Now().UTC(), Status: fireDelivered, MessageID: msgID, Silent: silent}
+  if err != nil {
+    metrics.deliveryFailures.inc("chat")
+    recordFailure(chatID, r.ID, "chat", err)
+    rec.Status, rec.Error, rec.MessageID = fireFailed, err.Error(), 0
+    return rec
+  }
+  repeat := false
+  for _, h := range r.History {
+    repeat = repeat || h.Scheduled.Equal(rec.Scheduled) && h.Status == fireDelivered
+  }
+  // Only the first of an urgent reminder's repeats is pinned.
+  if pinsNotification(r) && !repeat {
+    if err := messenger.PinMessage(chatID, msgID); err != nil {
+      slog.Debug("pin failed", "chat_id", chatID, "message_id", msgID, "err", err)
+    } else {
+      rec.Pinned = true
+    }
+  }
+  if r.Priority == priorityUrgent {
+    scheduleNag(chatID, r.ID, rec.Scheduled)
+  }
+  return rec
+}
+
+// appendHistory adds rec to r's history, keeping the newest maxHistory.
+// The slice is replaced, copies from getUserData share the old one.
+func appendHistory(r *Reminder, rec FireRecord) {
+  h := append(append([]FireRecord(nil), r.History...), rec)
+  if len(h) > maxHistory {
+    h = h[len(h)-maxHistory:]
+  }
+  r.History = h
+}
+
+// recordFire stores rec on the reminder, active or archived, if it still
+// exists.
+func recordFire(chatID int64, rid int, rec FireRecord) {
+  updateUserData(chatID, func(ud *UserData) {
+    for _, list := range [][]Reminder{ud.Reminders, ud.Archive} {
+      for i := range list {
+        if list[i].ID == rid {
+          appendHistory(&list[i], rec)
+          return
+        }
+      }
+    }
+  })
+}
+
+// archiveReminder moves a delivered one-time reminder to the archive with
+// rec as its last record.
+func archiveReminder(chatID int64, rid int, rec FireRecord) {
+  updateUserData(chatID, func(ud *UserData) {
+    for i, r := range ud.Reminders {
+      if r.ID == rid {
+        appendHistory(&r, rec)
+        ud.Reminders = removeReminder(ud.Reminders, i)
+        ud.Archive = append([]Reminder{r}, ud.Archive...)
+        if len(ud.Archive) > maxArchive {
+          ud.Archive = ud.Archive[:maxArchive]
+        }
+        return
+      }
+    }
+  })
+}
+
+// handleFireCallback records a press of a notification's Done or Snooze
+// button. Snoozing adds a one-time reminder that notifies again after
+// snoozeDelay. Either unpins the notification.
+func handleFireCallback(q *InCallback, cb callback) error {
+  a := cb.args()
+  action := a.word(actionAck, actionSnooze)
+  rid := a.int(0, math.MaxInt32)
+  if err := a.end(); err != nil {
+    return err
+  }
+  now := time.Now().UTC()
+  var fired Reminder
+  var pinned []int
+  found := false
+  updateUserData(q.ChatID, func(ud *UserData) {
+    for _, list := range [][]Reminder{ud.Reminders, ud.Archive} {
+      for i := range list {
+        if list[i].ID != rid {
+          continue
+        }
+        h := list[i].History
+        for j := len(h) - 1; j >= 0; j-- {
+          if h[j].MessageID == q.MessageID && h[j].Action == "" {
+            // Changed on a copy, readers may hold the old array.
+            h = append([]FireRecord(nil), h...)
+            h[j].Action, h[j].ActionAt = action, &now
+            list[i].History = h
+            fired, found = list[i], true
+            for _, rec := range h {
+              if rec.Pinned && rec.Scheduled.Equal(h[j].Scheduled) {
+                pinned = append(pinned, rec.MessageID)
+              }
+            }
+            return
+          }
+        }
+      }
+    }
+  })
+  messenger.EditKeyboard(q.ChatID, q.MessageID, nil)
+  if !found {
+    return errCallbackStale
+  }
+  q.log().Info("notification "+action, "reminder_id", rid)
+  unpinFire(q.ChatID, pinned)
+  if action == actionSnooze {
+    snoozeReminder(q.ChatID, fired, now)
+  }
+  return nil
+}
+
+// snoozeReminder adds a one-time copy of r that notifies at now plus
+// snoozeDelay.
+func snoozeReminder(chatID int64, r Reminder, now time.Time) {
+  if !checkReminderQuota(chatID) {
+    return
+  }
+  ud := getUserData(chatID)
+  // One-time reminders notify notifyLead before their time.
+  at := now.Add(snoozeDelay + config().notifyLead()).Add(time.Duration(ud.UTC) * time.Hour)
+  s := Reminder{
+    ID:       int(time.Now().UnixNano() % 1e6),
+    Name:     r.Name,
+    OptInfo:  r.OptInfo,
+    Channels: r.Channels,
+    OverrideDND: r.OverrideDND,
+    Priority: r.Priority,
+    Date:     at.Format("02/01/2006"),
+    Time:     at.Format("3:04 pm"),
+  }
+  updateUserData(chatID, func(ud *UserData) {
+    ud.Reminders = append(ud.Reminders, s)
+  })
+  scheduleOnce(chatID, s)
+  sendText(chatID, "snoozed", r.Name, LocalSpan(snoozeDelay))
+}
+
+// historyEntry is a fire record with its reminder's name.
+type historyEntry struct {
+  Name string
+  FireRecord
+}
+
+// handleHistoryCommand lists the chat's last n notifications, newest
+// first.
+func handleHistoryCommand(chatID int64, args string) {
+  ud := getUserData(chatID)
+  n := defaultHistory
+  if args != "" {
+    v, err := strconv.Atoi(args)
+    if err != nil || v < 1 {
+      sendText(chatID, "history_usage", maxHistoryList)
+      return
+    }
+    n = v
+  }
+  if n > maxHistoryList {
+    n = maxHistoryList
+  }
+  var entries []historyEntry
+  for _, list := range [][]Reminder{ud.Reminders, ud.Archive} {
+    for _, r := range list {
+      for _, rec := range r.History {
+        entries = append(entries, historyEntry{r.Name, rec})
+      }
+    }
+  }
+  if len(entries) == 0 {
+    sendText(chatID, "history_empty")
+    return
+  }
+  sort.SliceStable(entries, func(i, j int) bool { return entries[i].Sent.After(entries[j].Sent) })
+  if len(entries) > n {
+    entries = entries[:n]
+  }
+  local := func(t time.Time) time.Time { return t.Add(time.Duration(ud.UTC) * time.Hour) }
+  text := tr(ud.Lang, "history_header", Count{len(entries), "notifications"})
+  for _, e := range entries {
+    status := tr(ud.Lang, "history_"+e.Status)
+    if e.Status == fireFailed {
+      status = tr(ud.Lang, "history_failed", e.Error)
+    }
+    if e.Silent {
+      status += " · " + tr(ud.Lang, "history_silent")
+    }
+    if e.ActionAt != nil {
+      status += " · " + tr(ud.Lang, "history_"+e.Action, LocalTime(local(*e.ActionAt)))
+    }
+    due := local(e.Scheduled)
+    text += "\n\n" + tr(ud.Lang, "history_line", e.Name, LocalDate(due), LocalTime(due), LocalTime(local(e.Sent)), HTML(status))
+  }
+  messenger.SendText(chatID, text)
+}
+
+// --------- Archive ---------
+
+func handleArchiveCommand(chatID int64) {
+  ud := getUserData(chatID)
+  if len(ud.Archive) == 0 {
+    sendText(chatID, "archive_empty")
+    return
+  }
+  text := tr(ud.Lang, "archive_header", Count{len(ud.Archive), "reminders"})
+  var kb Keyboard
+  var row []Button
+  for i, r := range ud.Archive {
+    text += "\n" + render("%d) %s   %s", i+1, r.Name, HTML(scheduleText(ud.Lang, r)))
+    row = append(row, callbackButton(chatID, "♻️ "+strconv.Itoa(i+1), cbRestore, r.ID))
+    if len(row) == 5 {
+      kb = append(kb, row)
+      row = nil
+    }
+  }
+  if len(row) > 0 {
+    kb = append(kb, row)
+  }
+  text += "\n\n" + tr(ud.Lang, "archive_hint")
+  messenger.SendKeyboard(chatID, text, kb)
+}
+
+// handleRestoreCallback moves an archived reminder back to the active
+// list. A time already past moves to the next day it is still ahead.
+func handleRestoreCallback(q *InCallback, cb callback) error {
+  a := cb.args()
+  rid := a.int(0, math.MaxInt32)
+  if err := a.end(); err != nil {
+    return err
+  }
+  if !checkReminderQuota(q.ChatID) {
+    return nil
+  }
+  var restored Reminder
+  found, active := false, true
+  updateUserData(q.ChatID, func(ud *UserData) {
+    for i, r := range ud.Archive {
+      if r.ID != rid {
+        continue
+      }
+      at, err := reminderWallClock(r)
+      if err != nil {
+        return
+      }
+      now := time.Now().UTC().Add(time.Duration(ud.UTC) * time.Hour)
+      at = time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, time.UTC)
+      if !at.Add(-config().notifyLead()).After(now) {
+        at = at.AddDate(0, 0, 1)
+      }
+      r.Date, r.Time = at.Format("02/01/2006"), at.Format("3:04 pm")
+      r.Failures = nil
+      ud.Archive = append(ud.Archive[:i:i], ud.Archive[i+1:]...)
+      ud.Reminders = append(ud.Reminders, r)
+      restored, found, active = r, true, ud.InactiveSince == nil
+      return
+    }
+  })
+  if !found {
+    return errCallbackStale
+  }
+  if active {
+    scheduleOnce(q.ChatID, restored)
+  }
+  at, _ := reminderWallClock(restored)
+  sendText(q.ChatID, "restored", restored.Name, LocalDate(at), LocalTime(at))
+  return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+  "sync"
+  "testing"
+  "time"
+)
+
+func TestDoneWhileNagPending(t *testing.T) {
+  fm := setupTest(t)
+  const chatID = 41
+  old := nagInterval
+  nagInterval = 200 * time.Millisecond
+  defer func() { nagInterval = old }()
+
+  r := Reminder{ID: 201, Name: "Pay rent", Date: "01/01/2030", Time: "9:00 am", Priority: priorityUrgent}
+  updateUserData(chatID, func(ud *UserData) {
+    ud.Reminders = append(ud.Reminders, r)
+  })
+  scheduled := time.Now().UTC().Truncate(time.Minute)
+  recordFire(chatID, r.ID, fireReminder(chatID, r, scheduled))
+  notice := fm.last(t, chatID)
+  if !fm.pinned[notice.ID] {
+    t.Fatal("urgent notification not pinned")
+  }
+
+  // A copy is read without the lock while Done is pressed, as the nag
+  // timer does.
+  cp := getUserData(chatID)
+  stop := make(chan struct{})
+  var wg sync.WaitGroup
+  wg.Add(1)
+  go func() {
+    defer wg.Done()
+    for {
+      select {
+      case <-stop:
+        return
+      default:
+        _ = cp.Reminders[0].History[0].Action
+      }
+    }
+  }()
+  handleCallback(notice.press(t, plainText("en", "btn_ack")))
+  close(stop)
+  wg.Wait()
+
+  if a := cp.Reminders[0].History[0].Action; a != "" {
+    t.Fatalf("pressing Done changed a copy: action %q", a)
+  }
+  if a := getUserData(chatID).Reminders[0].History[0].Action; a != actionAck {
+    t.Fatalf("stored action %q, want %q", a, actionAck)
+  }
+  if fm.pinned[notice.ID] {
+    t.Fatal("notification still pinned after Done")
+  }
+
+  time.Sleep(nagInterval + 200*time.Millisecond)
+  if got := fm.messages(chatID); len(got) != 1 {
+    t.Fatalf("%d notifications, want no repeat after Done", len(got))
+  }
+}
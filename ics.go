@@ -0,0 +1,415 @@
+package main
+
+import (
+  "crypto/rand"
+  "encoding/base64"
+  "fmt"
+  "log/slog"
+  "net/http"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+  "unicode/utf8"
+
+  "github.com/gorhill/cronexpr"
+)
+
+// --------- iCalendar Export ---------
+
+// buildICS renders the chat's reminders as an iCalendar file. One-time
+// reminders become single events with an alarm at the notification lead
+// time; cron reminders become recurring events when their schedule can be
+// written as an RRULE, in their time zone, which is described by a
+// VTIMEZONE. It returns the file and the number of cron reminders that had
+// to be left out. now stamps the events and starts the recurring ones.
+func buildICS(chatID int64, now time.Time) ([]byte, int) {
+  ud := getUserData(chatID)
+  var b strings.Builder
+  line := func(s string) { writeICSLine(&b, s) }
+  skipped := 0
+  var zones []*time.Location
+  for _, r := range ud.Reminders {
+    if r.Paused {
+      continue
+    }
+    var start, rrule, trigger string
+    if r.CronExpr == "" {
+      at, err := reminderWallClock(r)
+      if err != nil {
+        continue
+      }
+      start = ":" + at.Add(-time.Duration(ud.UTC)*time.Hour).Format("20060102T150405Z")
+      trigger = fmt.Sprintf("-PT%dM", int(config().notifyLead()/time.Minute))
+    } else {
+      next, rule, ok := cronToRRule(r, now)
+      if !ok {
+        skipped++
+        continue
+      }
+      if loc := next.Location(); loc == time.UTC {
+        start = ":" + next.Format("20060102T150405Z")
+      } else {
+        start = ";TZID=" + loc.String() + ":" + next.Format("20060102T150405")
+        if !containsZone(zones, loc) {
+          zones = append(zones, loc)
+        }
+      }
+      rrule, trigger = rule, "PT0M"
+    }
+    line("BEGIN:VEVENT")
+    line(fmt.Sprintf("UID:%d-%d@reminderbot", r.ID, chatID))
+    line("DTSTAMP:" + now.Format("20060102T150405Z"))
+    line("DTSTART" + start)
+    if rrule != "" {
+      line("RRULE:" + rrule)
+    }
+    line("SUMMARY:" + icsText(r.Name))
+    if r.OptInfo != "" {
+      line("DESCRIPTION:" + icsText(r.OptInfo))
+    }
+    line("BEGIN:VALARM")
+    line("ACTION:DISPLAY")
+    line("DESCRIPTION:" + icsText(r.Name))
+    line("TRIGGER:" + trigger)
+    line("END:VALARM")
+    line("END:VEVENT")
+  }
+  events := b.String()
+
+  b.Reset()
+  line("BEGIN:VCALENDAR")
+  line("VERSION:2.0")
+  line("PRODID:-//ReminderBot//EN")
+  line("CALSCALE:GREGORIAN")
+  line("X-WR-CALNAME:" + icsText(stripHTML(tr(ud.Lang, "ics_calendar_name"))))
+  for _, loc := range zones {
+    writeVTimezone(&b, loc, now)
+  }
+  b.WriteString(events)
+  line("END:VCALENDAR")
+  return []byte(b.String()), skipped
+}
+
+func containsZone(zones []*time.Location, loc *time.Location) bool {
+  for _, z := range zones {
+    if z.String() == loc.String() {
+      return true
+    }
+  }
+  return false
+}
+
+// vtimezoneYears is how far ahead a VTIMEZONE lists the zone's
+// transitions. Calendars keep the last one in force after that.
+const vtimezoneYears = 10
+
+// writeVTimezone describes loc from the start of now's year on: the
+// offset in force then, and every change of offset or abbreviation
+// within vtimezoneYears, found by stepping a day at a time and narrowing
+// down to the second. A zone without changes gets a single STANDARD.
+func writeVTimezone(b *strings.Builder, loc *time.Location, now time.Time) {
+  line := func(s string) { writeICSLine(b, s) }
+  observance := func(at time.Time, from int) {
+    kind := "STANDARD"
+    if at.IsDST() {
+      kind = "DAYLIGHT"
+    }
+    name, to := at.Zone()
+    line("BEGIN:" + kind)
+    // DTSTART is the local time just before the change.
+    line("DTSTART:" + at.In(time.FixedZone("", from)).Format("20060102T150405"))
+    line("TZOFFSETFROM:" + icsOffset(from))
+    line("TZOFFSETTO:" + icsOffset(to))
+    line("TZNAME:" + icsText(name))
+    line("END:" + kind)
+  }
+
+  line("BEGIN:VTIMEZONE")
+  line("TZID:" + loc.String())
+  t := time.Date(now.In(loc).Year(), time.January, 1, 0, 0, 0, 0, loc)
+  _, off := t.Zone()
+  observance(t, off)
+  end := t.AddDate(vtimezoneYears, 0, 0)
+  for t.Before(end) {
+    next := t.Add(24 * time.Hour)
+    if sameZone(t, next) {
+      t = next
+      continue
+    }
+    lo, hi := t, next
+    for hi.Sub(lo) > time.Second {
+      mid := lo.Add(hi.Sub(lo) / 2)
+      if sameZone(lo, mid) {
+        lo = mid
+      } else {
+        hi = mid
+      }
+    }
+    _, from := lo.Zone()
+    observance(hi.Truncate(time.Second), from)
+    t = hi
+  }
+  line("END:VTIMEZONE")
+}
+
+// sameZone reports whether a and b have the same offset and abbreviation.
+func sameZone(a, b time.Time) bool {
+  an, ao := a.Zone()
+  bn, bo := b.Zone()
+  return an == bn && ao == bo
+}
+
+// icsOffset formats a UTC offset in seconds as a UTC-OFFSET value.
+func icsOffset(sec int) string {
+  sign := "+"
+  if sec < 0 {
+    sign, sec = "-", -sec
+  }
+  s := fmt.Sprintf("%s%02d%02d", sign, sec/3600, sec/60%60)
+  if sec%60 != 0 {
+    s += fmt.Sprintf("%02d", sec%60)
+  }
+  return s
+}
+
+// writeICSLine writes s folded to lines of at most 75 octets, as RFC 5545
+// asks, without splitting a UTF-8 sequence.
+func writeICSLine(b *strings.Builder, s string) {
+  limit := 75
+  for len(s) > limit {
+    i := limit
+    for i > 0 && !utf8.RuneStart(s[i]) {
+      i--
+    }
+    b.WriteString(s[:i] + "\r\n ")
+    s = s[i:]
+    limit = 74 // The continuation's leading space counts.
+  }
+  b.WriteString(s + "\r\n")
+}
+
+// icsText escapes a TEXT property value.
+func icsText(s string) string {
+  return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+var (
+  cronMonthNames = map[string]int{"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6, "JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12}
+  cronDayNames   = map[string]int{"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6}
+  icsDays        = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+)
+
+// cronToRRule returns the next run after now, in the reminder's time
+// zone, and the RRULE for a cron reminder.
+// Only plain 5-field expressions made of numbers, names, ranges, steps
+// and lists are expressible, and not those restricting both the day of
+// month and the day of week, which cron ORs together.
+func cronToRRule(r Reminder, now time.Time) (next time.Time, rrule string, ok bool) {
+  f := strings.Fields(r.CronOriginal)
+  if len(f) != 5 {
+    return time.Time{}, "", false
+  }
+  mins, _, ok1 := cronField(f[0], 0, 59, nil)
+  hours, _, ok2 := cronField(f[1], 0, 23, nil)
+  doms, allDoms, ok3 := cronField(f[2], 1, 31, nil)
+  mons, allMons, ok4 := cronField(f[3], 1, 12, cronMonthNames)
+  dows, allDows, ok5 := cronField(f[4], 0, 7, cronDayNames)
+  if !(ok1 && ok2 && ok3 && ok4 && ok5) || (!allDoms && !allDows) {
+    return time.Time{}, "", false
+  }
+  loc, err := time.LoadLocation(r.TZ)
+  if err != nil {
+    return time.Time{}, "", false
+  }
+  expr, err := cronexpr.Parse(r.CronOriginal)
+  if err != nil {
+    return time.Time{}, "", false
+  }
+  next = expr.Next(now.In(loc))
+  if next.IsZero() {
+    return time.Time{}, "", false
+  }
+
+  parts := []string{"FREQ=DAILY", "BYHOUR=" + joinInts(hours), "BYMINUTE=" + joinInts(mins)}
+  if !allMons {
+    parts = append(parts, "BYMONTH="+joinInts(mons))
+  }
+  if !allDoms {
+    parts = append(parts, "BYMONTHDAY="+joinInts(doms))
+  }
+  if !allDows {
+    seen := make(map[string]bool)
+    var days []string
+    for _, d := range dows {
+      if day := icsDays[d%7]; !seen[day] {
+        seen[day] = true
+        days = append(days, day)
+      }
+    }
+    parts = append(parts, "BYDAY="+strings.Join(days, ","))
+  }
+  return next, strings.Join(parts, ";"), true
+}
+
+// cronField expands one cron field into its values. all reports a "*"
+// or "?" field.
+func cronField(s string, min, max int, names map[string]int) (vals []int, all, ok bool) {
+  if s == "*" || s == "?" {
+    all = true
+  }
+  value := func(v string) (int, bool) {
+    if n, ok := names[strings.ToUpper(v)]; ok {
+      return n, true
+    }
+    n, err := strconv.Atoi(v)
+    return n, err == nil && n >= min && n <= max
+  }
+  set := make(map[int]bool)
+  for _, part := range strings.Split(s, ",") {
+    rng, stepStr, hasStep := strings.Cut(part, "/")
+    step := 1
+    if hasStep {
+      n, err := strconv.Atoi(stepStr)
+      if err != nil || n < 1 {
+        return nil, false, false
+      }
+      step = n
+    }
+    lo, hi := min, max
+    if rng != "*" && rng != "?" {
+      a, b, isRange := strings.Cut(rng, "-")
+      var ok1, ok2 bool
+      if lo, ok1 = value(a); !ok1 {
+        return nil, false, false
+      }
+      hi, ok2 = lo, true
+      if isRange {
+        hi, ok2 = value(b)
+      } else if hasStep {
+        hi = max
+      }
+      if !ok2 || hi < lo {
+        return nil, false, false
+      }
+    }
+    for v := lo; v <= hi; v += step {
+      set[v] = true
+    }
+  }
+  for v := range set {
+    vals = append(vals, v)
+  }
+  sort.Ints(vals)
+  return vals, all, true
+}
+
+func joinInts(vals []int) string {
+  s := make([]string, len(vals))
+  for i, v := range vals {
+    s[i] = strconv.Itoa(v)
+  }
+  return strings.Join(s, ",")
+}
+
+// sendICS sends the chat's reminders as reminders.ics.
+func sendICS(chatID int64) {
+  data, skipped := buildICS(chatID, time.Now().UTC())
+  lang := getUserData(chatID).Lang
+  caption := tr(lang, "export_ics")
+  if skipped > 0 {
+    caption += "\n" + tr(lang, "export_ics_skipped", Count{skipped, "reminders"})
+  }
+  if _, err := messenger.SendDocument(chatID, "reminders.ics", data, caption); err != nil {
+    slog.Error("send ics failed", "chat_id", chatID, "err", err)
+  }
+}
+
+// --------- iCalendar Feed ---------
+
+// A chat can publish its reminders at a secret URL on the API server, for
+// calendar apps to subscribe to. Feeds are stored like API tokens.
+
+// feedURL returns the public URL of a feed secret.
+func feedURL(secret string) string {
+  return strings.TrimSuffix(config().APIPublicURL, "/") + "/ics/" + secret + ".ics"
+}
+
+// createFeed replaces the chat's feed secret with a new one and returns it.
+func createFeed(chatID, userID int64) string {
+  buf := make([]byte, 24)
+  rand.Read(buf)
+  secret := base64.RawURLEncoding.EncodeToString(buf)
+  store.mu.Lock()
+  revokeFeedLocked(chatID)
+  store.Feeds[hashToken(secret)] = APIToken{ChatID: chatID, UserID: userID, Created: time.Now().UTC()}
+  store.mu.Unlock()
+  saveStorage()
+  slog.Info("ics feed created", "chat_id", chatID, "user_id", userID)
+  return secret
+}
+
+// revokeFeed removes the chat's feed and reports whether it had one.
+func revokeFeed(chatID int64) bool {
+  store.mu.Lock()
+  n := revokeFeedLocked(chatID)
+  store.mu.Unlock()
+  if n > 0 {
+    saveStorage()
+  }
+  return n > 0
+}
+
+// revokeFeedLocked is revokeFeed without saving. store.mu must be held.
+func revokeFeedLocked(chatID int64) int {
+  n := 0
+  for h, f := range store.Feeds {
+    if f.ChatID == chatID {
+      delete(store.Feeds, h)
+      n++
+    }
+  }
+  return n
+}
+
+// handleFeedCommand implements /export feed and /export feed revoke.
+func handleFeedCommand(chatID, userID int64, args string) {
+  if config().APIListen == "" {
+    sendText(chatID, "token_disabled")
+    return
+  }
+  switch args {
+  case "":
+    // Without it the link would be a bare path no calendar can open.
+    if config().APIPublicURL == "" {
+      sendText(chatID, "feed_no_public_url")
+      return
+    }
+    sendText(chatID, "feed_created", feedURL(createFeed(chatID, userID)))
+  case "revoke":
+    if revokeFeed(chatID) {
+      sendText(chatID, "feed_revoked")
+    } else {
+      sendText(chatID, "feed_none")
+    }
+  default:
+    sendText(chatID, "export_usage")
+  }
+}
+
+// handleFeed serves /ics/<secret>.ics, built fresh from the stored
+// reminders on every request.
+func handleFeed(w http.ResponseWriter, r *http.Request) {
+  secret := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/ics/"), ".ics")
+  store.mu.Lock()
+  f, ok := store.Feeds[hashToken(secret)]
+  store.mu.Unlock()
+  if secret == "" || !ok || !hasAccess(f.ChatID, f.UserID) {
+    http.NotFound(w, r)
+    return
+  }
+  data, _ := buildICS(f.ChatID, time.Now().UTC())
+  w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+  w.Header().Set("Cache-Control", "no-cache")
+  w.Write(data)
+}
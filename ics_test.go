@@ -0,0 +1,58 @@
+package main
+
+import (
+  "bytes"
+  "flag"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestBuildICSGolden(t *testing.T) {
+  setupTest(t)
+  const chatID = 81
+  cron := func(id int, name, spec, tz string) Reminder {
+    return Reminder{ID: id, Name: name, CronOriginal: spec, CronExpr: spec, TZ: tz}
+  }
+  updateUserData(chatID, func(ud *UserData) {
+    ud.UTC = 8
+    ud.Reminders = []Reminder{
+      {ID: 1, Name: "Dentist; bring card, insurance", Date: "02/03/2030", Time: "9:30 am", OptInfo: "Dr. Li\nRoom 4\\B"},
+      {ID: 2, Name: "牙医复诊，记得带上医保卡和上次的检查报告，提前十分钟到达前台登记", Date: "03/03/2030", Time: "2:00 pm"},
+      cron(3, "Standup", "0 9 * * MON-FRI", "Europe/Berlin"),
+      cron(4, "Rent", "30 7 1 * *", "Asia/Shanghai"),
+      cron(5, "Backup", "0 3 * * *", "UTC"),
+      cron(6, "Review", "0 16 * JAN,JUL SUN", "Europe/Berlin"),
+      cron(7, "Payday", "0 9 1 * MON", "Europe/Berlin"), // Not expressible
+      {ID: 8, Name: "Paused", Date: "04/03/2030", Time: "8:00 am", Paused: true},
+    }
+  })
+
+  data, skipped := buildICS(chatID, time.Date(2030, 3, 1, 12, 0, 0, 0, time.UTC))
+  if skipped != 1 {
+    t.Errorf("skipped %d, want 1", skipped)
+  }
+  for _, l := range strings.SplitAfter(string(data), "\r\n") {
+    if len(l) > 77 {
+      t.Errorf("line of %d octets: %q", len(l)-2, l)
+    }
+  }
+
+  golden := filepath.Join("testdata", "export.ics")
+  if *update {
+    if err := os.WriteFile(golden, data, 0644); err != nil {
+      t.Fatal(err)
+    }
+  }
+  want, err := os.ReadFile(golden)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !bytes.Equal(data, want) {
+    t.Fatalf("export differs from %s, rerun with -update to see the change:\n%s", golden, data)
+  }
+}
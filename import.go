@@ -0,0 +1,802 @@
+package main
+
+import (
+  "bytes"
+  "encoding/csv"
+  "errors"
+  "fmt"
+  "math"
+  "path/filepath"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+// --------- Import ---------
+
+// A chat can send a .ics or .csv file to add its events as reminders, or
+// the .json file of /export to restore it. The file is turned into a
+// preview, and nothing is stored until the preview's button confirms it.
+// Every event becomes API input and goes through the same validation as a
+// reminder created over HTTP.
+
+const (
+  maxImportSize      = 1 << 20 // Bytes
+  importTTL          = 15 * time.Minute
+  importPreviewLines = 10
+  importSkipLines    = 5
+)
+
+var (
+  errImportPast = errors.New("event is in the past")
+  errImportRule = errors.New("repeat rule not supported")
+)
+
+// importZoneError is a TZID that matches no known time zone.
+type importZoneError struct{ Zone string }
+
+func (e importZoneError) Error() string { return fmt.Sprintf("unknown time zone %q", e.Zone) }
+
+// importItem is one event of an imported file.
+type importItem struct {
+  Label    string // Shown next to the reason if the event is skipped
+  Input    apiReminderInput
+  Channels []string // Channels of an exported reminder, which may be restored with it
+  Paused   bool
+  Err      error // Why the event cannot be imported
+}
+
+// importRestore is what an /export file restores besides reminders.
+type importRestore struct {
+  Lang     string
+  UTC      int
+  Digest   *DigestSettings
+  Quiet    *QuietHours
+  Channels []Channel
+}
+
+// importBatch is a confirmed-pending preview.
+type importBatch struct {
+  Seq       int
+  Reminders []Reminder
+  Restore   *importRestore
+  Created   time.Time
+}
+
+var (
+  importMu       sync.Mutex
+  importSeq      int
+  pendingImports = make(map[int64]importBatch) // By chat, one preview each
+)
+
+// handleImportDocument parses a file sent to the chat and previews it.
+func handleImportDocument(msg *InMessage) {
+  chatID, doc := msg.ChatID, msg.Document
+  ext := strings.ToLower(filepath.Ext(doc.Name))
+  if ext != ".ics" && ext != ".csv" && ext != ".json" {
+    sendText(chatID, "import_unsupported")
+    return
+  }
+  if doc.Size > maxImportSize {
+    sendText(chatID, "import_too_large", maxImportSize>>10)
+    return
+  }
+  data, err := doc.Fetch()
+  if err != nil {
+    msg.log().Warn("import download failed", "file", doc.Name, "err", err)
+    sendText(chatID, "import_failed")
+    return
+  }
+  if len(data) > maxImportSize {
+    sendText(chatID, "import_too_large", maxImportSize>>10)
+    return
+  }
+  var items []importItem
+  var restore *importRestore
+  switch ext {
+  case ".ics":
+    items, err = parseICSImport(data, getUserData(chatID).UTC)
+  case ".csv":
+    items, err = parseCSVImport(data)
+  default:
+    items, restore, err = parseExportImport(data)
+  }
+  if err != nil {
+    sendText(chatID, "import_invalid", doc.Name, err)
+    return
+  }
+  msg.log().Info("import parsed", "file", doc.Name, "events", len(items))
+  previewImport(chatID, doc.Name, items, restore)
+}
+
+// previewImport validates the items and sends what would be imported,
+// with buttons to confirm or cancel.
+func previewImport(chatID int64, name string, items []importItem, restore *importRestore) {
+  ud := getUserData(chatID)
+  channels := append([]Channel(nil), ud.Channels...)
+  if restore != nil {
+    for _, c := range restore.Channels {
+      if findChannel(UserData{Channels: channels}, c.Name) < 0 {
+        channels = append(channels, c)
+      }
+    }
+  }
+  lang := ud.Lang
+  now := time.Now().UTC()
+  var rems []Reminder
+  var skipped []string
+  dups := 0
+  for _, it := range items {
+    if it.Err == nil {
+      var rem Reminder
+      it.Err = it.Input.apply(chatID, &rem)
+      for _, c := range it.Channels {
+        if it.Err == nil && findChannel(UserData{Channels: channels}, c) < 0 {
+          it.Err = fmt.Errorf("no channel named %q", c)
+        }
+      }
+      rem.Channels, rem.Paused = it.Channels, it.Paused
+      if it.Err == nil && rem.CronExpr == "" {
+        at, _ := reminderWallClock(rem)
+        if at.Add(-time.Duration(ud.UTC) * time.Hour).Add(-config().notifyLead()).Before(now) {
+          it.Err = errImportPast
+        }
+      }
+      if it.Err == nil {
+        if hasReminder(ud.Reminders, rem) || hasReminder(rems, rem) {
+          dups++
+        } else {
+          rems = append(rems, rem)
+        }
+        continue
+      }
+    }
+    skipped = append(skipped, render("• %s: %s", it.Label, HTML(importReason(lang, it.Err))))
+  }
+  over := 0
+  max := config().Limits.maxReminders()
+  if room := max - len(ud.Reminders); len(rems) > room {
+    if room < 0 {
+      room = 0
+    }
+    over = len(rems) - room
+    rems = rems[:room]
+  }
+
+  text := tr(lang, "import_preview", name, Count{len(rems), "reminders"})
+  for i, r := range rems {
+    if i == importPreviewLines {
+      text += "\n" + tr(lang, "import_preview_more", Count{len(rems) - i, "reminders"})
+      break
+    }
+    text += "\n" + render("%d) %s   %s", i+1, r.Name, HTML(scheduleText(lang, r)))
+  }
+  if dups > 0 {
+    text += "\n\n" + tr(lang, "import_duplicates", Count{dups, "events"})
+  }
+  if over > 0 {
+    text += "\n\n" + tr(lang, "import_over_limit", Count{over, "events"}, Count{max, "reminders"})
+  }
+  if len(skipped) > 0 {
+    text += "\n\n" + tr(lang, "import_skipped", Count{len(skipped), "events"})
+    for i, line := range skipped {
+      if i == importSkipLines {
+        text += "\n…"
+        break
+      }
+      text += "\n" + line
+    }
+  }
+  if restore != nil {
+    text += "\n\n" + tr(lang, "import_restore", Count{len(channels) - len(ud.Channels), "channels"})
+  }
+  if len(rems) == 0 && restore == nil {
+    messenger.SendText(chatID, text)
+    return
+  }
+
+  importMu.Lock()
+  importSeq++
+  seq := importSeq
+  pendingImports[chatID] = importBatch{Seq: seq, Reminders: rems, Restore: restore, Created: time.Now()}
+  importMu.Unlock()
+  kb := newKeyboard(newRow(
+    callbackButton(chatID, plainText(lang, "btn_import"), cbImport, "ok", seq),
+    callbackButton(chatID, plainText(lang, "btn_import_cancel"), cbImport, "no", seq),
+  ))
+  messenger.SendKeyboard(chatID, text, kb)
+}
+
+// importReason localizes why an event was skipped.
+func importReason(lang string, err error) string {
+  var ze importZoneError
+  switch {
+  case errors.Is(err, errImportPast):
+    return tr(lang, "import_reason_past")
+  case errors.Is(err, errImportRule):
+    return tr(lang, "import_reason_rule")
+  case errors.As(err, &ze):
+    return tr(lang, "import_reason_zone", ze.Zone)
+  default:
+    return render("%s", err)
+  }
+}
+
+// hasReminder reports whether rems has a reminder with r's name and
+// schedule.
+func hasReminder(rems []Reminder, r Reminder) bool {
+  for _, x := range rems {
+    if x.Name == r.Name && x.Date == r.Date && x.Time == r.Time && x.CronOriginal == r.CronOriginal && x.TZ == r.TZ {
+      return true
+    }
+  }
+  return false
+}
+
+// takeImport removes and returns the chat's pending preview if it is the
+// one with seq and has not expired.
+func takeImport(chatID int64, seq int) (importBatch, bool) {
+  importMu.Lock()
+  defer importMu.Unlock()
+  b, ok := pendingImports[chatID]
+  if !ok || b.Seq != seq {
+    return importBatch{}, false
+  }
+  delete(pendingImports, chatID)
+  return b, time.Since(b.Created) < importTTL
+}
+
+func handleImportCallback(q *InCallback, cb callback) error {
+  a := cb.args()
+  action := a.word("ok", "no")
+  seq := a.int(1, math.MaxInt32)
+  if err := a.end(); err != nil {
+    return err
+  }
+  b, ok := takeImport(q.ChatID, seq)
+  messenger.EditKeyboard(q.ChatID, q.MessageID, nil)
+  if !ok {
+    return errCallbackStale
+  }
+  if action == "no" {
+    sendText(q.ChatID, "import_cancelled")
+    return nil
+  }
+  var rejected []rejectedChannel
+  if b.Restore != nil {
+    rejected = restoreSettings(q.ChatID, b.Restore)
+  }
+  n := importReminders(q.ChatID, b.Reminders)
+  q.log().Info("reminders imported", "count", n, "channels_rejected", len(rejected))
+  sendText(q.ChatID, "import_done", Count{n, "reminders"})
+  for _, c := range rejected {
+    sendText(q.ChatID, "import_channel_rejected", c.Name, c.Err.Error())
+  }
+  return nil
+}
+
+// rejectedChannel is a channel of an /export file that failed validation.
+type rejectedChannel struct {
+  Name string
+  Err  error
+}
+
+// restoreSettings applies the settings of an /export file and adds its
+// channels the chat does not have yet. Channels that fail validation, as
+// when added with /channels, are left out and returned.
+func restoreSettings(chatID int64, r *importRestore) []rejectedChannel {
+  var rejected []rejectedChannel
+  updateUserData(chatID, func(ud *UserData) {
+    if r.Lang == "en" || r.Lang == "zh" {
+      ud.Lang = r.Lang
+    }
+    if r.UTC >= -12 && r.UTC <= 14 {
+      ud.UTC = r.UTC
+    }
+    if r.Digest != nil {
+      ud.Digest = r.Digest
+    }
+    if r.Quiet != nil {
+      ud.Quiet = r.Quiet
+    }
+    var list []Channel
+    for _, c := range r.Channels {
+      if findChannel(*ud, c.Name) >= 0 {
+        continue
+      }
+      if err := c.validate(); err != nil {
+        rejected = append(rejected, rejectedChannel{c.Name, err})
+        continue
+      }
+      list = append(list, c)
+    }
+    if len(list) > 0 {
+      ud.Channels = append(append([]Channel(nil), ud.Channels...), list...)
+    }
+  })
+  updateSecrets(config())
+  return rejected
+}
+
+// importReminders stores and schedules rems, as many as the chat's limit
+// still allows, and returns how many that was.
+func importReminders(chatID int64, rems []Reminder) int {
+  active := true
+  updateUserData(chatID, func(ud *UserData) {
+    if room := config().Limits.maxReminders() - len(ud.Reminders); len(rems) > room {
+      if room < 0 {
+        room = 0
+      }
+      rems = rems[:room]
+    }
+    used := make(map[int]bool)
+    for _, r := range ud.Reminders {
+      used[r.ID] = true
+    }
+    for i := range rems {
+      id := int(time.Now().UnixNano() % 1e6)
+      for used[id] {
+        id = (id + 1) % 1e6
+      }
+      used[id] = true
+      rems[i].ID = id
+    }
+    ud.Reminders = append(ud.Reminders, rems...)
+    active = ud.InactiveSince == nil
+  })
+  if active {
+    for _, r := range rems {
+      scheduleReminder(chatID, r)
+    }
+  }
+  return len(rems)
+}
+
+// --------- CSV Import ---------
+
+// parseCSVImport reads a CSV file whose header names the columns, using
+// the API's field names: name, info, date, time, cron and tz.
+func parseCSVImport(data []byte) ([]importItem, error) {
+  r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+  r.FieldsPerRecord = -1
+  r.TrimLeadingSpace = true
+  rows, err := r.ReadAll()
+  if err != nil {
+    return nil, err
+  }
+  if len(rows) == 0 {
+    return nil, errors.New("the file is empty")
+  }
+  cols := make(map[string]int)
+  for i, h := range rows[0] {
+    h = strings.ToLower(strings.TrimSpace(h))
+    switch h {
+    case "name", "info", "date", "time", "cron", "tz":
+      cols[h] = i
+    default:
+      return nil, fmt.Errorf("unknown column %q, expected name, info, date, time, cron and tz", h)
+    }
+  }
+  if _, ok := cols["name"]; !ok {
+    return nil, errors.New("the header has no name column")
+  }
+  var items []importItem
+  for n, row := range rows[1:] {
+    cell := func(col string) *string {
+      i, ok := cols[col]
+      if !ok || i >= len(row) || strings.TrimSpace(row[i]) == "" {
+        return nil
+      }
+      v := strings.TrimSpace(row[i])
+      return &v
+    }
+    in := apiReminderInput{Name: cell("name"), Info: cell("info"), Date: cell("date"), Time: cell("time"), Cron: cell("cron"), TZ: cell("tz")}
+    label := fmt.Sprintf("line %d", n+2)
+    if in.Name != nil {
+      label = *in.Name
+    }
+    items = append(items, importItem{Label: label, Input: in})
+  }
+  return items, nil
+}
+
+// --------- iCalendar Import ---------
+
+// icsProp is one unfolded content line.
+type icsProp struct {
+  Name   string
+  Params map[string]string
+  Value  string
+}
+
+// icsEvent holds the properties of a VEVENT that matter here.
+type icsEvent struct {
+  Summary, Description, RRule string
+  Start                       icsProp
+  Alarm                       *time.Duration // Offset of the first alarm from the start
+  Skip                        bool           // Cancelled, or an exception to a recurring event
+}
+
+// parseICSImport reads the VEVENTs of an iCalendar file. Floating times
+// are taken in the chat's UTC offset.
+func parseICSImport(data []byte, utcOffset int) ([]importItem, error) {
+  props := parseICSProps(data)
+  if len(props) == 0 || props[0].Name != "BEGIN" || !strings.EqualFold(props[0].Value, "VCALENDAR") {
+    return nil, errors.New("not an iCalendar file")
+  }
+  var items []importItem
+  var ev *icsEvent
+  inAlarm := false
+  for _, p := range props {
+    switch {
+    case p.Name == "BEGIN" && strings.EqualFold(p.Value, "VEVENT"):
+      ev = &icsEvent{}
+    case ev == nil:
+    case p.Name == "END" && strings.EqualFold(p.Value, "VEVENT"):
+      if !ev.Skip {
+        items = append(items, icsEventItem(*ev, utcOffset))
+      }
+      ev = nil
+    case p.Name == "BEGIN" && strings.EqualFold(p.Value, "VALARM"):
+      inAlarm = true
+    case p.Name == "END" && strings.EqualFold(p.Value, "VALARM"):
+      inAlarm = false
+    case inAlarm:
+      if p.Name == "TRIGGER" && ev.Alarm == nil && p.Params["VALUE"] != "DATE-TIME" && p.Params["RELATED"] != "END" {
+        if d, ok := parseICSDuration(p.Value); ok {
+          ev.Alarm = &d
+        }
+      }
+    case p.Name == "SUMMARY":
+      ev.Summary = icsUnescape(p.Value)
+    case p.Name == "DESCRIPTION":
+      ev.Description = icsUnescape(p.Value)
+    case p.Name == "DTSTART":
+      ev.Start = p
+    case p.Name == "RRULE":
+      ev.RRule = p.Value
+    case p.Name == "RECURRENCE-ID", p.Name == "STATUS" && strings.EqualFold(p.Value, "CANCELLED"):
+      ev.Skip = true
+    }
+  }
+  if len(items) == 0 {
+    return nil, errors.New("the file has no events")
+  }
+  return items, nil
+}
+
+// parseICSProps unfolds the file's lines and splits them into properties.
+func parseICSProps(data []byte) []icsProp {
+  text := strings.ReplaceAll(string(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))), "\r\n", "\n")
+  text = strings.NewReplacer("\n ", "", "\n\t", "").Replace(text)
+  var props []icsProp
+  for _, line := range strings.Split(text, "\n") {
+    // The value starts at the first colon outside a quoted parameter.
+    quoted, colon := false, -1
+    for i := 0; i < len(line) && colon < 0; i++ {
+      switch line[i] {
+      case '"':
+        quoted = !quoted
+      case ':':
+        if !quoted {
+          colon = i
+        }
+      }
+    }
+    if colon < 0 {
+      continue
+    }
+    head := strings.Split(line[:colon], ";")
+    p := icsProp{Name: strings.ToUpper(head[0]), Params: make(map[string]string), Value: line[colon+1:]}
+    for _, param := range head[1:] {
+      if k, v, ok := strings.Cut(param, "="); ok {
+        p.Params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+      }
+    }
+    props = append(props, p)
+  }
+  return props
+}
+
+func icsUnescape(s string) string {
+  return strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n").Replace(s)
+}
+
+// parseICSDuration parses a DURATION value such as "-PT15M" or "-P1DT2H".
+func parseICSDuration(s string) (time.Duration, bool) {
+  sign := time.Duration(1)
+  switch {
+  case strings.HasPrefix(s, "-"):
+    sign, s = -1, s[1:]
+  case strings.HasPrefix(s, "+"):
+    s = s[1:]
+  }
+  if !strings.HasPrefix(s, "P") || len(s) < 3 {
+    return 0, false
+  }
+  units := map[byte]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour, 'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+  var d time.Duration
+  n := -1
+  for i := 1; i < len(s); i++ {
+    c := s[i]
+    switch {
+    case c == 'T':
+    case c >= '0' && c <= '9':
+      if n < 0 {
+        n = 0
+      }
+      n = n*10 + int(c-'0')
+    case units[c] != 0 && n >= 0:
+      d += time.Duration(n) * units[c]
+      n = -1
+    default:
+      return 0, false
+    }
+  }
+  return sign * d, n < 0
+}
+
+// icsEventItem converts an event to API input. The bot notifies a
+// one-time reminder notify_lead_minutes before its time, so the time is
+// moved to make that the moment of the event's alarm; cron reminders fire
+// at the alarm itself. All-day events without an alarm notify at 9:00.
+func icsEventItem(ev icsEvent, utcOffset int) importItem {
+  it := importItem{Label: ev.Summary}
+  name := ev.Summary
+  it.Input.Name = &name
+  if ev.Description != "" {
+    info := ev.Description
+    it.Input.Info = &info
+  }
+  start, tz, allDay, err := icsStart(ev.Start, utcOffset)
+  if err != nil {
+    it.Err = err
+    return it
+  }
+  var alarm time.Duration
+  if ev.Alarm != nil {
+    alarm = *ev.Alarm
+  } else if allDay {
+    alarm = 9 * time.Hour
+  }
+
+  if ev.RRule == "" {
+    at := start.Add(alarm)
+    if ev.Alarm != nil || allDay {
+      at = at.Add(config().notifyLead())
+    }
+    local := at.UTC().Add(time.Duration(utcOffset) * time.Hour)
+    date, clock := local.Format("2006-01-02"), local.Format("15:04")
+    it.Input.Date, it.Input.Time = &date, &clock
+    return it
+  }
+  spec, err := rruleToCron(ev.RRule, start, start.Add(alarm))
+  if err != nil {
+    it.Err = err
+    return it
+  }
+  it.Input.Cron, it.Input.TZ = &spec, &tz
+  return it
+}
+
+// icsStart parses DTSTART and returns it with the name of its zone.
+func icsStart(p icsProp, utcOffset int) (start time.Time, tz string, allDay bool, err error) {
+  v := p.Value
+  switch {
+  case v == "":
+    return time.Time{}, "", false, errors.New("event has no start")
+  case p.Params["VALUE"] == "DATE" || len(v) == 8:
+    allDay = true
+    v += "T000000"
+  }
+  var loc *time.Location
+  switch {
+  case strings.HasSuffix(v, "Z"):
+    loc, tz, v = time.UTC, "UTC", strings.TrimSuffix(v, "Z")
+  case p.Params["TZID"] != "":
+    if loc, err = icsZone(p.Params["TZID"]); err != nil {
+      return time.Time{}, "", false, err
+    }
+    tz = loc.String()
+  default:
+    // Floating time, in the chat's offset.
+    tz = offsetZoneName(utcOffset)
+    loc = time.FixedZone(tz, utcOffset*3600)
+  }
+  start, err = time.ParseInLocation("20060102T150405", v, loc)
+  if err != nil {
+    return time.Time{}, "", false, fmt.Errorf("invalid start %q", p.Value)
+  }
+  return start, tz, allDay, nil
+}
+
+// offsetZoneName returns the IANA name of a whole-hour UTC offset.
+func offsetZoneName(offset int) string {
+  if offset == 0 {
+    return "UTC"
+  }
+  // The Etc zones count west of Greenwich as positive.
+  return fmt.Sprintf("Etc/GMT%+d", -offset)
+}
+
+// windowsZones maps the Windows zone names Outlook writes as TZID to
+// IANA zones.
+var windowsZones = map[string]string{
+  "Dateline Standard Time":          "Etc/GMT+12",
+  "Hawaiian Standard Time":          "Pacific/Honolulu",
+  "Alaskan Standard Time":           "America/Anchorage",
+  "Pacific Standard Time":           "America/Los_Angeles",
+  "Mountain Standard Time":          "America/Denver",
+  "Central Standard Time":           "America/Chicago",
+  "Eastern Standard Time":           "America/New_York",
+  "Atlantic Standard Time":          "America/Halifax",
+  "E. South America Standard Time":  "America/Sao_Paulo",
+  "GMT Standard Time":               "Europe/London",
+  "Greenwich Standard Time":         "Atlantic/Reykjavik",
+  "W. Europe Standard Time":         "Europe/Berlin",
+  "Central Europe Standard Time":    "Europe/Budapest",
+  "Central European Standard Time":  "Europe/Warsaw",
+  "Romance Standard Time":           "Europe/Paris",
+  "E. Europe Standard Time":         "Europe/Chisinau",
+  "FLE Standard Time":               "Europe/Kiev",
+  "GTB Standard Time":               "Europe/Bucharest",
+  "Russian Standard Time":           "Europe/Moscow",
+  "Turkey Standard Time":            "Europe/Istanbul",
+  "Israel Standard Time":            "Asia/Jerusalem",
+  "Arabian Standard Time":           "Asia/Dubai",
+  "India Standard Time":             "Asia/Kolkata",
+  "SE Asia Standard Time":           "Asia/Bangkok",
+  "China Standard Time":             "Asia/Shanghai",
+  "Singapore Standard Time":         "Asia/Singapore",
+  "Taipei Standard Time":            "Asia/Taipei",
+  "Tokyo Standard Time":             "Asia/Tokyo",
+  "Korea Standard Time":             "Asia/Seoul",
+  "AUS Eastern Standard Time":       "Australia/Sydney",
+  "New Zealand Standard Time":       "Pacific/Auckland",
+  "UTC":                             "UTC",
+  "Coordinated Universal Time":      "UTC",
+}
+
+// icsZone maps a TZID to a location. Besides IANA names it accepts
+// Windows names and prefixed names such as "/mozilla.org/20050126_1/Europe/Berlin".
+func icsZone(tzid string) (*time.Location, error) {
+  name := tzid
+  if iana, ok := windowsZones[name]; ok {
+    name = iana
+  }
+  for name != "" && name != "Local" {
+    if loc, err := time.LoadLocation(name); err == nil {
+      return loc, nil
+    }
+    i := strings.IndexByte(name, '/')
+    if i < 0 {
+      break
+    }
+    name = name[i+1:]
+  }
+  return nil, importZoneError{tzid}
+}
+
+// rruleToCron converts an RRULE to a cron expression firing at fire, the
+// first occurrence's alarm. Only rules a 5-field cron expression can
+// express are accepted: no intervals, ends, ordinal weekdays or set
+// positions, and not both BYMONTHDAY and BYDAY, which cron ORs together.
+func rruleToCron(rule string, start, fire time.Time) (string, error) {
+  parts := make(map[string]string)
+  for _, kv := range strings.Split(rule, ";") {
+    k, v, _ := strings.Cut(kv, "=")
+    parts[strings.ToUpper(k)] = strings.ToUpper(v)
+  }
+  for k, v := range parts {
+    switch k {
+    case "FREQ", "BYMONTH", "BYMONTHDAY", "BYDAY", "WKST":
+    case "INTERVAL":
+      if v != "1" {
+        return "", errImportRule
+      }
+    default:
+      return "", errImportRule
+    }
+  }
+  dom, mon, dow := parts["BYMONTHDAY"], parts["BYMONTH"], ""
+  if dom != "" && !cronList(dom, 1, 31) || mon != "" && !cronList(mon, 1, 12) {
+    return "", errImportRule
+  }
+  if days := parts["BYDAY"]; days != "" {
+    var nums []int
+    for _, d := range strings.Split(days, ",") {
+      n := indexOf(icsDays, d)
+      if n < 0 {
+        return "", errImportRule // Ordinal weekdays such as 1MO
+      }
+      nums = append(nums, n)
+    }
+    dow = weekdayList(nums, 0)
+  }
+  switch parts["FREQ"] {
+  case "DAILY":
+  case "WEEKLY":
+    if dow == "" {
+      dow = strconv.Itoa(int(start.Weekday()))
+    }
+  case "MONTHLY":
+    if dom == "" && dow == "" {
+      dom = strconv.Itoa(start.Day())
+    }
+  case "YEARLY":
+    if mon == "" {
+      mon = strconv.Itoa(int(start.Month()))
+    }
+    if dom == "" && dow == "" {
+      dom = strconv.Itoa(start.Day())
+    }
+  default:
+    return "", errImportRule
+  }
+  if dom != "" && dow != "" {
+    return "", errImportRule
+  }
+
+  // An alarm on another day than the event moves weekdays along with it;
+  // days of the month cannot be moved.
+  y1, m1, d1 := start.Date()
+  y2, m2, d2 := fire.Date()
+  shift := int(time.Date(y2, m2, d2, 0, 0, 0, 0, time.UTC).Sub(time.Date(y1, m1, d1, 0, 0, 0, 0, time.UTC)) / (24 * time.Hour))
+  if shift != 0 {
+    if dom != "" || mon != "" {
+      return "", errImportRule
+    }
+    if dow != "" {
+      var nums []int
+      for _, d := range strings.Split(dow, ",") {
+        n, _ := strconv.Atoi(d)
+        nums = append(nums, n)
+      }
+      dow = weekdayList(nums, shift)
+    }
+  }
+  or := func(s string) string {
+    if s == "" {
+      return "*"
+    }
+    return s
+  }
+  return fmt.Sprintf("%d %d %s %s %s", fire.Minute(), fire.Hour(), or(dom), or(mon), or(dow)), nil
+}
+
+// cronList reports whether s is a comma-separated list of numbers in
+// [min, max].
+func cronList(s string, min, max int) bool {
+  for _, v := range strings.Split(s, ",") {
+    n, err := strconv.Atoi(v)
+    if err != nil || n < min || n > max {
+      return false
+    }
+  }
+  return true
+}
+
+// weekdayList moves each weekday by shift days and joins them, sorted.
+func weekdayList(days []int, shift int) string {
+  set := make(map[int]bool)
+  for _, d := range days {
+    set[((d+shift)%7+7)%7] = true
+  }
+  var out []int
+  for d := range set {
+    out = append(out, d)
+  }
+  sort.Ints(out)
+  return joinInts(out)
+}
+
+func indexOf(list []string, s string) int {
+  for i, v := range list {
+    if v == s {
+      return i
+    }
+  }
+  return -1
+}
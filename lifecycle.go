@@ -0,0 +1,81 @@
+package main
+
+import (
+  "context"
+  "log/slog"
+  "os"
+  "time"
+
+  tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --------- Lifecycle ---------
+
+// defaultShutdownTimeout stays below Docker's 10 second stop grace period.
+const defaultShutdownTimeout = 8 * time.Second
+
+// runUpdates hands updates to pool until the channel closes or ctx is done.
+func runUpdates(ctx context.Context, updates tgbotapi.UpdatesChannel, pool *updatePool) {
+  for {
+    select {
+    case upd, ok := <-updates:
+      if !ok {
+        return
+      }
+      pool.Submit(upd)
+    case <-ctx.Done():
+      return
+    }
+  }
+}
+
+// drainUpdates handles updates that were already received when the
+// update source was stopped.
+func drainUpdates(updates tgbotapi.UpdatesChannel, pool *updatePool) {
+  for {
+    select {
+    case upd, ok := <-updates:
+      if !ok {
+        return
+      }
+      pool.Submit(upd)
+    default:
+      return
+    }
+  }
+}
+
+// shutdown stops the update source, drains pending updates and the
+// workers handling them, stops the scheduler and flushes storage, wizard
+// sessions included. If it does not finish within timeout the process
+// exits with status 1.
+func shutdown(timeout time.Duration, stopUpdates func(ctx context.Context), updates tgbotapi.UpdatesChannel, pool *updatePool) {
+  ready.Store(false)
+  slog.Info("shutting down", "deadline", timeout)
+  ctx, cancel := context.WithTimeout(context.Background(), timeout)
+  defer cancel()
+  go func() {
+    <-ctx.Done()
+    if ctx.Err() == context.DeadlineExceeded {
+      slog.Error("shutdown deadline exceeded, exiting")
+      os.Exit(1)
+    }
+  }()
+
+  if stopUpdates != nil {
+    stopUpdates(ctx)
+  }
+  if updates != nil {
+    drainUpdates(updates, pool)
+  }
+  if pool != nil {
+    pool.Close()
+  }
+  if !stopScheduler(ctx.Done()) {
+    slog.Warn("gave up waiting for in-flight notifications")
+  }
+  if err := saveStorage(); err != nil {
+    slog.Error("save storage failed", "path", config().DataPath, "err", err)
+  }
+  slog.Info("shutdown complete")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "log/slog"
+  "path/filepath"
+  "strings"
+  "sync"
+)
+
+// --------- Locale Overrides ---------
+
+// Operators can reword any entry of messages without rebuilding: a file
+// <lang>.json in locales_dir maps message keys to replacement texts.
+// Overrides are read at startup and again on /reload.
+
+var (
+  localeMu        sync.RWMutex
+  localeOverrides map[string]map[string]string // key -> lang -> text
+)
+
+// override returns the operator's text for key in lang, if any.
+func override(key, lang string) (string, bool) {
+  localeMu.RLock()
+  defer localeMu.RUnlock()
+  s, ok := localeOverrides[key][lang]
+  return s, ok
+}
+
+// loadLocales replaces the overrides with the files in dir. An empty dir
+// drops all overrides. Entries that would break a message are rejected.
+func loadLocales(dir string) (int, error) {
+  loaded := make(map[string]map[string]string)
+  n := 0
+  if dir != "" {
+    files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+    if err != nil {
+      return 0, err
+    }
+    for _, path := range files {
+      lang := strings.TrimSuffix(filepath.Base(path), ".json")
+      if lang != "en" && lang != "zh" {
+        slog.Warn("locale file for unsupported language ignored", "path", path)
+        continue
+      }
+      bs, err := ioutil.ReadFile(path)
+      if err != nil {
+        return 0, err
+      }
+      var entries map[string]string
+      if err := json.Unmarshal(bs, &entries); err != nil {
+        return 0, fmt.Errorf("%s: %w", path, err)
+      }
+      for key, text := range entries {
+        builtin, ok := messages[key]
+        if !ok {
+          return 0, fmt.Errorf("%s: unknown message key %q", path, key)
+        }
+        // The text is formatted with the built-in arguments.
+        if strings.Count(text, "%") != strings.Count(builtin["en"], "%") {
+          return 0, fmt.Errorf("%s: %q must keep the placeholders of %q", path, key, builtin["en"])
+        }
+        if err := checkMarkup(text); err != nil {
+          return 0, fmt.Errorf("%s: %q: %w", path, key, err)
+        }
+        if loaded[key] == nil {
+          loaded[key] = make(map[string]string)
+        }
+        loaded[key][lang] = text
+        n++
+      }
+    }
+  }
+  localeMu.Lock()
+  localeOverrides = loaded
+  localeMu.Unlock()
+  return n, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "io"
+  "log/slog"
+  "os"
+  "regexp"
+  "strings"
+  "sync/atomic"
+  "unicode/utf8"
+
+  tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --------- Logging ---------
+
+// LevelTrace is below debug. Only at trace level are user-entered text
+// and raw Bot API traffic written to the log.
+const LevelTrace = slog.Level(-8)
+
+var (
+  logLevel = new(slog.LevelVar)
+  secrets  atomic.Pointer[strings.Replacer] // Replaces known secrets in every log line
+
+  // Only hashes of API tokens and feed secrets are stored, so they are
+  // found by their shape.
+  tokenPattern = regexp.MustCompile(`\brb_[A-Za-z0-9_-]{32}\b`)
+  feedPattern  = regexp.MustCompile(`/ics/[A-Za-z0-9_-]{32}\.ics`)
+)
+
+// setupLogging installs the slog logger described by cfg as the default
+// one, for the standard log package and the Bot API client too.
+func setupLogging(cfg *Config, w io.Writer) {
+  logLevel.Set(parseLevel(cfg.LogLevel))
+  updateSecrets(cfg)
+
+  opts := &slog.HandlerOptions{Level: logLevel, ReplaceAttr: redactAttr}
+  var h slog.Handler
+  if cfg.LogFormat == "json" {
+    h = slog.NewJSONHandler(w, opts)
+  } else {
+    h = slog.NewTextHandler(w, opts)
+  }
+  slog.SetDefault(slog.New(h))
+  tgbotapi.SetLogger(botLogger{})
+}
+
+// updateSecrets rebuilds the secrets replaced in log lines from cfg and
+// the chats' channels. It is called again when either changes.
+func updateSecrets(cfg *Config) {
+  list := []string{cfg.Token, cfg.Webhook.SecretToken, cfg.SMTP.Password}
+  store.mu.Lock()
+  for _, ud := range store.Reminder {
+    for _, c := range ud.Channels {
+      list = append(list, c.Secret)
+    }
+  }
+  store.mu.Unlock()
+  var pairs []string
+  for _, s := range list {
+    if s != "" {
+      pairs = append(pairs, s, "[REDACTED]")
+    }
+  }
+  secrets.Store(strings.NewReplacer(pairs...))
+}
+
+// redact strips secrets and API tokens from s.
+func redact(s string) string {
+  if r := secrets.Load(); r != nil {
+    s = r.Replace(s)
+  }
+  s = tokenPattern.ReplaceAllString(s, "[REDACTED]")
+  return feedPattern.ReplaceAllString(s, "/ics/[REDACTED].ics")
+}
+
+func parseLevel(s string) slog.Level {
+  switch s {
+  case "trace":
+    return LevelTrace
+  case "debug":
+    return slog.LevelDebug
+  case "warn":
+    return slog.LevelWarn
+  case "error":
+    return slog.LevelError
+  default:
+    return slog.LevelInfo
+  }
+}
+
+func traceEnabled() bool {
+  return logLevel.Level() <= LevelTrace
+}
+
+// redactAttr names the trace level and strips secrets from every string
+// and error that is logged, the message included.
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+  if a.Key == slog.LevelKey && len(groups) == 0 {
+    if lvl, ok := a.Value.Any().(slog.Level); ok && lvl <= LevelTrace {
+      a.Value = slog.StringValue("TRACE")
+    }
+    return a
+  }
+  switch a.Value.Kind() {
+  case slog.KindString:
+    a.Value = slog.StringValue(redact(a.Value.String()))
+  case slog.KindAny:
+    if err, ok := a.Value.Any().(error); ok {
+      a.Value = slog.StringValue(redact(err.Error()))
+    }
+  }
+  return a
+}
+
+// userText is text a user typed. Below trace level only its length is
+// logged.
+type userText string
+
+func (t userText) LogValue() slog.Value {
+  if traceEnabled() {
+    return slog.StringValue(string(t))
+  }
+  return slog.StringValue(fmt.Sprintf("[%d chars]", utf8.RuneCountInString(string(t))))
+}
+
+// botLogger routes the Bot API client's logging to slog. It only uses
+// Printf for request and response dumps when bot.Debug is set, and
+// Println for polling errors.
+type botLogger struct{}
+
+func (botLogger) Printf(format string, v ...interface{}) {
+  slog.Log(context.Background(), LevelTrace, strings.TrimSpace(fmt.Sprintf(format, v...)), "component", "botapi")
+}
+
+func (botLogger) Println(v ...interface{}) {
+  slog.Warn(strings.TrimSpace(fmt.Sprintln(v...)), "component", "botapi")
+}
+
+// fatal logs at error level and exits, in place of log.Fatalf.
+func fatal(msg string, args ...interface{}) {
+  slog.Error(msg, args...)
+  os.Exit(1)
+}
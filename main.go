@@ -1,809 +1,1297 @@
-package main
-
-import (
-  "encoding/json"
-  "fmt"
-  "io/ioutil"
-  "log"
-  "os"
-  "strconv"
-  "strings"
-  "sync"
-  "time"
-
-  "github.com/gorhill/cronexpr"
-  tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-)
-
-// --------- Config ---------
-type Config struct {
-  Token string `json:"token"`
-}
-
-func loadConfig(path string) (*Config, error) {
-  bs, err := ioutil.ReadFile(path)
-  if err != nil {
-    return nil, err
-  }
-  var cfg Config
-  if err := json.Unmarshal(bs, &cfg); err != nil {
-    return nil, err
-  }
-  if cfg.Token == "" {
-    return nil, fmt.Errorf("token 为空，请检查 %s", path)
-  }
-  return &cfg, nil
-}
-
-// --------- Storage ---------
-type Reminder struct {
-  Name         string `json:"name"`
-  Date         string `json:"date"`               // Used for one-time reminder
-  Time         string `json:"time"`               // Used for one-time reminder
-  ID           int    `json:"id"`
-  OptInfo      string `json:"opt_inf"`
-  CronOriginal string `json:"cron_original,omitempty"` // Original cron expression from user
-  TZ           string `json:"tz,omitempty"`
-  CronExpr     string `json:"cron_expr,omitempty"`
-}
-
-type UserData struct {
-  UTC       int        `json:"utc"`
-  Reminders []Reminder `json:"reminder"`
-  Lang      string     `json:"lang"`
-}
-
-type Storage struct {
-  Reminder map[string]*UserData `json:"reminder"`
-  mu       sync.Mutex           `json:"-"`
-}
-
-var (
-  store       = Storage{}
-  bot         *tgbotapi.BotAPI
-  sessions    = make(map[int64]*Session)
-  sessMu      sync.Mutex
-  cronQuitMap = make(map[int]chan struct{}) // Used to cancel cronexpr scheduling
-)
-
-// loadStorage reads reminders from the JSON file or initializes storage.
-func loadStorage() error {
-  store.mu.Lock()
-  defer store.mu.Unlock()
-  if _, err := os.Stat("reminder.json"); os.IsNotExist(err) {
-    store.Reminder = make(map[string]*UserData)
-    return saveStorage()
-  }
-  bs, err := ioutil.ReadFile("reminder.json")
-  if err != nil {
-    return err
-  }
-  if err := json.Unmarshal(bs, &store); err != nil {
-    return err
-  }
-  if store.Reminder == nil {
-    store.Reminder = make(map[string]*UserData)
-  }
-  return nil
-}
-
-// saveStorage writes the current reminders to the JSON file.
-func saveStorage() error {
-  store.mu.Lock()
-  defer store.mu.Unlock()
-  bs, err := json.MarshalIndent(store, "", "  ")
-  if err != nil {
-    return err
-  }
-  return ioutil.WriteFile("reminder.json", bs, 0644)
-}
-
-// getUserData retrieves or creates UserData for a chat.
-func getUserData(chatID int64) *UserData {
-  key := strconv.FormatInt(chatID, 10)
-  store.mu.Lock()
-  defer store.mu.Unlock()
-  ud, ok := store.Reminder[key]
-  if !ok {
-    ud = &UserData{UTC: 0, Reminders: []Reminder{}, Lang: "en"}
-    store.Reminder[key] = ud
-  }
-  if ud.Lang != "en" && ud.Lang != "zh" {
-    ud.Lang = "en"
-  }
-  return ud
-}
-
-// --------- Delete Reminder ---------
-func deleteReminder(chatID int64, rid int, head bool) {
-  ud := getUserData(chatID)
-  if head {
-    if len(ud.Reminders) > 0 {
-      r := ud.Reminders[0]
-      if r.CronExpr != "" {
-        if quit, ok := cronQuitMap[r.ID]; ok {
-          close(quit)
-          delete(cronQuitMap, r.ID)
-        }
-      }
-      ud.Reminders = ud.Reminders[1:]
-    }
-  } else {
-    for i, r := range ud.Reminders {
-      if r.ID == rid {
-        if r.CronExpr != "" {
-          if quit, ok := cronQuitMap[r.ID]; ok {
-            close(quit)
-            delete(cronQuitMap, r.ID)
-          }
-        }
-        ud.Reminders = append(ud.Reminders[:i], ud.Reminders[i+1:]...)
-        break
-      }
-    }
-  }
-  saveStorage()
-}
-
-func deleteByIndex(chatID int64, idx int) bool {
-  ud := getUserData(chatID)
-  if idx < 1 || idx > len(ud.Reminders) {
-    return false
-  }
-  r := ud.Reminders[idx-1]
-  if r.CronExpr != "" {
-    if quit, ok := cronQuitMap[r.ID]; ok {
-      close(quit)
-      delete(cronQuitMap, r.ID)
-    }
-  }
-  ud.Reminders = append(ud.Reminders[:idx-1], ud.Reminders[idx:]...)
-  saveStorage()
-  return true
-}
-
-// --------- Multilingual Text ---------
-var messages = map[string]map[string]string{
-  "prompt_name":     {"en": "📍 *Reminder Setup*\n\nWhat is the name of your appointment?", "zh": "📍 *提醒设置*\n\n请输入您的日程名称："},
-  "prompt_date":     {"en": "Select a date:", "zh": "请选择日期："},
-  "prompt_time":     {"en": "You selected %s\n\nChoose time:", "zh": "您选择了 %s\n\n请选择时间："},
-  "ask_extra":       {"en": "You selected %s\nAdd extra information?", "zh": "您选择了 %s\n是否需要添加更多信息？"},
-  "prompt_optinfo":  {"en": "Please send additional information:", "zh": "请输入附加信息："},
-  "no_extra":        {"en": "No extra info. Saving…", "zh": "不添加附加信息，正在保存…"},
-  "saved":           {"en": "📌 *Saved*\n\nAppointment: %s\nDate: %s\nTime: %s", "zh": "📌 *已保存*\n\n日程：%s\n日期：%s\n时间：%s"},
-  "list_empty":      {"en": "📋 You have no reminders.", "zh": "📋 您还没有任何提醒。"},
-  "list_header":     {"en": "📋 *Reminder List*\n", "zh": "📋 *日程列表*\n"},
-  "timezone_prompt": {"en": "Choose your UTC offset:", "zh": "请选择您的 UTC 时区偏移："},
-  "timezone_set":    {"en": "Your UTC offset is now %+d", "zh": "您的 UTC 偏移已设置为 %+d"},
-  "cancelled":       {"en": "🚫 Reminder Setup canceled.", "zh": "🚫 已取消提醒设置。"},
-  "cancelled_index": {"en": "🚫 Cancelled reminder #%d.", "zh": "🚫 已取消第 %d 条提醒。"},
-  "invalid_index":   {"en": "❌ Invalid index", "zh": "❌ 无效的序号"},
-  "notify":          {"en": "💡 *Reminder*\n\nAppointment: %s\nScheduled for %s - %s.\nThe appointment starts in 10 minutes!", "zh": "💡 *提醒*\n\n日程：%s\n安排在 %s - %s。\n距离开始还有 10 分钟！"},
-  "notify_cron":     {"en": "⏰ *Cron Reminder*\n\n%s", "zh": "⏰ *定时提醒*\n\n%s"},
-  "lang_prompt":     {"en": "Please choose language / 请选择语言：", "zh": "请切换语言 / Please choose language："},
-  "lang_set_en":     {"en": "Language set to English.", "zh": "Language set to English."},
-  "lang_set_zh":     {"en": "语言已切换至中文。", "zh": "语言已切换至中文。"},
-  "btn_yes":         {"en": "Yes", "zh": "是"},
-  "btn_no":          {"en": "No", "zh": "否"},
-  "btn_en":          {"en": "English", "zh": "English"},
-  "btn_zh":          {"en": "中文", "zh": "中文"},
-  "cron_usage": {
-    "en": "Usage: /cron <min> <hour> <day> <month> <dow> <TZ> <text>\nExample: `/cron 0 11 18 * * Asia/Shanghai Monthly report`",
-    "zh": "用法: /cron <分> <时> <日> <月> <周> <时区> <内容>\n例如: `/cron 0 11 18 * * Asia/Shanghai 月报提醒`",
-  },
-  "cron_set":    {"en": "✅ Cron reminder set: `%s` ⇒ %s", "zh": "✅ 已设置定时提醒：`%s` ⇒ %s"},
-  "cancel_prompt": {"en": "❓ Select which reminder to cancel:", "zh": "❓ 请选择要取消的提醒："},
-}
-
-func sendText(chatID int64, key string, a ...interface{}) {
-  ud := getUserData(chatID)
-  text := fmt.Sprintf(messages[key][ud.Lang], a...)
-  msg := tgbotapi.NewMessage(chatID, text)
-  msg.ParseMode = "Markdown"
-  bot.Send(msg)
-}
-
-func editText(chatID int64, msgID int, key string, a ...interface{}) tgbotapi.EditMessageTextConfig {
-  ud := getUserData(chatID)
-  text := fmt.Sprintf(messages[key][ud.Lang], a...)
-  edit := tgbotapi.NewEditMessageText(chatID, msgID, text)
-  edit.ParseMode = "Markdown"
-  return edit
-}
-
-// --------- Session ---------
-type Stage int
-
-const (
-  StageIdle Stage = iota
-  StageName
-  StageDate
-  StageTime
-  StageAskInfo
-  StageOptInfo
-  StageUTC
-)
-
-type Session struct {
-  Stage  Stage
-  Temp   Reminder
-  ChatID int64
-}
-
-func getSession(chatID int64) *Session {
-  sessMu.Lock()
-  defer sessMu.Unlock()
-  s, ok := sessions[chatID]
-  if !ok {
-    s = &Session{Stage: StageIdle, ChatID: chatID}
-    sessions[chatID] = s
-  }
-  return s
-}
-
-func finalizeReminder(s *Session) {
-  chatID := s.ChatID
-  ud := getUserData(chatID)
-  s.Temp.ID = int(time.Now().UnixNano() % 1e6)
-  ud.Reminders = append([]Reminder{s.Temp}, ud.Reminders...)
-  saveStorage()
-  scheduleOnce(chatID, s.Temp)
-  sendText(chatID, "saved", s.Temp.Name, s.Temp.Date, s.Temp.Time)
-  s.Stage = StageIdle
-  s.Temp = Reminder{}
-}
-
-// --------- One-time Scheduling ---------
-func scheduleOnce(chatID int64, r Reminder) {
-  ud := getUserData(chatID)
-  dparts := strings.Split(r.Date, "/")
-  day, _ := strconv.Atoi(dparts[0])
-  mon, _ := strconv.Atoi(dparts[1])
-  yr, _ := strconv.Atoi(dparts[2])
-
-  tparts := strings.Split(r.Time, " ")
-  hm := strings.Split(tparts[0], ":")
-  hh, _ := strconv.Atoi(hm[0])
-  mi, _ := strconv.Atoi(hm[1])
-  ap := strings.ToLower(tparts[1])
-
-  if ap == "pm" && hh < 12 {
-    hh += 12
-  }
-  if ap == "am" && hh == 12 {
-    hh = 0
-  }
-
-  // Build event time in UTC and adjust by user's offset
-  evtUTC := time.Date(yr, time.Month(mon), day, hh, mi, 0, 0, time.UTC).
-    Add(-time.Duration(ud.UTC) * time.Hour)
-  // Notify 10 minutes before event
-  notifyUTC := evtUTC.Add(-10 * time.Minute)
-  nowUTC := time.Now().UTC()
-  delay := notifyUTC.Sub(nowUTC)
-  if delay <= 0 {
-    delay = time.Second
-  }
-
-  log.Printf("[Reminder %d] at %v (in %v)\n", r.ID, notifyUTC, delay)
-  time.AfterFunc(delay, func() {
-    sendText(chatID, "notify", r.Name, r.Date, r.Time)
-    deleteReminder(chatID, r.ID, false)
-  })
-}
-
-// --------- Cron Scheduling (cronexpr) ---------
-func runExprJob(chatID int64, r Reminder, expr *cronexpr.Expression, loc *time.Location, quit chan struct{}) {
-  for {
-    now := time.Now().In(loc)
-    next := expr.Next(now)
-    wait := time.Until(next)
-    if wait <= 0 {
-      wait = time.Second
-    }
-    select {
-    case <-time.After(wait):
-      sendText(chatID, "notify_cron", r.Name)
-    case <-quit:
-      return
-    }
-  }
-}
-
-// --------- Message Handling ---------
-func handleMessage(msg *tgbotapi.Message) {
-  chatID := msg.Chat.ID
-  ud := getUserData(chatID)
-  s := getSession(chatID)
-
-  if msg.IsCommand() {
-    switch msg.Command() {
-    case "start":
-      s.Stage = StageName
-      sendText(chatID, "prompt_name")
-      return
-
-    case "cancel":
-      args := msg.CommandArguments()
-      if args != "" {
-        idx, err := strconv.Atoi(args)
-        if err != nil || !deleteByIndex(chatID, idx) {
-          sendText(chatID, "invalid_index")
-        } else {
-          sendText(chatID, "cancelled_index", idx)
-        }
-        s.Stage = StageIdle
-        return
-      }
-      if len(ud.Reminders) == 0 {
-        sendText(chatID, "list_empty")
-        return
-      }
-      var rows [][]tgbotapi.InlineKeyboardButton
-      for i, r := range ud.Reminders {
-        text := fmt.Sprintf("%d) %s", i+1, r.Name)
-        data := fmt.Sprintf("CANCELIDX;%d", i+1)
-        btn := tgbotapi.NewInlineKeyboardButtonData(text, data)
-        rows = append(rows, tgbotapi.NewInlineKeyboardRow(btn))
-      }
-      kb := tgbotapi.InlineKeyboardMarkup{InlineKeyboard: rows}
-      m := tgbotapi.NewMessage(chatID, messages["cancel_prompt"][ud.Lang])
-      m.ReplyMarkup = kb
-      bot.Send(m)
-      return
-
-    case "list":
-      if len(ud.Reminders) == 0 {
-        sendText(chatID, "list_empty")
-        return
-      }
-      text := messages["list_header"][ud.Lang] + "\n"
-      for idx, r := range ud.Reminders {
-        line := fmt.Sprintf("%d) %s", idx+1, r.Name)
-        if r.CronExpr != "" {
-          line += fmt.Sprintf("   (cron: `%s` TZ:%s)", r.CronOriginal, r.TZ)
-        } else {
-          line += fmt.Sprintf("   %s %s", r.Date, r.Time)
-        }
-        if r.OptInfo != "" {
-          line += "\n   Info: " + r.OptInfo
-        }
-        text += "\n" + line
-      }
-      m := tgbotapi.NewMessage(chatID, text)
-      m.ParseMode = "Markdown"
-      bot.Send(m)
-      return
-
-    case "time":
-      s.Stage = StageUTC
-      m := tgbotapi.NewMessage(chatID, messages["timezone_prompt"][ud.Lang])
-      m.ReplyMarkup = CreateTimezone(ud.UTC)
-      bot.Send(m)
-      return
-
-    case "language", "lang":
-      kb := tgbotapi.NewInlineKeyboardMarkup(
-        tgbotapi.NewInlineKeyboardRow(
-          tgbotapi.NewInlineKeyboardButtonData(messages["btn_en"][ud.Lang], "LANG;en"),
-          tgbotapi.NewInlineKeyboardButtonData(messages["btn_zh"][ud.Lang], "LANG;zh"),
-        ),
-      )
-      m := tgbotapi.NewMessage(chatID, messages["lang_prompt"][ud.Lang])
-      m.ReplyMarkup = kb
-      bot.Send(m)
-      return
-
-    case "cron":
-      fields := strings.Fields(msg.CommandArguments())
-      if len(fields) < 7 {
-        sendText(chatID, "cron_usage")
-        return
-      }
-
-      spec := strings.Join(fields[0:5], " ")
-      tzName := fields[5]
-      text := strings.Join(fields[6:], " ")
-
-      // 1) Load the timezone
-      loc, err := time.LoadLocation(tzName)
-      if err != nil {
-        // Option A: Send raw error message
-        msg := tgbotapi.NewMessage(chatID,
-          fmt.Sprintf("❌ 无效时区：%s", tzName))
-        msg.ParseMode = "Markdown"
-        bot.Send(msg)
-        return
-        // Option B: Use sendText, need to add err_invalid_tz key to messages
-        // sendText(chatID, "err_invalid_tz", tzName)
-        // return
-      }
-
-      // 2) Syntax and range validation
-      expr, err := cronexpr.Parse(spec)
-      if err != nil {
-        msg := tgbotapi.NewMessage(chatID,
-          fmt.Sprintf("❌ Cron 表达式解析失败：%s", err.Error()))
-        msg.ParseMode = "Markdown"
-        bot.Send(msg)
-        return
-      }
-      // Store
-      r := Reminder{
-        ID:           int(time.Now().UnixNano() % 1e6),
-        Name:         text,
-        CronOriginal: spec,
-        TZ:           tzName,
-        CronExpr:     spec,
-      }
-      ud.Reminders = append(ud.Reminders, r)
-      saveStorage()
-      // Start cron job
-      quit := make(chan struct{})
-      cronQuitMap[r.ID] = quit
-      go runExprJob(chatID, r, expr, loc, quit)
-      sendText(chatID, "cron_set", spec, text)
-      return
-    }
-  }
-
-  // Session flow: one-time reminder
-  switch s.Stage {
-  case StageName:
-    s.Temp.Name = msg.Text
-    s.Stage = StageDate
-    kb := CreateCalendar(time.Now().Year(), int(time.Now().Month()))
-    m := tgbotapi.NewMessage(chatID, messages["prompt_date"][ud.Lang])
-    m.ReplyMarkup = kb
-    bot.Send(m)
-
-  case StageOptInfo:
-    s.Temp.OptInfo = msg.Text
-    finalizeReminder(s)
-
-  case StageAskInfo:
-    lower := strings.ToLower(msg.Text)
-    yes := messages["btn_yes"][ud.Lang]
-    if lower == strings.ToLower(yes) {
-      s.Stage = StageOptInfo
-      sendText(chatID, "prompt_optinfo")
-    } else {
-      finalizeReminder(s)
-    }
-  }
-}
-
-// --------- Callback Handling ---------
-func handleCallback(q *tgbotapi.CallbackQuery) {
-  chatID := q.Message.Chat.ID
-  ud := getUserData(chatID)
-  s := getSession(chatID)
-  data := q.Data
-
-  if strings.HasPrefix(data, "CANCELIDX;") {
-    parts := strings.Split(data, ";")
-    idx, _ := strconv.Atoi(parts[1])
-    if deleteByIndex(chatID, idx) {
-      bot.Request(tgbotapi.NewEditMessageReplyMarkup(chatID, q.Message.MessageID, tgbotapi.InlineKeyboardMarkup{}))
-      sendText(chatID, "cancelled_index", idx)
-    } else {
-      sendText(chatID, "invalid_index")
-    }
-    bot.Request(tgbotapi.NewCallback(q.ID, ""))
-    return
-  }
-
-  if strings.HasPrefix(data, "LANG;") {
-    parts := strings.Split(data, ";")
-    ud.Lang = parts[1]
-    saveStorage()
-    if ud.Lang == "en" {
-      sendText(chatID, "lang_set_en")
-    } else {
-      sendText(chatID, "lang_set_zh")
-    }
-    bot.Request(tgbotapi.NewEditMessageReplyMarkup(chatID, q.Message.MessageID, tgbotapi.InlineKeyboardMarkup{}))
-    return
-  }
-
-  // Date selection
-  if s.Stage == StageDate {
-    ok, y, m, d := ProcessCalendar(q)
-    if ok {
-      s.Temp.Date = fmt.Sprintf("%02d/%02d/%04d", d, m, y)
-      s.Stage = StageTime
-      kb := CreateClock(12, 0, "am")
-      edit := editText(chatID, q.Message.MessageID, "prompt_time", s.Temp.Date)
-      edit.ReplyMarkup = &kb
-      bot.Send(edit)
-    }
-    return
-  }
-
-  // Time selection
-  if s.Stage == StageTime {
-    ok, h, mi, ap := ProcessClock(q)
-    if ok {
-      s.Temp.Time = fmt.Sprintf("%d:%02d %s", h, mi, ap)
-      s.Stage = StageAskInfo
-      kb := tgbotapi.NewInlineKeyboardMarkup(
-        tgbotapi.NewInlineKeyboardRow(
-          tgbotapi.NewInlineKeyboardButtonData(messages["btn_yes"][ud.Lang], "askinfo_yes"),
-          tgbotapi.NewInlineKeyboardButtonData(messages["btn_no"][ud.Lang], "askinfo_no"),
-        ),
-      )
-      edit := tgbotapi.NewEditMessageText(chatID, q.Message.MessageID,
-        fmt.Sprintf(messages["ask_extra"][ud.Lang], s.Temp.Time))
-      edit.ParseMode = "Markdown"
-      edit.ReplyMarkup = &kb
-      bot.Send(edit)
-    }
-    return
-  }
-
-  // Ask for extra information
-  if s.Stage == StageAskInfo && (data == "askinfo_yes" || data == "askinfo_no") {
-    if data == "askinfo_yes" {
-      s.Stage = StageOptInfo
-      bot.Send(tgbotapi.NewEditMessageText(chatID, q.Message.MessageID, messages["prompt_optinfo"][ud.Lang]))
-    } else {
-      bot.Send(tgbotapi.NewEditMessageText(chatID, q.Message.MessageID, messages["no_extra"][ud.Lang]))
-      finalizeReminder(s)
-    }
-    return
-  }
-
-  // UTC offset
-  if s.Stage == StageUTC {
-    done, off := ProcessUTC(q)
-    if done {
-      ud.UTC = off
-      saveStorage()
-      bot.Send(tgbotapi.NewEditMessageText(chatID, q.Message.MessageID,
-        fmt.Sprintf(messages["timezone_set"][ud.Lang], off)))
-      s.Stage = StageIdle
-    }
-    return
-  }
-
-  bot.Request(tgbotapi.NewCallback(q.ID, ""))
-}
-
-// --------- Calendar ---------
-func CreateCalendar(year, month int) tgbotapi.InlineKeyboardMarkup {
-  var rows [][]tgbotapi.InlineKeyboardButton
-  rows = append(rows, tgbotapi.NewInlineKeyboardRow(
-    tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%s %d", time.Month(month), year), "ignore"),
-  ))
-  weekDays := []string{"Mo", "Tu", "We", "Th", "Fr", "Sa", "Su"}
-  var hdr []tgbotapi.InlineKeyboardButton
-  for _, d := range weekDays {
-    hdr = append(hdr, tgbotapi.NewInlineKeyboardButtonData(d, "ignore"))
-  }
-  rows = append(rows, hdr)
-  weeks := monthCalendar(year, month)
-  for _, wk := range weeks {
-    var row []tgbotapi.InlineKeyboardButton
-    for _, d := range wk {
-      if d == 0 {
-        row = append(row, tgbotapi.NewInlineKeyboardButtonData(" ", "ignore"))
-      } else {
-        data := fmt.Sprintf("DAY;%d;%d;%d", year, month, d)
-        row = append(row, tgbotapi.NewInlineKeyboardButtonData(strconv.Itoa(d), data))
-      }
-    }
-    rows = append(rows, row)
-  }
-  prev := fmt.Sprintf("PREV;%d;%d;0", year, month)
-  next := fmt.Sprintf("NEXT;%d;%d;0", year, month)
-  rows = append(rows, tgbotapi.NewInlineKeyboardRow(
-    tgbotapi.NewInlineKeyboardButtonData("<", prev),
-    tgbotapi.NewInlineKeyboardButtonData(" ", "ignore"),
-    tgbotapi.NewInlineKeyboardButtonData(">", next),
-  ))
-  return tgbotapi.InlineKeyboardMarkup{InlineKeyboard: rows}
-}
-
-func monthCalendar(year, month int) [][]int {
-  first := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local)
-  start := (int(first.Weekday()) + 6) % 7
-  days := time.Date(year, time.Month(month)+1, 0, 0, 0, 0, 0, time.Local).Day()
-  var weeks [][]int
-  week := make([]int, 7)
-  for i := 0; i < start; i++ {
-    week[i] = 0
-  }
-  d := 1
-  for d <= days {
-    idx := (start + d - 1) % 7
-    week[idx] = d
-    if idx == 6 {
-      weeks = append(weeks, week)
-      week = make([]int, 7)
-    }
-    d++
-  }
-  if d > days {
-    weeks = append(weeks, week)
-  }
-  return weeks
-}
-
-func ProcessCalendar(q *tgbotapi.CallbackQuery) (bool, int, int, int) {
-  parts := strings.Split(q.Data, ";")
-  act := parts[0]
-  y, _ := strconv.Atoi(parts[1])
-  m, _ := strconv.Atoi(parts[2])
-  d, _ := strconv.Atoi(parts[3])
-  switch act {
-  case "ignore":
-    bot.Request(tgbotapi.NewCallback(q.ID, ""))
-  case "DAY":
-    bot.Request(tgbotapi.NewCallback(q.ID, ""))
-    return true, y, m, d
-  case "PREV":
-    prev := time.Date(y, time.Month(m), 1, 0, 0, 0, 0, time.Local).AddDate(0, -1, 0)
-    bot.Request(tgbotapi.NewEditMessageReplyMarkup(q.Message.Chat.ID, q.Message.MessageID,
-      CreateCalendar(prev.Year(), int(prev.Month()))))
-  case "NEXT":
-    nxt := time.Date(y, time.Month(m), 1, 0, 0, 0, 0, time.Local).AddDate(0, +1, 0)
-    bot.Request(tgbotapi.NewEditMessageReplyMarkup(q.Message.Chat.ID, q.Message.MessageID,
-      CreateCalendar(nxt.Year(), int(nxt.Month()))))
-  }
-  return false, 0, 0, 0
-}
-
-// --------- Clock ---------
-func CreateClock(hour, minute int, ampm string) tgbotapi.InlineKeyboardMarkup {
-  r1 := tgbotapi.NewInlineKeyboardRow(
-    tgbotapi.NewInlineKeyboardButtonData("↑h", fmt.Sprintf("PLUS-HOUR;%d;%d;%s", hour, minute, ampm)),
-    tgbotapi.NewInlineKeyboardButtonData("↑m", fmt.Sprintf("PLUS-MINUTE;%d;%d;%s", hour, minute, ampm)),
-    tgbotapi.NewInlineKeyboardButtonData("±", fmt.Sprintf("PLUS-AMPM;%d;%d;%s", hour, minute, ampm)),
-  )
-  r2 := tgbotapi.NewInlineKeyboardRow(
-    tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%2d", hour), "ignore"),
-    tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%02d", minute), "ignore"),
-    tgbotapi.NewInlineKeyboardButtonData(ampm, "ignore"),
-  )
-  r3 := tgbotapi.NewInlineKeyboardRow(
-    tgbotapi.NewInlineKeyboardButtonData("↓h", fmt.Sprintf("MINUS-HOUR;%d;%d;%s", hour, minute, ampm)),
-    tgbotapi.NewInlineKeyboardButtonData("↓m", fmt.Sprintf("MINUS-MINUTE;%d;%d;%s", hour, minute, ampm)),
-    tgbotapi.NewInlineKeyboardButtonData("±", fmt.Sprintf("MINUS-AMPM;%d;%d;%s", hour, minute, ampm)),
-  )
-  r4 := tgbotapi.NewInlineKeyboardRow(
-    tgbotapi.NewInlineKeyboardButtonData("OK", fmt.Sprintf("OKAY;%d;%d;%s", hour, minute, ampm)),
-  )
-  return tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{r1, r2, r3, r4}}
-}
-
-func ProcessClock(q *tgbotapi.CallbackQuery) (bool, int, int, string) {
-  parts := strings.Split(q.Data, ";")
-  act := parts[0]
-  if act == "ignore" {
-    bot.Request(tgbotapi.NewCallback(q.ID, ""))
-    return false, 0, 0, ""
-  }
-  h, _ := strconv.Atoi(parts[1])
-  mi, _ := strconv.Atoi(parts[2])
-  ap := parts[3]
-  switch act {
-  case "OKAY":
-    bot.Request(tgbotapi.NewCallback(q.ID, ""))
-    return true, h, mi, ap
-  case "PLUS-HOUR":
-    if h == 12 {
-      h = 1
-    } else {
-      h++
-    }
-  case "MINUS-HOUR":
-    if h <= 1 {
-      h = 12
-    } else {
-      h--
-    }
-  case "PLUS-MINUTE":
-    if mi >= 50 {
-      mi = 0
-    } else {
-      mi += 10
-    }
-  case "MINUS-MINUTE":
-    if mi < 10 {
-      mi = 50
-    } else {
-      mi -= 10
-    }
-  case "PLUS-AMPM", "MINUS-AMPM":
-    if ap == "am" {
-      ap = "pm"
-    } else {
-      ap = "am"
-    }
-  }
-  bot.Request(tgbotapi.NewEditMessageReplyMarkup(q.Message.Chat.ID, q.Message.MessageID, CreateClock(h, mi, ap)))
-  return false, 0, 0, ""
-}
-
-// --------- Timezone ---------
-func CreateTimezone(offset int) tgbotapi.InlineKeyboardMarkup {
-  return tgbotapi.NewInlineKeyboardMarkup(
-    tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("↑", fmt.Sprintf("PLUS;%d", offset))),
-    tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("UTC %+d", offset), "ignore")),
-    tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("↓", fmt.Sprintf("MINUS;%d", offset))),
-    tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("OK", fmt.Sprintf("OKAY;%d", offset))),
-  )
-}
-
-func ProcessUTC(q *tgbotapi.CallbackQuery) (bool, int) {
-  parts := strings.Split(q.Data, ";")
-  act := parts[0]
-  off, _ := strconv.Atoi(parts[1])
-  switch act {
-  case "ignore":
-    bot.Request(tgbotapi.NewCallback(q.ID, ""))
-    return false, off
-  case "PLUS":
-    off++
-  case "MINUS":
-    off--
-  case "OKAY":
-    bot.Request(tgbotapi.NewCallback(q.ID, ""))
-    return true, off
-  }
-  bot.Request(tgbotapi.NewEditMessageReplyMarkup(q.Message.Chat.ID, q.Message.MessageID, CreateTimezone(off)))
-  return false, off
-}
-
-// --------- main ---------
-func main() {
-  cfg, err := loadConfig("config.json")
-  if err != nil {
-    log.Fatalf("load config.json failed: %v", err)
-  }
-  bot, err = tgbotapi.NewBotAPI(cfg.Token)
-  if err != nil {
-    log.Fatalf("new bot failed: %v", err)
-  }
-  bot.Debug = true
-  log.Printf("Authorized on %s", bot.Self.UserName)
-
-  if err := loadStorage(); err != nil {
-    log.Fatalf("load reminder.json failed: %v", err)
-  }
-
-  // Restore all persisted tasks: one-time and cron
-  for k, ud := range store.Reminder {
-    chatID, _ := strconv.ParseInt(k, 10, 64)
-    for _, r := range ud.Reminders {
-      if r.CronExpr != "" {
-        // Reschedule cronexpr tasks
-        loc, err := time.LoadLocation(r.TZ)
-        if err != nil {
-          continue
-        }
-        expr, err := cronexpr.Parse(r.CronOriginal)
-        if err != nil {
-          continue
-        }
-        quit := make(chan struct{})
-        cronQuitMap[r.ID] = quit
-        go runExprJob(chatID, r, expr, loc, quit)
-      } else {
-        scheduleOnce(chatID, r)
-      }
-    }
-  }
-
-  ucfg := tgbotapi.NewUpdate(0)
-  ucfg.Timeout = 60
-  updates := bot.GetUpdatesChan(ucfg)
-  for upd := range updates {
-    if upd.Message != nil {
-      handleMessage(upd.Message)
-    }
-    if upd.CallbackQuery != nil {
-      handleCallback(upd.CallbackQuery)
-    }
-  }
-}
+package main
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "log/slog"
+  "math"
+  "os"
+  "os/signal"
+  "strconv"
+  "strings"
+  "sync"
+  "syscall"
+  "time"
+
+  "github.com/gorhill/cronexpr"
+  tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --------- Storage ---------
+type Reminder struct {
+  Name         string `json:"name"`
+  Date         string `json:"date"`               // Used for one-time reminder
+  Time         string `json:"time"`               // Used for one-time reminder
+  ID           int    `json:"id"`
+  OptInfo      string `json:"opt_inf"`
+  CronOriginal string `json:"cron_original,omitempty"` // Original cron expression from user
+  TZ           string `json:"tz,omitempty"`
+  CronExpr     string `json:"cron_expr,omitempty"`
+  Channels     []string `json:"channels,omitempty"` // Channel names to deliver to; empty means all
+  Failures     []DeliveryFailure `json:"failures,omitempty"` // Recent notifications that were not delivered; replaced, never changed in place
+  Paused       bool     `json:"paused,omitempty"`   // Kept but not scheduled, set through the API
+  History      []FireRecord `json:"history,omitempty"` // Recent notifications, oldest first; replaced like Failures
+  OverrideDND  bool     `json:"override_dnd,omitempty"` // Notifies during quiet hours as usual
+  Priority     string   `json:"priority,omitempty"` // low, high or urgent; empty is normal
+}
+
+// DeliveryFailure records a notification that could not be delivered.
+type DeliveryFailure struct {
+  At      time.Time `json:"at"`
+  Channel string    `json:"channel"` // "chat" or the channel name
+  Error   string    `json:"error"`
+}
+
+// maxFailures is how many delivery failures are kept per reminder.
+const maxFailures = 10
+
+type UserData struct {
+  UTC       int        `json:"utc"`
+  Reminders []Reminder `json:"reminder"`
+  Lang      string     `json:"lang"`
+  Channels  []Channel  `json:"channels,omitempty"`
+  InactiveSince *time.Time `json:"inactive_since,omitempty"` // Set while the bot cannot reach the chat
+  Digest        *DigestSettings `json:"digest,omitempty"`   // Never changed in place, see handleDigestCommand
+  Invited       bool       `json:"invited,omitempty"`        // Redeemed an invite code
+  Archive       []Reminder `json:"archive,omitempty"`        // Delivered one-time reminders, newest first
+  Quiet         *QuietHours `json:"quiet,omitempty"`         // Never changed in place, like Digest
+  Held          []HeldFire `json:"held,omitempty"`           // Notifications held during quiet hours
+}
+
+type Storage struct {
+  Reminder map[string]*UserData `json:"reminder"`
+  Sessions map[string]*Session  `json:"sessions,omitempty"` // In-progress wizard sessions
+  Fires    map[string]int       `json:"fires,omitempty"`    // Notifications fired per UTC day ("2006-01-02"), for /stats
+  Invites  map[string]Invite    `json:"invites,omitempty"`  // Unused invite codes
+  APITokens map[string]APIToken `json:"api_tokens,omitempty"` // By SHA-256 of the token
+  Feeds     map[string]APIToken `json:"feeds,omitempty"`      // iCalendar feeds, by SHA-256 of the URL secret
+  mu       sync.Mutex           `json:"-"`
+}
+
+var (
+  store       = Storage{}
+  bot         *tgbotapi.BotAPI // nil when running on the console front end
+  cronQuitMap = make(map[int]chan struct{}) // Used to cancel cronexpr scheduling, guarded by schedMu
+)
+
+// loadStorage reads reminders from the JSON file or initializes storage.
+func loadStorage() error {
+  if _, err := os.Stat(config().DataPath); config().Storage == "memory" || os.IsNotExist(err) {
+    store.mu.Lock()
+    store.Reminder = make(map[string]*UserData)
+    store.Sessions = make(map[string]*Session)
+    store.Fires = make(map[string]int)
+    store.Invites = make(map[string]Invite)
+    store.APITokens = make(map[string]APIToken)
+    store.Feeds = make(map[string]APIToken)
+    store.mu.Unlock()
+    return saveStorage()
+  }
+  store.mu.Lock()
+  defer store.mu.Unlock()
+  bs, err := ioutil.ReadFile(config().DataPath)
+  if err != nil {
+    return err
+  }
+  if err := json.Unmarshal(bs, &store); err != nil {
+    return err
+  }
+  if store.Reminder == nil {
+    store.Reminder = make(map[string]*UserData)
+  }
+  if store.Sessions == nil {
+    store.Sessions = make(map[string]*Session)
+  }
+  if store.Fires == nil {
+    store.Fires = make(map[string]int)
+  }
+  if store.Invites == nil {
+    store.Invites = make(map[string]Invite)
+  }
+  if store.APITokens == nil {
+    store.APITokens = make(map[string]APIToken)
+  }
+  if store.Feeds == nil {
+    store.Feeds = make(map[string]APIToken)
+  }
+  return nil
+}
+
+// saveStorage writes the current reminders to the JSON file.
+func saveStorage() error {
+  if config().Storage == "memory" {
+    return nil
+  }
+  defer metrics.saveDuration.since(time.Now())
+  store.mu.Lock()
+  defer store.mu.Unlock()
+  bs, err := json.MarshalIndent(&store, "", "  ")
+  if err != nil {
+    return err
+  }
+  return ioutil.WriteFile(config().DataPath, bs, 0644)
+}
+
+// getUserData returns a copy of the chat's UserData, creating it if
+// needed. The copy is safe to read without holding store.mu; use
+// updateUserData to change it.
+func getUserData(chatID int64) UserData {
+  store.mu.Lock()
+  defer store.mu.Unlock()
+  return userDataLocked(chatID).clone()
+}
+
+// clone copies the slices of ud whose elements are changed in place.
+// store.mu must be held.
+func (ud *UserData) clone() UserData {
+  cp := *ud
+  cp.Reminders = append([]Reminder(nil), ud.Reminders...)
+  cp.Channels = append([]Channel(nil), ud.Channels...)
+  cp.Archive = append([]Reminder(nil), ud.Archive...)
+  cp.Held = append([]HeldFire(nil), ud.Held...)
+  return cp
+}
+
+// updateUserData applies fn to the chat's UserData under store.mu and
+// saves the store. fn must not call anything that takes store.mu.
+func updateUserData(chatID int64, fn func(ud *UserData)) {
+  store.mu.Lock()
+  fn(userDataLocked(chatID))
+  store.mu.Unlock()
+  saveStorage()
+}
+
+// userDataLocked retrieves or creates UserData for a chat. store.mu must
+// be held.
+func userDataLocked(chatID int64) *UserData {
+  key := strconv.FormatInt(chatID, 10)
+  ud, ok := store.Reminder[key]
+  if !ok {
+    ud = &UserData{UTC: config().DefaultUTC, Reminders: []Reminder{}, Lang: config().DefaultLang}
+    store.Reminder[key] = ud
+  }
+  if ud.Lang != "en" && ud.Lang != "zh" {
+    ud.Lang = config().DefaultLang
+  }
+  return ud
+}
+
+// --------- Delete Reminder ---------
+func deleteReminder(chatID int64, rid int, head bool) {
+  removed := -1
+  updateUserData(chatID, func(ud *UserData) {
+    for i, r := range ud.Reminders {
+      if head || r.ID == rid {
+        removed = r.ID
+        ud.Reminders = removeReminder(ud.Reminders, i)
+        return
+      }
+    }
+  })
+  if removed >= 0 {
+    cancelJob(removed)
+  }
+}
+
+func deleteByIndex(chatID int64, idx int) bool {
+  removed := -1
+  updateUserData(chatID, func(ud *UserData) {
+    if idx < 1 || idx > len(ud.Reminders) {
+      return
+    }
+    removed = ud.Reminders[idx-1].ID
+    ud.Reminders = removeReminder(ud.Reminders, idx-1)
+  })
+  if removed < 0 {
+    return false
+  }
+  cancelJob(removed)
+  return true
+}
+
+// removeReminder returns list without element i. It never writes into
+// list's backing array, which copies handed out by getUserData may share.
+func removeReminder(list []Reminder, i int) []Reminder {
+  out := make([]Reminder, 0, len(list)-1)
+  out = append(out, list[:i]...)
+  return append(out, list[i+1:]...)
+}
+
+// recordFailure stores a failed delivery on the reminder, if it still exists.
+func recordFailure(chatID int64, rid int, channel string, err error) {
+  slog.Warn("delivery failed", "chat_id", chatID, "reminder_id", rid, "channel", channel, "err", err)
+  updateUserData(chatID, func(ud *UserData) {
+    for i := range ud.Reminders {
+      if ud.Reminders[i].ID == rid {
+        r := &ud.Reminders[i]
+        // Copies from getUserData share the old array.
+        list := append(append([]DeliveryFailure(nil), r.Failures...), DeliveryFailure{At: time.Now().UTC(), Channel: channel, Error: err.Error()})
+        if len(list) > maxFailures {
+          list = list[len(list)-maxFailures:]
+        }
+        r.Failures = list
+        return
+      }
+    }
+  })
+}
+
+// --------- Multilingual Text ---------
+var messages = map[string]map[string]string{
+  "prompt_name":     {"en": "📍 *Reminder Setup*\n\nWhat is the name of your appointment?", "zh": "📍 *提醒设置*\n\n请输入您的日程名称："},
+  "prompt_date":     {"en": "Select a date:", "zh": "请选择日期："},
+  "prompt_time":     {"en": "You selected %s\n\nChoose time:", "zh": "您选择了 %s\n\n请选择时间："},
+  "ask_extra":       {"en": "You selected %s\nAdd extra information?", "zh": "您选择了 %s\n是否需要添加更多信息？"},
+  "prompt_optinfo":  {"en": "Please send additional information:", "zh": "请输入附加信息："},
+  "no_extra":        {"en": "No extra info.", "zh": "不添加附加信息。"},
+  "prompt_priority": {"en": "How important is it?", "zh": "请选择优先级："},
+  "priority_picked": {"en": "Priority: %s. Saving…", "zh": "优先级：%s，正在保存…"},
+  "saved":           {"en": "📌 *Saved*\n\nAppointment: %s\nDate: %s\nTime: %s\nReminder: %s before", "zh": "📌 *已保存*\n\n日程：%s\n日期：%s\n时间：%s\n提醒：提前 %s"},
+  "list_empty":      {"en": "📋 You have no reminders.", "zh": "📋 您还没有任何提醒。"},
+  "list_header":     {"en": "📋 *Reminder List* (%s)\n", "zh": "📋 *日程列表*（%s）\n"},
+  "timezone_prompt": {"en": "Choose your UTC offset:", "zh": "请选择您的 UTC 时区偏移："},
+  "timezone_set":    {"en": "Your UTC offset is now %+d", "zh": "您的 UTC 偏移已设置为 %+d"},
+  "cancelled":       {"en": "🚫 Reminder Setup canceled.", "zh": "🚫 已取消提醒设置。"},
+  "cancelled_index": {"en": "🚫 Cancelled reminder #%d.", "zh": "🚫 已取消第 %d 条提醒。"},
+  "invalid_index":   {"en": "❌ Invalid index", "zh": "❌ 无效的序号"},
+  "notify":          {"en": "💡 *Reminder*\n\nAppointment: %s\nScheduled for %s - %s.\nThe appointment starts %s!", "zh": "💡 *提醒*\n\n日程：%s\n安排在 %s - %s。\n将于%s开始！"},
+  "notify_cron":     {"en": "⏰ *Cron Reminder*\n\n%s", "zh": "⏰ *定时提醒*\n\n%s"},
+  "lang_prompt":     {"en": "Please choose language / 请选择语言：", "zh": "请切换语言 / Please choose language："},
+  "lang_set_en":     {"en": "Language set to English.", "zh": "Language set to English."},
+  "lang_set_zh":     {"en": "语言已切换至中文。", "zh": "语言已切换至中文。"},
+  "btn_yes":         {"en": "Yes", "zh": "是"},
+  "btn_no":          {"en": "No", "zh": "否"},
+  "btn_en":          {"en": "English", "zh": "English"},
+  "btn_zh":          {"en": "中文", "zh": "中文"},
+  "cron_usage": {
+    "en": "Usage: /cron <min> <hour> <day> <month> <dow> <TZ> <text>\nExample: `/cron 0 11 18 * * Asia/Shanghai Monthly report`",
+    "zh": "用法: /cron <分> <时> <日> <月> <周> <时区> <内容>\n例如: `/cron 0 11 18 * * Asia/Shanghai 月报提醒`",
+  },
+  "cron_set":    {"en": "✅ Cron reminder set: `%s` ⇒ %s", "zh": "✅ 已设置定时提醒：`%s` ⇒ %s"},
+  "cancel_prompt": {"en": "❓ Select which reminder to cancel:", "zh": "❓ 请选择要取消的提醒："},
+  "duration_now":  {"en": "now", "zh": "现在"},
+  "setup_expired": {"en": "⌛ Reminder setup expired after inactivity. Send /start to begin again.", "zh": "⌛ 提醒设置因长时间未操作已过期，请发送 /start 重新开始。"},
+  "back_nothing":  {"en": "Nothing to undo.", "zh": "没有可撤销的步骤。"},
+  "list_undelivered": {"en": "⚠️ %s, last on %s %s via %s: %s", "zh": "⚠️ %s，最近一次 %s %s 通过 %s：%s"},
+  "channels_usage": {
+    "en": "Usage:\n`/channels` list your channels\n`/channels add email <name> <address>`\n`/channels add webhook <name> <url> [secret]`\n`/channels add ntfy <name> <topic-url> [token]`\n`/channels add gotify <name> <server-url> <token>`\n`/channels remove <name>`\n`/channels test <name>`\n`/channels use <index> <name,...|all>`",
+    "zh": "用法：\n`/channels` 查看通知渠道\n`/channels add email <名称> <邮箱>`\n`/channels add webhook <名称> <URL> [密钥]`\n`/channels add ntfy <名称> <主题URL> [令牌]`\n`/channels add gotify <名称> <服务器URL> <令牌>`\n`/channels remove <名称>`\n`/channels test <名称>`\n`/channels use <序号> <名称,...|all>`",
+  },
+  "channels_empty":      {"en": "📭 No extra channels. Reminders are only sent here.\nSee `/channels help`.", "zh": "📭 没有额外的通知渠道，提醒只会发送到这里。\n查看 `/channels help`。"},
+  "channels_header":     {"en": "📡 *Notification Channels*\n", "zh": "📡 *通知渠道*\n"},
+  "channel_added":       {"en": "✅ Channel `%s` added.", "zh": "✅ 已添加渠道 `%s`。"},
+  "channel_in_use":      {"en": "⚠️ Channel `%s` is the only channel of reminder %s; without it the reminder would go to all channels. Give it other channels with `/channels use` first, or `all`.", "zh": "⚠️ 渠道 `%s` 是提醒 %s 唯一的渠道，删除后该提醒将发送到所有渠道。请先用 `/channels use` 为其指定其他渠道，或设为 `all`。"},
+  "channel_removed":     {"en": "🗑 Channel `%s` removed.", "zh": "🗑 已删除渠道 `%s`。"},
+  "channel_exists":      {"en": "❌ A channel named `%s` already exists.", "zh": "❌ 已存在名为 `%s` 的渠道。"},
+  "channel_not_found":   {"en": "❌ No channel named `%s`.", "zh": "❌ 没有名为 `%s` 的渠道。"},
+  "channel_invalid":     {"en": "❌ Invalid channel: %s", "zh": "❌ 渠道无效：%s"},
+  "smtp_not_configured": {"en": "❌ Email is not available on this bot.", "zh": "❌ 此机器人未配置邮件发送。"},
+  "channel_test_body":   {"en": "This is a test notification from your reminder bot.", "zh": "这是来自提醒机器人的测试通知。"},
+  "channel_test_ok":     {"en": "✅ Test sent to `%s`.", "zh": "✅ 已向 `%s` 发送测试通知。"},
+  "channel_test_failed": {"en": "❌ Test to `%s` failed: %s", "zh": "❌ 向 `%s` 发送测试失败：%s"},
+  "channel_use_set":     {"en": "✅ Reminder #%d now delivers to: %s", "zh": "✅ 第 %d 条提醒将发送到：%s"},
+  "admin_stats": {
+    "en": "📊 *Stats*\n\nChats: %d (%d active, %d inactive)\nReminders: %d (%d one-time, %d cron)\nSetups in progress: %d\n\nFires per day (UTC):",
+    "zh": "📊 *统计*\n\n会话：%d（活跃 %d，停用 %d）\n提醒：%d（一次性 %d，定时 %d）\n正在设置：%d\n\n每日提醒次数（UTC）：",
+  },
+  "admin_broadcast_usage":   {"en": "Usage: /broadcast <text>", "zh": "用法：/broadcast <内容>"},
+  "admin_broadcast_started": {"en": "📣 Broadcasting to %s…", "zh": "📣 正在向 %s广播…"},
+  "admin_broadcast_done":    {"en": "📣 Broadcast done: %d sent, %d failed.", "zh": "📣 广播完成：成功 %d，失败 %d。"},
+  "admin_user_usage":        {"en": "Usage: /user <chat id>", "zh": "用法：/user <会话 ID>"},
+  "admin_purge_usage":       {"en": "Usage: /purge <chat id>", "zh": "用法：/purge <会话 ID>"},
+  "admin_user_dump":         {"en": "🔎 Stored data of chat %d", "zh": "🔎 会话 %d 的存储数据"},
+  "admin_chat_not_found":    {"en": "❌ No data for chat %d.", "zh": "❌ 没有会话 %d 的数据。"},
+  "admin_purged":            {"en": "🗑 Chat %d purged.", "zh": "🗑 已清除会话 %d。"},
+  "admin_reloaded":          {"en": "✅ Configuration and locales reloaded.", "zh": "✅ 已重新加载配置和语言文件。"},
+  "admin_reload_failed":     {"en": "❌ Reload failed: %s", "zh": "❌ 重新加载失败：%s"},
+  "admin_reload_restart":    {"en": "⚠️ These changes need a restart: %s", "zh": "⚠️ 以下修改需要重启才能生效：%s"},
+  "access_denied":      {"en": "🔒 Sorry, this bot is private and you don't have access to it.", "zh": "🔒 抱歉，这是一个私人机器人，您没有使用权限。"},
+  "access_invite_only": {"en": "🔒 Sorry, this bot is invite-only. If you have an invite code, send `/start <code>`.", "zh": "🔒 抱歉，此机器人仅限受邀使用。如果您有邀请码，请发送 `/start <邀请码>`。"},
+  "invite_invalid":     {"en": "❌ Sorry, this invite code is invalid or has already been used.", "zh": "❌ 抱歉，此邀请码无效或已被使用。"},
+  "invite_accepted":    {"en": "🎉 Invite accepted, welcome!", "zh": "🎉 邀请码有效，欢迎使用！"},
+  "admin_invite_usage": {"en": "Usage: /invite [count]", "zh": "用法：/invite [数量]"},
+  "admin_invites":      {"en": "🎟 New single-use invite codes:\n%s", "zh": "🎟 新的一次性邀请码：\n%s"},
+  "welcome_back":        {"en": "👋 Welcome back! %s resumed.", "zh": "👋 欢迎回来！已恢复 %s。"},
+  "limit_reminders":     {"en": "⚠️ You have reached the limit of %s. Remove one with /cancel first.", "zh": "⚠️ 您的提醒已达上限（%s），请先用 /cancel 删除一些。"},
+  "limit_text_length":   {"en": "⚠️ That text is %d characters long, the limit is %d. Please send a shorter one.", "zh": "⚠️ 该文本长 %d 个字符，上限为 %d，请发送更短的文本。"},
+  "limit_cron_interval": {"en": "⚠️ Cron reminders may fire at most once every %s.", "zh": "⚠️ Cron 提醒的触发间隔不能短于 %s。"},
+  "limit_fires":         {"en": "⚠️ This chat has reached the limit of %d notifications per hour. Further reminders are skipped for now and shown in /list.", "zh": "⚠️ 本聊天已达到每小时 %d 条通知的上限，之后的提醒将暂时跳过，可在 /list 中查看。"},
+  "limit_commands":      {"en": "⚠️ Too many commands, please slow down and try again in a minute.", "zh": "⚠️ 操作过于频繁，请稍候一分钟再试。"},
+  "callback_expired":    {"en": "This button has expired, please start over.", "zh": "该按钮已失效，请重新开始。"},
+  "callback_failed":     {"en": "Something went wrong, please try again.", "zh": "出错了，请重试。"},
+  "list_paused":         {"en": "⏸ paused", "zh": "⏸ 已暂停"},
+  "token_issued": {
+    "en": "🔑 API token for this chat:\n`%s`\n\nKeep it secret, it lets anyone manage this chat's reminders. Sending /token again replaces it, `/token revoke` disables it.",
+    "zh": "🔑 本聊天的 API 令牌：\n`%s`\n\n请妥善保管，持有者可以管理本聊天的所有提醒。再次发送 /token 会替换它，`/token revoke` 可将其停用。",
+  },
+  "token_revoked":  {"en": "🔑 API token revoked.", "zh": "🔑 API 令牌已停用。"},
+  "token_none":     {"en": "This chat has no API token.", "zh": "本聊天没有 API 令牌。"},
+  "token_usage":    {"en": "Usage: /token or `/token revoke`", "zh": "用法：/token 或 `/token revoke`"},
+  "token_disabled": {"en": "❌ The API is not enabled on this bot.", "zh": "❌ 此机器人未启用 API。"},
+  "export_usage": {
+    "en": "Usage:\n`/export` download all your data, for `/import`\n`/export ics` download your reminders as a calendar file\n`/export feed` get a calendar subscription URL\n`/export feed revoke` disable it",
+    "zh": "用法：\n`/export` 下载您的全部数据，可用于 `/import`\n`/export ics` 下载日历文件\n`/export feed` 获取日历订阅链接\n`/export feed revoke` 停用订阅链接",
+  },
+  "ics_calendar_name":  {"en": "Reminders", "zh": "提醒"},
+  "export_ics":         {"en": "📅 Your reminders, ready to import into Google Calendar, Outlook or Apple Calendar.", "zh": "📅 您的提醒，可导入 Google 日历、Outlook 或 Apple 日历。"},
+  "export_ics_skipped": {"en": "⚠️ Left out %s whose schedule cannot be written as a calendar rule.", "zh": "⚠️ %s无法转换为日历规则，未包含在内。"},
+  "feed_created": {
+    "en": "📅 Subscribe to this URL in your calendar app to keep it in sync with your reminders:\n`%s`\n\nAnyone with the link can see your reminders. Sending `/export feed` again replaces it, `/export feed revoke` disables it.",
+    "zh": "📅 在日历应用中订阅此链接，即可与提醒保持同步：\n`%s`\n\n任何拥有此链接的人都能看到您的提醒。再次发送 `/export feed` 会替换它，`/export feed revoke` 可将其停用。",
+  },
+  "feed_revoked": {"en": "📅 Calendar feed disabled.", "zh": "📅 日历订阅已停用。"},
+  "feed_none":    {"en": "This chat has no calendar feed.", "zh": "本聊天没有日历订阅。"},
+  "feed_no_public_url": {"en": "❌ Calendar feeds are not available on this bot.", "zh": "❌ 此机器人未启用日历订阅。"},
+  "import_unsupported": {"en": "📥 Send a `.ics` calendar file or a `.csv` file to import reminders.", "zh": "📥 发送 `.ics` 日历文件或 `.csv` 文件即可导入提醒。"},
+  "import_too_large":   {"en": "❌ Files to import are limited to %d KB.", "zh": "❌ 导入文件不能超过 %d KB。"},
+  "import_failed":      {"en": "❌ Could not download the file, please try again.", "zh": "❌ 无法下载文件，请重试。"},
+  "import_invalid":     {"en": "❌ Could not read *%s*: %s", "zh": "❌ 无法读取 *%s*：%s"},
+  "import_preview":     {"en": "📥 *%s*: %s to import", "zh": "📥 *%s*：将导入%s"},
+  "import_preview_more": {"en": "… and %s more", "zh": "…… 另有%s"},
+  "import_duplicates":  {"en": "Duplicates left out: %s.", "zh": "跳过重复项：%s。"},
+  "import_over_limit":  {"en": "⚠️ %s left out, this chat is limited to %s.", "zh": "⚠️ 跳过%s，本聊天最多只能有%s。"},
+  "import_skipped":     {"en": "%s cannot be imported:", "zh": "%s无法导入："},
+  "import_reason_past": {"en": "in the past", "zh": "已过期"},
+  "import_reason_rule": {"en": "repeats in a way cron cannot express", "zh": "重复规则无法用 cron 表达"},
+  "import_reason_zone": {"en": "unknown time zone %s", "zh": "未知时区 %s"},
+  "btn_import":         {"en": "✅ Import", "zh": "✅ 导入"},
+  "btn_import_cancel":  {"en": "✖️ Cancel", "zh": "✖️ 取消"},
+  "import_cancelled":   {"en": "Import cancelled.", "zh": "已取消导入。"},
+  "import_done":        {"en": "✅ Imported %s.", "zh": "✅ 已导入%s。"},
+  "import_channel_rejected": {"en": "⚠️ Channel `%s` was not restored: %s", "zh": "⚠️ 渠道 `%s` 未恢复：%s"},
+  "import_usage": {
+    "en": "📥 Send me a file to import it:\n• the `.json` file from `/export`, to restore your reminders, settings and channels\n• a `.ics` calendar file\n• a `.csv` file with the columns name, date, time, cron, tz and info\n\nYou will see a preview before anything is saved.",
+    "zh": "📥 发送文件即可导入：\n• `/export` 导出的 `.json` 文件，用于恢复提醒、设置和渠道\n• `.ics` 日历文件\n• 包含 name、date、time、cron、tz、info 列的 `.csv` 文件\n\n保存之前会先显示预览。",
+  },
+  "import_restore": {"en": "Your language and time zone settings will be restored, and %s added.", "zh": "将恢复语言和时区设置，并添加%s。"},
+  "export_json":    {"en": "🗂 Everything this bot stores about this chat. Send the file back with `/import` to restore it.", "zh": "🗂 本机器人为此聊天保存的全部数据。发送此文件即可通过 `/import` 恢复。"},
+  "forget_prompt": {
+    "en": "⚠️ Delete everything this bot stores about this chat? All reminders stop, and your settings, channels, API tokens and calendar feeds are removed. This cannot be undone; `/export` first if you want a copy.",
+    "zh": "⚠️ 删除本机器人为此聊天保存的全部数据？所有提醒将停止，设置、渠道、API 令牌和日历订阅都会被删除。此操作无法撤销；如需备份，请先使用 `/export`。",
+  },
+  "btn_forget":      {"en": "🗑 Delete everything", "zh": "🗑 全部删除"},
+  "btn_forget_keep": {"en": "Keep my data", "zh": "保留数据"},
+  "forget_kept":     {"en": "Nothing was deleted.", "zh": "未删除任何数据。"},
+  "forget_done":     {"en": "🗑 All data of this chat has been deleted.", "zh": "🗑 此聊天的全部数据已删除。"},
+  "btn_ack":         {"en": "✅ Done", "zh": "✅ 完成"},
+  "btn_snooze":      {"en": "💤 Snooze 10 min", "zh": "💤 10 分钟后提醒"},
+  "snoozed":         {"en": "💤 I'll remind you of *%s* again in %s.", "zh": "💤 将在%[2]s后再次提醒 *%[1]s*。"},
+  "history_usage":   {"en": "Usage: `/history [n]`, the last n notifications, up to %d.", "zh": "用法：`/history [n]`，显示最近 n 条通知，最多 %d 条。"},
+  "history_empty":   {"en": "🕘 No notifications have been sent yet.", "zh": "🕘 还没有发送过通知。"},
+  "history_header":  {"en": "🕘 *History*, the last %s", "zh": "🕘 *历史记录*，最近%s"},
+  "history_line":    {"en": "• *%s*\n   due %s %s, sent %s · %s", "zh": "• *%s*\n   预定 %s %s，发送于 %s · %s"},
+  "history_delivered":    {"en": "✅ delivered", "zh": "✅ 已送达"},
+  "history_failed":       {"en": "❌ failed: %s", "zh": "❌ 失败：%s"},
+  "history_rate_limited": {"en": "⏳ skipped, over the hourly limit", "zh": "⏳ 超出每小时上限，已跳过"},
+  "history_ack":     {"en": "👍 done at %s", "zh": "👍 %s 已完成"},
+  "history_snooze":  {"en": "💤 snoozed at %s", "zh": "💤 %s 已稍后提醒"},
+  "archive_empty":   {"en": "🗄 The archive is empty. Delivered one-time reminders are kept here.", "zh": "🗄 归档为空。已送达的一次性提醒会保存在这里。"},
+  "archive_header":  {"en": "🗄 *Archive* (%s)\n", "zh": "🗄 *归档*（%s）\n"},
+  "archive_hint":    {"en": "Press ♻️ to set a reminder again, at the same time of day.", "zh": "按 ♻️ 可在同一时刻重新设置提醒。"},
+  "restored":        {"en": "♻️ *%s* is back, for %s %s.", "zh": "♻️ 已恢复 *%s*，时间为 %s %s。"},
+  "agenda_today":    {"en": "📅 *Next 24 hours*", "zh": "📅 *未来 24 小时*"},
+  "agenda_week":     {"en": "🗓 *Next 7 days*", "zh": "🗓 *未来 7 天*"},
+  "digest_daily":    {"en": "☀️ *Your day ahead*, the next 24 hours", "zh": "☀️ *今日安排*，未来 24 小时"},
+  "digest_weekly":   {"en": "🗓 *Your week ahead*", "zh": "🗓 *下周安排*"},
+  "agenda_empty":    {"en": "Nothing due. 🎉", "zh": "没有待办事项。🎉"},
+  "agenda_more":     {"en": "… and %s more", "zh": "…… 另有%s"},
+  "btn_postpone":    {"en": "⏩ +1 h", "zh": "⏩ 推迟 1 小时"},
+  "postponed":       {"en": "⏩ *%s* moved to %s %s.", "zh": "⏩ *%s* 已推迟到 %s %s。"},
+  "edit_prompt_date": {"en": "✏️ New date for *%s*:", "zh": "✏️ 请选择 *%s* 的新日期："},
+  "edit_still_paused": {"en": "⏸ The reminder stays paused until it is resumed.", "zh": "⏸ 提醒仍处于暂停状态，恢复后才会发送。"},
+  "digest_usage": {
+    "en": "Usage:\n`/digest 08:00` a daily digest at this time\n`/digest off` stop it\n`/digest weekly on` an overview of the week on Sunday evenings\n`/digest weekly off` stop it",
+    "zh": "用法：\n`/digest 08:00` 每天在此时间发送日程摘要\n`/digest off` 停止\n`/digest weekly on` 每周日晚上发送一周概览\n`/digest weekly off` 停止",
+  },
+  "digest_status": {
+    "en": "☀️ Daily digest: %s\n🗓 Weekly overview: %s\n\nTimes are in your UTC offset (/time). See `/digest help`.",
+    "zh": "☀️ 每日摘要：%s\n🗓 每周概览：%s\n\n时间以您的 UTC 偏移为准（/time）。详见 `/digest help`。",
+  },
+  "digest_off":      {"en": "off", "zh": "关闭"},
+  "digest_at":       {"en": "every day at %s", "zh": "每天 %s"},
+  "digest_sunday":   {"en": "Sundays at %s", "zh": "每周日 %s"},
+  "quiet_usage": {
+    "en": "Usage:\n`/quiet 22:00-07:00` set quiet hours\n`/quiet off` turn them off\n`/quiet hold` hold notifications until they end\n`/quiet silent` send notifications without a sound\n`/quiet override 2` let reminder 2 of /list notify anyway, again to undo",
+    "zh": "用法：\n`/quiet 22:00-07:00` 设置免打扰时段\n`/quiet off` 关闭\n`/quiet hold` 暂存通知，时段结束后发送\n`/quiet silent` 静音发送通知\n`/quiet override 2` 让 /list 中第 2 个提醒照常通知，再次发送可撤销",
+  },
+  "quiet_status": {
+    "en": "🌙 Quiet hours: %s\nNotifications during them are %s.\n\nReminders marked 🔔 in /list notify anyway. Times are in your UTC offset (/time). See `/quiet help`.",
+    "zh": "🌙 免打扰时段：%s\n期间的通知将%s。\n\n/list 中标有 🔔 的提醒照常通知。时间以您的 UTC 偏移为准（/time）。详见 `/quiet help`。",
+  },
+  "quiet_off":         {"en": "off", "zh": "关闭"},
+  "quiet_window":      {"en": "%s – %s", "zh": "%s – %s"},
+  "quiet_mode_hold":   {"en": "held and delivered when they end", "zh": "暂存，并在时段结束后一并发送"},
+  "quiet_mode_silent": {"en": "sent without a sound", "zh": "静音发送"},
+  "quiet_override_on":  {"en": "🔔 *%s* will notify during quiet hours too.", "zh": "🔔 *%s* 在免打扰时段也会照常通知。"},
+  "quiet_override_off": {"en": "🔕 *%s* follows quiet hours again.", "zh": "🔕 *%s* 将重新遵循免打扰时段。"},
+  "quiet_over":        {"en": "🌅 *Quiet hours are over.* Held back:", "zh": "🌅 *免打扰时段已结束。* 期间暂存的通知："},
+  "quiet_held_times":  {"en": "(due %d times)", "zh": "（共 %d 次）"},
+  "history_silent":    {"en": "🔕 silent", "zh": "🔕 静音"},
+  "priority_low":      {"en": "🔹 Low", "zh": "🔹 低"},
+  "priority_normal":   {"en": "Normal", "zh": "普通"},
+  "priority_high":     {"en": "❗ High", "zh": "❗ 高"},
+  "priority_urgent":   {"en": "🚨 Urgent", "zh": "🚨 紧急"},
+  "notify_low":        {"en": "🔹 *Low priority*", "zh": "🔹 *低优先级*"},
+  "notify_high":       {"en": "❗ *Important*", "zh": "❗ *重要*"},
+  "notify_urgent":     {"en": "🚨 *URGENT*, repeated until answered", "zh": "🚨 *紧急*，回复前将重复提醒"},
+  "priority_usage": {
+    "en": "Usage: `/priority 2 high` sets the priority of reminder 2 of /list to `low`, `normal`, `high` or `urgent`.\n\nLow ones notify without a sound, high ones are pinned, urgent ones are pinned and repeated every 5 minutes, up to 3 times, until ✅ Done or 💤 Snooze is pressed. `/list high` lists only one priority.",
+    "zh": "用法：`/priority 2 high` 将 /list 中第 2 个提醒的优先级设为 `low`、`normal`、`high` 或 `urgent`。\n\n低优先级静音通知，高优先级置顶，紧急提醒置顶并每 5 分钟重复一次（最多 3 次），直到按下 ✅ 或 💤。`/list high` 只列出该优先级的提醒。",
+  },
+  "priority_set":      {"en": "*%s* now has priority %s.", "zh": "*%s* 的优先级已设为 %s。"},
+  "list_no_priority":  {"en": "📋 You have no reminders with priority %s.", "zh": "📋 没有优先级为 %s 的提醒。"},
+}
+
+func sendText(chatID int64, key string, a ...interface{}) error {
+  ud := getUserData(chatID)
+  _, err := messenger.SendText(chatID, tr(ud.Lang, key, a...))
+  return err
+}
+
+func sendKeyboard(chatID int64, kb Keyboard, key string, a ...interface{}) {
+  ud := getUserData(chatID)
+  messenger.SendKeyboard(chatID, tr(ud.Lang, key, a...), kb)
+}
+
+func editText(chatID int64, msgID int, kb Keyboard, key string, a ...interface{}) {
+  ud := getUserData(chatID)
+  messenger.EditMessage(chatID, msgID, tr(ud.Lang, key, a...), kb)
+}
+
+func finalizeReminder(s *Session) {
+  chatID := s.ChatID
+  // The chat may have reached the limit since the wizard started. An edit
+  // replaces its reminder in place and keeps its ID.
+  if s.EditID == 0 && !checkReminderQuota(chatID) {
+    resetSession(s)
+    return
+  }
+  s.Temp.ID = int(time.Now().UnixNano() % 1e6)
+  if s.EditID != 0 {
+    s.Temp.ID = s.EditID
+    cancelJob(s.EditID)
+  }
+  updateUserData(chatID, func(ud *UserData) {
+    for i, r := range ud.Reminders {
+      if r.ID == s.EditID {
+        ud.Reminders = removeReminder(ud.Reminders, i)
+        break
+      }
+    }
+    ud.Reminders = append([]Reminder{s.Temp}, ud.Reminders...)
+  })
+  // A paused reminder edited from the agenda stays paused.
+  if !s.Temp.Paused {
+    scheduleOnce(chatID, s.Temp)
+  }
+  if at, err := reminderWallClock(s.Temp); err == nil {
+    sendText(chatID, "saved", s.Temp.Name, LocalDate(at), LocalTime(at), LocalSpan(config().notifyLead()))
+  } else {
+    sendText(chatID, "saved", s.Temp.Name, s.Temp.Date, s.Temp.Time, LocalSpan(config().notifyLead()))
+  }
+  if s.Temp.Paused {
+    sendText(chatID, "edit_still_paused")
+  }
+  resetSession(s)
+}
+
+// --------- One-time Scheduling ---------
+
+// reminderWallClock parses a one-time reminder's Date ("dd/mm/yyyy") and
+// Time ("h:mm am") into a wall-clock time. The result carries the UTC
+// location but represents the user's local time.
+func reminderWallClock(r Reminder) (time.Time, error) {
+  return time.Parse("02/01/2006 3:04 pm", r.Date+" "+strings.ToLower(r.Time))
+}
+
+// scheduleText describes when r fires, as /list shows it.
+func scheduleText(lang string, r Reminder) string {
+  if r.CronExpr != "" {
+    return render("(cron: `%s` TZ:%s)", r.CronOriginal, r.TZ)
+  }
+  if at, err := reminderWallClock(r); err == nil {
+    return render("%s %s", LocalDate(at).Localize(lang), LocalTime(at).Localize(lang))
+  }
+  return render("%s %s", r.Date, r.Time)
+}
+
+func scheduleOnce(chatID int64, r Reminder) {
+  ud := getUserData(chatID)
+  at, err := reminderWallClock(r)
+  if err != nil {
+    slog.Error("invalid reminder date/time", "chat_id", chatID, "reminder_id", r.ID, "date", r.Date, "time", r.Time, "err", err)
+    return
+  }
+
+  // Build event time in UTC and adjust by user's offset
+  evtUTC := at.Add(-time.Duration(ud.UTC) * time.Hour)
+  // Notify ahead of the event
+  notifyUTC := evtUTC.Add(-config().notifyLead())
+  nowUTC := time.Now().UTC()
+  delay := notifyUTC.Sub(nowUTC)
+  if delay <= 0 {
+    delay = time.Second
+  }
+
+  slog.Debug("reminder scheduled", "chat_id", chatID, "reminder_id", r.ID, "at", notifyUTC, "in", delay)
+  // Register under schedMu so a short timer cannot fire before it is
+  // tracked. Scheduling again replaces the pending timer.
+  schedMu.Lock()
+  defer schedMu.Unlock()
+  stopJobLocked(r.ID)
+  var t *time.Timer
+  t = time.AfterFunc(delay, func() {
+    if !beginFire() {
+      return
+    }
+    defer endFire()
+    schedMu.Lock()
+    // Replaced or cancelled after it had already started.
+    if onceTimers[r.ID] != t {
+      schedMu.Unlock()
+      return
+    }
+    delete(onceTimers, r.ID)
+    schedMu.Unlock()
+    if holdFire(chatID, r, notifyUTC) {
+      return
+    }
+    if !allowFire(chatID, r.ID) {
+      recordFire(chatID, r.ID, FireRecord{Scheduled: notifyUTC, Sent: time.Now().UTC(), Status: fireLimited})
+      return
+    }
+    metrics.fires.inc("once")
+    countFire()
+    slog.Info("reminder fired", "chat_id", chatID, "reminder_id", r.ID)
+    rec := fireReminder(chatID, r, notifyUTC)
+    if rec.Status != fireDelivered {
+      // Keep the reminder so /list shows it was not delivered.
+      recordFire(chatID, r.ID, rec)
+      return
+    }
+    archiveReminder(chatID, r.ID, rec)
+  })
+  onceTimers[r.ID] = t
+}
+
+// notificationText renders the notification sent when r fires.
+func notificationText(chatID int64, r Reminder) string {
+  lang := getUserData(chatID).Lang
+  header := ""
+  if r.Priority != "" {
+    header = tr(lang, "notify_"+r.Priority) + "\n"
+  }
+  if r.CronExpr != "" {
+    return header + tr(lang, "notify_cron", r.Name)
+  }
+  at, _ := reminderWallClock(r)
+  return header + tr(lang, "notify", r.Name, LocalDate(at), LocalTime(at), LocalDuration(config().notifyLead()))
+}
+
+// --------- Cron Scheduling (cronexpr) ---------
+func runExprJob(chatID int64, r Reminder, expr *cronexpr.Expression, loc *time.Location, quit chan struct{}) {
+  for {
+    now := time.Now().In(loc)
+    next := expr.Next(now)
+    wait := time.Until(next)
+    if wait <= 0 {
+      wait = time.Second
+    }
+    select {
+    case <-time.After(wait):
+      if !beginFire() {
+        return
+      }
+      if holdFire(chatID, r, next) {
+        endFire()
+        continue
+      }
+      if !allowFire(chatID, r.ID) {
+        recordFire(chatID, r.ID, FireRecord{Scheduled: next.UTC(), Sent: time.Now().UTC(), Status: fireLimited})
+        endFire()
+        continue
+      }
+      metrics.fires.inc("cron")
+      countFire()
+      slog.Info("cron reminder fired", "chat_id", chatID, "reminder_id", r.ID)
+      recordFire(chatID, r.ID, fireReminder(chatID, r, next))
+      endFire()
+    case <-quit:
+      return
+    }
+  }
+}
+
+// --------- Message Handling ---------
+func handleMessage(msg *InMessage) {
+  chatID := msg.ChatID
+  if !admitMessage(msg) || !allowCommand(chatID, msg.UserID) {
+    return
+  }
+  ud := getUserData(chatID)
+  // An abandoned wizard must not swallow an unrelated message.
+  expireSession(chatID)
+  s := getSession(chatID)
+
+  if msg.Document != nil {
+    handleImportDocument(msg)
+    return
+  }
+
+  if msg.IsCommand() {
+    if config().isAdmin(msg.UserID) && handleAdminCommand(msg) {
+      return
+    }
+    switch msg.Command() {
+    case "start":
+      if reactivateChat(chatID) {
+        sendText(chatID, "welcome_back", Count{len(ud.Reminders), "reminders"})
+      }
+      resetSession(s)
+      if !checkReminderQuota(chatID) {
+        return
+      }
+      advanceSession(s, StageName)
+      sendText(chatID, "prompt_name")
+      return
+
+    case "back":
+      backSession(s)
+      return
+
+    case "cancel":
+      args := msg.CommandArguments()
+      if args != "" {
+        idx, err := strconv.Atoi(args)
+        if err != nil || !deleteByIndex(chatID, idx) {
+          sendText(chatID, "invalid_index")
+        } else {
+          sendText(chatID, "cancelled_index", idx)
+        }
+        resetSession(s)
+        return
+      }
+      if len(ud.Reminders) == 0 {
+        sendText(chatID, "list_empty")
+        return
+      }
+      var kb Keyboard
+      for i, r := range ud.Reminders {
+        text := fmt.Sprintf("%d) %s", i+1, r.Name)
+        kb = append(kb, newRow(callbackButton(chatID, text, cbCancel, i+1)))
+      }
+      sendKeyboard(chatID, kb, "cancel_prompt")
+      return
+
+    case "list":
+      if len(ud.Reminders) == 0 {
+        sendText(chatID, "list_empty")
+        return
+      }
+      // /list <priority> shows one priority, numbered as in the full list.
+      filter := strings.ToLower(msg.CommandArguments())
+      if filter != "" && indexOf(priorities, filter) < 0 {
+        sendText(chatID, "priority_usage")
+        return
+      }
+      n := 0
+      for _, r := range ud.Reminders {
+        if filter == "" || r.Priority == storedPriority(filter) {
+          n++
+        }
+      }
+      if n == 0 {
+        sendText(chatID, "list_no_priority", HTML(tr(ud.Lang, "priority_"+filter)))
+        return
+      }
+      text := tr(ud.Lang, "list_header", Count{n, "reminders"}) + "\n"
+      for idx, r := range ud.Reminders {
+        if filter != "" && r.Priority != storedPriority(filter) {
+          continue
+        }
+        line := render("%d) %s   %s", idx+1, r.Name, HTML(scheduleText(ud.Lang, r)))
+        if r.Paused {
+          line += "   " + tr(ud.Lang, "list_paused")
+        }
+        if icon, ok := priorityIcons[r.Priority]; ok {
+          line += "   " + icon
+        }
+        if r.OverrideDND {
+          line += "   🔔"
+        }
+        if r.OptInfo != "" {
+          line += render("\n   Info: %s", r.OptInfo)
+        }
+        if n := len(r.Failures); n > 0 {
+          f := r.Failures[n-1]
+          local := f.At.Add(time.Duration(ud.UTC) * time.Hour)
+          line += "\n   " + tr(ud.Lang, "list_undelivered", Count{n, "failures"}, LocalDate(local), LocalTime(local), f.Channel, f.Error)
+        }
+        text += "\n" + line
+      }
+      messenger.SendText(chatID, text)
+      return
+
+    case "time":
+      advanceSession(s, StageUTC)
+      sendKeyboard(chatID, CreateTimezone(chatID, ud.UTC), "timezone_prompt")
+      return
+
+    case "language", "lang":
+      kb := newKeyboard(
+        newRow(
+          callbackButton(chatID, plainText(ud.Lang, "btn_en"), cbLang, "en"),
+          callbackButton(chatID, plainText(ud.Lang, "btn_zh"), cbLang, "zh"),
+        ),
+      )
+      sendKeyboard(chatID, kb, "lang_prompt")
+      return
+
+    case "channels":
+      handleChannelsCommand(chatID, msg.CommandArguments())
+      return
+
+    case "token":
+      handleTokenCommand(chatID, msg.UserID, msg.CommandArguments())
+      return
+
+    case "export":
+      args := strings.Fields(msg.CommandArguments())
+      switch {
+      case len(args) == 0 || len(args) == 1 && args[0] == "json":
+        sendExport(chatID)
+      case len(args) == 1 && args[0] == "ics":
+        sendICS(chatID)
+      case len(args) >= 1 && args[0] == "feed":
+        handleFeedCommand(chatID, msg.UserID, strings.Join(args[1:], " "))
+      default:
+        sendText(chatID, "export_usage")
+      }
+      return
+
+    case "import":
+      sendText(chatID, "import_usage")
+      return
+
+    case "forgetme":
+      handleForgetCommand(chatID)
+      return
+
+    case "history":
+      handleHistoryCommand(chatID, msg.CommandArguments())
+      return
+
+    case "archive":
+      handleArchiveCommand(chatID)
+      return
+
+    case "today":
+      sendAgenda(chatID, "agenda_today", 24*time.Hour)
+      return
+
+    case "week":
+      sendAgenda(chatID, "agenda_week", 7*24*time.Hour)
+      return
+
+    case "priority":
+      handlePriorityCommand(chatID, msg.CommandArguments())
+      return
+
+    case "quiet":
+      handleQuietCommand(chatID, msg.CommandArguments())
+      return
+
+    case "digest":
+      handleDigestCommand(chatID, msg.CommandArguments())
+      return
+
+    case "cron":
+      fields := strings.Fields(msg.CommandArguments())
+      if len(fields) < 7 {
+        sendText(chatID, "cron_usage")
+        return
+      }
+
+      spec := strings.Join(fields[0:5], " ")
+      tzName := fields[5]
+      text := strings.Join(fields[6:], " ")
+      if !checkReminderQuota(chatID) || !checkTextLength(chatID, text) {
+        return
+      }
+
+      // 1) Load the timezone
+      loc, err := time.LoadLocation(tzName)
+      if err != nil {
+        // Option A: Send raw error message
+        messenger.SendText(chatID, render("❌ 无效时区：%s", tzName))
+        return
+        // Option B: Use sendText, need to add err_invalid_tz key to messages
+        // sendText(chatID, "err_invalid_tz", tzName)
+        // return
+      }
+
+      // 2) Syntax and range validation
+      expr, err := cronexpr.Parse(spec)
+      if err != nil {
+        messenger.SendText(chatID, render("❌ Cron 表达式解析失败：%s", err))
+        return
+      }
+      if !checkCronInterval(chatID, expr, loc) {
+        return
+      }
+      // Store
+      r := Reminder{
+        ID:           int(time.Now().UnixNano() % 1e6),
+        Name:         text,
+        CronOriginal: spec,
+        TZ:           tzName,
+        CronExpr:     spec,
+      }
+      updateUserData(chatID, func(ud *UserData) {
+        ud.Reminders = append(ud.Reminders, r)
+      })
+      // Start cron job
+      startCronJob(chatID, r, expr, loc)
+      sendText(chatID, "cron_set", spec, text)
+      return
+    }
+  }
+
+  // Session flow: one-time reminder
+  switch s.Stage {
+  case StageName:
+    if !checkTextLength(chatID, msg.Text) {
+      return
+    }
+    s.Temp.Name = msg.Text
+    advanceSession(s, StageDate)
+    kb := CreateCalendar(chatID, time.Now().Year(), int(time.Now().Month()))
+    sendKeyboard(chatID, kb, "prompt_date")
+
+  case StageOptInfo:
+    if !checkTextLength(chatID, msg.Text) {
+      return
+    }
+    s.Temp.OptInfo = msg.Text
+    askPriority(s)
+
+  case StageAskInfo:
+    lower := strings.ToLower(msg.Text)
+    yes := plainText(ud.Lang, "btn_yes")
+    if lower == strings.ToLower(yes) {
+      advanceSession(s, StageOptInfo)
+      sendText(chatID, "prompt_optinfo")
+    } else {
+      askPriority(s)
+    }
+
+  case StagePriority:
+    sendKeyboard(chatID, priorityKeyboard(chatID, ud.Lang), "prompt_priority")
+  }
+}
+
+// --------- Callback Handling ---------
+func handleCallback(q *InCallback) {
+  chatID := q.ChatID
+  if !admitCallback(q) {
+    return
+  }
+  if !allowCommand(chatID, q.UserID) {
+    messenger.AnswerCallback(q.ID, "")
+    return
+  }
+  expireSession(chatID)
+  cb, err := decodeCallback(chatID, q.Data)
+  if err == nil {
+    if h, ok := callbackHandlers[cb.Prefix]; ok {
+      err = runCallback(h, q, cb)
+    } else {
+      err = errCallbackMalformed
+    }
+  }
+  answerCallback(q, err)
+}
+
+// callbackHandlers routes button presses by prefix.
+var callbackHandlers = map[string]callbackHandler{
+  cbNoop:     func(*InCallback, callback) error { return nil },
+  cbCancel:   handleCancelCallback,
+  cbLang:     handleLangCallback,
+  cbCalendar: ProcessCalendar,
+  cbClock:    ProcessClock,
+  cbAskInfo:  handleAskInfoCallback,
+  cbPriority: handlePriorityCallback,
+  cbUTC:      ProcessUTC,
+  cbImport:   handleImportCallback,
+  cbForget:   handleForgetCallback,
+  cbFire:     handleFireCallback,
+  cbRestore:  handleRestoreCallback,
+  cbAgenda:   handleAgendaCallback,
+}
+
+func handleCancelCallback(q *InCallback, cb callback) error {
+  a := cb.args()
+  idx := a.int(1, math.MaxInt32)
+  if err := a.end(); err != nil {
+    return err
+  }
+  if deleteByIndex(q.ChatID, idx) {
+    messenger.EditKeyboard(q.ChatID, q.MessageID, nil)
+    sendText(q.ChatID, "cancelled_index", idx)
+  } else {
+    sendText(q.ChatID, "invalid_index")
+  }
+  return nil
+}
+
+func handleLangCallback(q *InCallback, cb callback) error {
+  a := cb.args()
+  lang := a.word("en", "zh")
+  if err := a.end(); err != nil {
+    return err
+  }
+  updateUserData(q.ChatID, func(ud *UserData) {
+    ud.Lang = lang
+  })
+  if lang == "en" {
+    sendText(q.ChatID, "lang_set_en")
+  } else {
+    sendText(q.ChatID, "lang_set_zh")
+  }
+  messenger.EditKeyboard(q.ChatID, q.MessageID, nil)
+  return nil
+}
+
+// Ask for extra information
+func handleAskInfoCallback(q *InCallback, cb callback) error {
+  a := cb.args()
+  answer := a.word("yes", "no")
+  if err := a.end(); err != nil {
+    return err
+  }
+  s := getSession(q.ChatID)
+  if s.Stage != StageAskInfo {
+    return errCallbackStale
+  }
+  if answer == "yes" {
+    advanceSession(s, StageOptInfo)
+    editText(q.ChatID, q.MessageID, nil, "prompt_optinfo")
+  } else {
+    editText(q.ChatID, q.MessageID, nil, "no_extra")
+    askPriority(s)
+  }
+  return nil
+}
+
+// --------- Calendar ---------
+func CreateCalendar(chatID int64, year, month int) Keyboard {
+  noop := func(text string) Button { return callbackButton(chatID, text, cbNoop) }
+  var rows Keyboard
+  rows = append(rows, newRow(
+    noop(fmt.Sprintf("%s %d", time.Month(month), year)),
+  ))
+  weekDays := []string{"Mo", "Tu", "We", "Th", "Fr", "Sa", "Su"}
+  var hdr []Button
+  for _, d := range weekDays {
+    hdr = append(hdr, noop(d))
+  }
+  rows = append(rows, hdr)
+  weeks := monthCalendar(year, month)
+  for _, wk := range weeks {
+    var row []Button
+    for _, d := range wk {
+      if d == 0 {
+        row = append(row, noop(" "))
+      } else {
+        row = append(row, callbackButton(chatID, strconv.Itoa(d), cbCalendar, "DAY", year, month, d))
+      }
+    }
+    rows = append(rows, row)
+  }
+  rows = append(rows, newRow(
+    callbackButton(chatID, "<", cbCalendar, "PREV", year, month),
+    noop(" "),
+    callbackButton(chatID, ">", cbCalendar, "NEXT", year, month),
+  ))
+  return rows
+}
+
+func monthCalendar(year, month int) [][]int {
+  first := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local)
+  start := (int(first.Weekday()) + 6) % 7
+  days := time.Date(year, time.Month(month)+1, 0, 0, 0, 0, 0, time.Local).Day()
+  var weeks [][]int
+  week := make([]int, 7)
+  for i := 0; i < start; i++ {
+    week[i] = 0
+  }
+  d := 1
+  for d <= days {
+    idx := (start + d - 1) % 7
+    week[idx] = d
+    if idx == 6 {
+      weeks = append(weeks, week)
+      week = make([]int, 7)
+    }
+    d++
+  }
+  if d > days {
+    weeks = append(weeks, week)
+  }
+  return weeks
+}
+
+// ProcessCalendar pages the calendar, or takes the picked day and moves
+// the wizard on to the clock.
+func ProcessCalendar(q *InCallback, cb callback) error {
+  a := cb.args()
+  act := a.word("DAY", "PREV", "NEXT")
+  y := a.int(1, 9999)
+  m := a.int(1, 12)
+  d := 0
+  if act == "DAY" {
+    d = a.int(1, 31)
+  }
+  if err := a.end(); err != nil {
+    return err
+  }
+  s := getSession(q.ChatID)
+  if s.Stage != StageDate {
+    return errCallbackStale
+  }
+  switch act {
+  case "DAY":
+    day := time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC)
+    if day.Day() != d {
+      return errCallbackMalformed
+    }
+    s.Temp.Date = fmt.Sprintf("%02d/%02d/%04d", d, m, y)
+    advanceSession(s, StageTime)
+    editText(q.ChatID, q.MessageID, CreateClock(q.ChatID, 12, 0, "am"), "prompt_time", LocalDate(day))
+  case "PREV":
+    prev := time.Date(y, time.Month(m), 1, 0, 0, 0, 0, time.Local).AddDate(0, -1, 0)
+    messenger.EditKeyboard(q.ChatID, q.MessageID, CreateCalendar(q.ChatID, prev.Year(), int(prev.Month())))
+  case "NEXT":
+    nxt := time.Date(y, time.Month(m), 1, 0, 0, 0, 0, time.Local).AddDate(0, +1, 0)
+    messenger.EditKeyboard(q.ChatID, q.MessageID, CreateCalendar(q.ChatID, nxt.Year(), int(nxt.Month())))
+  }
+  return nil
+}
+
+// --------- Clock ---------
+func CreateClock(chatID int64, hour, minute int, ampm string) Keyboard {
+  btn := func(text, act string) Button {
+    return callbackButton(chatID, text, cbClock, act, hour, minute, ampm)
+  }
+  r1 := newRow(
+    btn("↑h", "PLUS-HOUR"),
+    btn("↑m", "PLUS-MINUTE"),
+    btn("±", "PLUS-AMPM"),
+  )
+  r2 := newRow(
+    callbackButton(chatID, fmt.Sprintf("%2d", hour), cbNoop),
+    callbackButton(chatID, fmt.Sprintf("%02d", minute), cbNoop),
+    callbackButton(chatID, ampm, cbNoop),
+  )
+  r3 := newRow(
+    btn("↓h", "MINUS-HOUR"),
+    btn("↓m", "MINUS-MINUTE"),
+    btn("±", "MINUS-AMPM"),
+  )
+  r4 := newRow(
+    btn("OK", "OKAY"),
+  )
+  return newKeyboard(r1, r2, r3, r4)
+}
+
+// ProcessClock turns the clock, or takes the picked time and moves the
+// wizard on.
+func ProcessClock(q *InCallback, cb callback) error {
+  a := cb.args()
+  act := a.word("OKAY", "PLUS-HOUR", "MINUS-HOUR", "PLUS-MINUTE", "MINUS-MINUTE", "PLUS-AMPM", "MINUS-AMPM")
+  h := a.int(1, 12)
+  mi := a.int(0, 59)
+  ap := a.word("am", "pm")
+  if err := a.end(); err != nil {
+    return err
+  }
+  s := getSession(q.ChatID)
+  if s.Stage != StageTime {
+    return errCallbackStale
+  }
+  switch act {
+  case "OKAY":
+    s.Temp.Time = fmt.Sprintf("%d:%02d %s", h, mi, ap)
+    advanceSession(s, StageAskInfo)
+    at, _ := time.Parse("3:04 pm", s.Temp.Time)
+    editText(q.ChatID, q.MessageID, askExtraKeyboard(q.ChatID, getUserData(q.ChatID).Lang), "ask_extra", LocalTime(at))
+    return nil
+  case "PLUS-HOUR":
+    if h == 12 {
+      h = 1
+    } else {
+      h++
+    }
+  case "MINUS-HOUR":
+    if h <= 1 {
+      h = 12
+    } else {
+      h--
+    }
+  case "PLUS-MINUTE":
+    if mi >= 50 {
+      mi = 0
+    } else {
+      mi += 10
+    }
+  case "MINUS-MINUTE":
+    if mi < 10 {
+      mi = 50
+    } else {
+      mi -= 10
+    }
+  case "PLUS-AMPM", "MINUS-AMPM":
+    if ap == "am" {
+      ap = "pm"
+    } else {
+      ap = "am"
+    }
+  }
+  messenger.EditKeyboard(q.ChatID, q.MessageID, CreateClock(q.ChatID, h, mi, ap))
+  return nil
+}
+
+// --------- Timezone ---------
+func CreateTimezone(chatID int64, offset int) Keyboard {
+  return newKeyboard(
+    newRow(callbackButton(chatID, "↑", cbUTC, "PLUS", offset)),
+    newRow(callbackButton(chatID, fmt.Sprintf("UTC %+d", offset), cbNoop)),
+    newRow(callbackButton(chatID, "↓", cbUTC, "MINUS", offset)),
+    newRow(callbackButton(chatID, "OK", cbUTC, "OKAY", offset)),
+  )
+}
+
+// ProcessUTC steps the offset, or stores it and ends the /time wizard.
+func ProcessUTC(q *InCallback, cb callback) error {
+  a := cb.args()
+  act := a.word("PLUS", "MINUS", "OKAY")
+  off := a.int(-12, 14)
+  if err := a.end(); err != nil {
+    return err
+  }
+  s := getSession(q.ChatID)
+  if s.Stage != StageUTC {
+    return errCallbackStale
+  }
+  switch act {
+  case "PLUS":
+    if off < 14 {
+      off++
+    }
+  case "MINUS":
+    if off > -12 {
+      off--
+    }
+  case "OKAY":
+    updateUserData(q.ChatID, func(ud *UserData) {
+      ud.UTC = off
+    })
+    editText(q.ChatID, q.MessageID, nil, "timezone_set", off)
+    resetSession(s)
+    return nil
+  }
+  messenger.EditKeyboard(q.ChatID, q.MessageID, CreateTimezone(q.ChatID, off))
+  return nil
+}
+
+// --------- main ---------
+func main() {
+  cfg, err := loadConfig(os.Args[1:])
+  if errors.Is(err, flag.ErrHelp) {
+    return
+  }
+  if err != nil {
+    fatal("load config failed", "err", err)
+  }
+  setConfig(cfg)
+  setupLogging(cfg, os.Stderr)
+  if _, err := loadLocales(cfg.LocalesDir); err != nil {
+    fatal("load locales failed", "dir", cfg.LocalesDir, "err", err)
+  }
+  var console *consoleMessenger
+  if cfg.Mode == "console" {
+    console = newConsoleMessenger(os.Stdout)
+    messenger = console
+  } else {
+    bot, err = tgbotapi.NewBotAPI(cfg.Token)
+    if err != nil {
+      fatal("new bot failed", "err", err)
+    }
+    bot.Debug = cfg.Debug
+    slog.Info("authorized", "bot", bot.Self.UserName)
+    messenger = newTelegramMessenger(bot)
+  }
+
+  if err := loadStorage(); err != nil {
+    fatal("load storage failed", "path", cfg.DataPath, "err", err)
+  }
+  updateSecrets(cfg)
+
+  // Restore all persisted tasks: one-time and cron
+  for k, ud := range store.Reminder {
+    if ud.InactiveSince != nil {
+      // Suspended until the user comes back with /start.
+      continue
+    }
+    chatID, _ := strconv.ParseInt(k, 10, 64)
+    for _, r := range ud.Reminders {
+      scheduleReminder(chatID, r)
+    }
+  }
+
+  ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+  defer stop()
+  go sweepSessions(ctx)
+  go purgeInactiveChats(ctx)
+  go runDigests(ctx)
+  go runQuietHours(ctx)
+  if cfg.MetricsListen != "" {
+    startHealthServer(cfg.MetricsListen)
+  }
+  if cfg.APIListen != "" {
+    startAPIServer(cfg.APIListen)
+  }
+
+  if console != nil {
+    slog.Info("console mode, type :<n> to press a button", "chat_id", consoleChatID)
+    ready.Store(true)
+    runConsole(ctx, console, os.Stdin)
+    shutdown(config().shutdownTimeout(), nil, nil, nil)
+    return
+  }
+
+  var updates tgbotapi.UpdatesChannel
+  var stopUpdates func(ctx context.Context)
+  if cfg.Mode == "webhook" {
+    ws, err := startWebhook(cfg.Webhook)
+    if err != nil {
+      fatal("start webhook failed", "err", err)
+    }
+    updates = ws.Updates
+    stopUpdates = ws.Stop
+  } else {
+    ucfg := tgbotapi.NewUpdate(0)
+    ucfg.Timeout = cfg.pollTimeout()
+    updates = bot.GetUpdatesChan(ucfg)
+    stopUpdates = func(context.Context) { bot.StopReceivingUpdates() }
+  }
+
+  pool := newUpdatePool(cfg.Workers, dispatchUpdate)
+  ready.Store(true)
+  runUpdates(ctx, updates, pool)
+  shutdown(config().shutdownTimeout(), stopUpdates, updates, pool)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+  "fmt"
+  "io"
+  "log/slog"
+  "os"
+  "strings"
+  "sync"
+  "testing"
+  "time"
+)
+
+func TestMain(m *testing.M) {
+  slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+  os.Exit(m.Run())
+}
+
+// setupTest gives a test empty in-memory storage and a fakeMessenger.
+func setupTest(t *testing.T) *fakeMessenger {
+  t.Helper()
+  cfg := defaultConfig()
+  cfg.Storage = "memory"
+  cfg.Token = "123:test"
+  setConfig(cfg)
+  if err := loadStorage(); err != nil {
+    t.Fatal(err)
+  }
+  fm := newFakeMessenger()
+  messenger = fm
+  return fm
+}
+
+func TestGetUserDataReturnsCopy(t *testing.T) {
+  setupTest(t)
+  const chatID = 42
+  updateUserData(chatID, func(ud *UserData) {
+    ud.Reminders = append(ud.Reminders, Reminder{ID: 1, Name: "a"})
+    ud.Channels = append(ud.Channels, Channel{Name: "c"})
+  })
+
+  ud := getUserData(chatID)
+  ud.UTC = 5
+  ud.Reminders[0].Name = "changed"
+  ud.Reminders = append(ud.Reminders, Reminder{ID: 2})
+  ud.Channels[0].Name = "changed"
+
+  got := getUserData(chatID)
+  if got.UTC != 0 || len(got.Reminders) != 1 || got.Reminders[0].Name != "a" || got.Channels[0].Name != "c" {
+    t.Fatalf("changing a copy changed the store: %+v", got)
+  }
+}
+
+func TestUpdateUserDataConcurrent(t *testing.T) {
+  setupTest(t)
+  const chatID, n = 42, 50
+  var wg sync.WaitGroup
+  for i := 1; i <= n; i++ {
+    wg.Add(2)
+    go func(id int) {
+      defer wg.Done()
+      updateUserData(chatID, func(ud *UserData) {
+        ud.Reminders = append(ud.Reminders, Reminder{ID: id})
+      })
+    }(i)
+    go func() {
+      defer wg.Done()
+      for _, r := range getUserData(chatID).Reminders {
+        _ = r.ID
+      }
+    }()
+  }
+  wg.Wait()
+
+  seen := make(map[int]bool)
+  for _, r := range getUserData(chatID).Reminders {
+    seen[r.ID] = true
+  }
+  if len(seen) != n {
+    t.Fatalf("got %d distinct reminders, want %d", len(seen), n)
+  }
+}
+
+// send hands text from chatID to the message handler.
+func send(chatID int64, text string) {
+  handleMessage(&InMessage{ChatID: chatID, UserID: chatID, Text: text})
+}
+
+func TestStartWizard(t *testing.T) {
+  fm := setupTest(t)
+  const chatID = 21
+  lang := "en"
+
+  send(chatID, "/start")
+  if m := fm.last(t, chatID); m.Text != tr(lang, "prompt_name") {
+    t.Fatalf("sent %q, want the name prompt", m.Text)
+  }
+  send(chatID, "Dentist")
+
+  // Next month, so the reminder is in the future.
+  cal := fm.last(t, chatID)
+  handleCallback(cal.press(t, ">"))
+  next := time.Now().AddDate(0, 0, 1-time.Now().Day()).AddDate(0, 1, 0)
+  handleCallback(fm.last(t, chatID).press(t, "28"))
+
+  clock := fm.last(t, chatID)
+  if clock.ID != cal.ID {
+    t.Fatalf("the clock replaced message #%d, want #%d", clock.ID, cal.ID)
+  }
+  handleCallback(clock.press(t, "↑h"))
+  handleCallback(fm.last(t, chatID).press(t, "OK"))
+  handleCallback(fm.last(t, chatID).press(t, plainText(lang, "btn_no")))
+  handleCallback(fm.last(t, chatID).press(t, plainText(lang, "priority_high")))
+
+  ud := getUserData(chatID)
+  if len(ud.Reminders) != 1 {
+    t.Fatalf("%d reminders saved, want 1", len(ud.Reminders))
+  }
+  r := ud.Reminders[0]
+  defer cancelJob(r.ID)
+  date := fmt.Sprintf("28/%02d/%d", next.Month(), next.Year())
+  if r.Name != "Dentist" || r.Date != date || r.Time != "1:00 am" || r.Priority != priorityHigh {
+    t.Fatalf("saved %+v, want Dentist on %s at 1:00 am, high", r, date)
+  }
+  if s := getSession(chatID); s.Stage != StageIdle {
+    t.Fatalf("session left at stage %v", s.Stage)
+  }
+  for _, a := range fm.answers {
+    if a != "" {
+      t.Fatalf("a button was answered with %q", a)
+    }
+  }
+}
+
+func TestList(t *testing.T) {
+  fm := setupTest(t)
+  const chatID = 22
+
+  send(chatID, "/list")
+  if m := fm.last(t, chatID); m.Text != tr("en", "list_empty") {
+    t.Fatalf("sent %q for no reminders", m.Text)
+  }
+
+  updateUserData(chatID, func(ud *UserData) {
+    ud.Reminders = []Reminder{
+      {ID: 1, Name: "Standup", CronExpr: "0 9 * * 1-5", CronOriginal: "0 9 * * 1-5", TZ: "UTC"},
+      {ID: 2, Name: "Dentist", Date: "28/02/2030", Time: "9:00 am", Priority: priorityUrgent},
+    }
+  })
+  send(chatID, "/list")
+  text := fm.last(t, chatID).Text
+  for _, s := range []string{"1) Standup", "2) Dentist", "0 9 * * 1-5", priorityIcons[priorityUrgent]} {
+    if !strings.Contains(text, s) {
+      t.Fatalf("/list is missing %q:\n%s", s, text)
+    }
+  }
+
+  // A filtered list keeps the numbers of the full one.
+  send(chatID, "/list urgent")
+  text = fm.last(t, chatID).Text
+  if !strings.Contains(text, "2) Dentist") || strings.Contains(text, "Standup") {
+    t.Fatalf("/list urgent:\n%s", text)
+  }
+}
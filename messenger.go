@@ -0,0 +1,129 @@
+package main
+
+import (
+  "log/slog"
+  "strings"
+)
+
+// --------- Messenger ---------
+
+// Messenger is the outgoing side of a chat platform. Handlers only talk to
+// the platform through it, so the same flows run on Telegram, the console
+// front end or anything else that implements it. Message text is Telegram
+// HTML as produced by render; adapters that cannot show it use stripHTML.
+type Messenger interface {
+  // SendText sends a plain message and returns its message ID.
+  SendText(chatID int64, text string) (int, error)
+  // SendKeyboard sends a message with an inline keyboard attached.
+  SendKeyboard(chatID int64, text string, kb Keyboard) (int, error)
+  // SendSilent is SendKeyboard without a notification sound.
+  SendSilent(chatID int64, text string, kb Keyboard) (int, error)
+  // EditMessage replaces the text of a message; a nil kb removes its keyboard.
+  EditMessage(chatID int64, msgID int, text string, kb Keyboard) error
+  // EditKeyboard replaces only the keyboard; a nil kb removes it.
+  EditKeyboard(chatID int64, msgID int, kb Keyboard) error
+  // PinMessage pins a message in the chat without notifying its members.
+  PinMessage(chatID int64, msgID int) error
+  // UnpinMessage unpins a message.
+  UnpinMessage(chatID int64, msgID int) error
+  // AnswerCallback acknowledges a button press, optionally with a toast.
+  AnswerCallback(callbackID, text string) error
+  // SendDocument sends data as a file named name, with an optional caption.
+  SendDocument(chatID int64, name string, data []byte, caption string) (int, error)
+}
+
+// messenger is the active platform adapter.
+var messenger Messenger
+
+// Button is one inline keyboard button. Data is handed back verbatim in
+// the InCallback produced when the button is pressed.
+type Button struct {
+  Text string
+  Data string
+}
+
+// Keyboard is a platform-neutral inline keyboard, one slice per row.
+type Keyboard [][]Button
+
+func newButton(text, data string) Button {
+  return Button{Text: text, Data: data}
+}
+
+func newRow(btns ...Button) []Button {
+  return btns
+}
+
+func newKeyboard(rows ...[]Button) Keyboard {
+  return Keyboard(rows)
+}
+
+// InMessage is an incoming chat message.
+type InMessage struct {
+  ChatID    int64
+  UserID    int64
+  MessageID int
+  Text      string
+  Document  *InDocument  // An attached file, if any
+  Log       *slog.Logger // Carries the update's IDs; see log
+}
+
+// InDocument is a file sent to the bot. Fetch downloads its content.
+type InDocument struct {
+  Name  string
+  Size  int
+  Fetch func() ([]byte, error)
+}
+
+// log returns the logger for handling m.
+func (m *InMessage) log() *slog.Logger {
+  if m.Log == nil {
+    return slog.With("chat_id", m.ChatID, "user_id", m.UserID)
+  }
+  return m.Log
+}
+
+// IsCommand reports whether the message starts with a /command.
+func (m *InMessage) IsCommand() bool {
+  return len(m.Text) > 1 && m.Text[0] == '/'
+}
+
+// Command returns the command name without the slash or an @botname suffix.
+func (m *InMessage) Command() string {
+  if !m.IsCommand() {
+    return ""
+  }
+  cmd := strings.Fields(m.Text)[0][1:]
+  if i := strings.Index(cmd, "@"); i >= 0 {
+    cmd = cmd[:i]
+  }
+  return cmd
+}
+
+// CommandArguments returns everything after the command name.
+func (m *InMessage) CommandArguments() string {
+  if !m.IsCommand() {
+    return ""
+  }
+  if i := strings.IndexAny(m.Text, " \n"); i >= 0 {
+    return strings.TrimSpace(m.Text[i+1:])
+  }
+  return ""
+}
+
+// InCallback is an incoming inline keyboard button press.
+type InCallback struct {
+  ID        string
+  ChatID    int64
+  UserID    int64
+  MessageID int
+  Data      string
+  Log       *slog.Logger // Carries the update's IDs; see log
+}
+
+// log returns the logger for handling q.
+func (q *InCallback) log() *slog.Logger {
+  if q.Log == nil {
+    return slog.With("chat_id", q.ChatID, "user_id", q.UserID)
+  }
+  return q.Log
+}
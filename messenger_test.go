@@ -0,0 +1,141 @@
+package main
+
+import (
+  "sync"
+  "testing"
+)
+
+// sentMessage is a message recorded by fakeMessenger. Edits change the
+// recorded message in place.
+type sentMessage struct {
+  ChatID   int64
+  ID       int
+  Text     string
+  Keyboard Keyboard
+  Silent   bool
+  Document string // File name, for SendDocument
+  Data     []byte
+}
+
+// fakeMessenger records everything the handlers send, for tests.
+type fakeMessenger struct {
+  mu      sync.Mutex
+  nextID  int
+  sent    []*sentMessage
+  pinned  map[int]bool
+  answers []string // Toasts of answered callbacks, "" for none
+}
+
+func newFakeMessenger() *fakeMessenger {
+  return &fakeMessenger{pinned: make(map[int]bool)}
+}
+
+func (f *fakeMessenger) add(m sentMessage) int {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  f.nextID++
+  m.ID = f.nextID
+  f.sent = append(f.sent, &m)
+  return m.ID
+}
+
+func (f *fakeMessenger) find(chatID int64, msgID int) *sentMessage {
+  for _, m := range f.sent {
+    if m.ChatID == chatID && m.ID == msgID {
+      return m
+    }
+  }
+  return nil
+}
+
+func (f *fakeMessenger) SendText(chatID int64, text string) (int, error) {
+  return f.add(sentMessage{ChatID: chatID, Text: text}), nil
+}
+
+func (f *fakeMessenger) SendKeyboard(chatID int64, text string, kb Keyboard) (int, error) {
+  return f.add(sentMessage{ChatID: chatID, Text: text, Keyboard: kb}), nil
+}
+
+func (f *fakeMessenger) SendSilent(chatID int64, text string, kb Keyboard) (int, error) {
+  return f.add(sentMessage{ChatID: chatID, Text: text, Keyboard: kb, Silent: true}), nil
+}
+
+func (f *fakeMessenger) EditMessage(chatID int64, msgID int, text string, kb Keyboard) error {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  if m := f.find(chatID, msgID); m != nil {
+    m.Text, m.Keyboard = text, kb
+  }
+  return nil
+}
+
+func (f *fakeMessenger) EditKeyboard(chatID int64, msgID int, kb Keyboard) error {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  if m := f.find(chatID, msgID); m != nil {
+    m.Keyboard = kb
+  }
+  return nil
+}
+
+func (f *fakeMessenger) PinMessage(chatID int64, msgID int) error {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  f.pinned[msgID] = true
+  return nil
+}
+
+func (f *fakeMessenger) UnpinMessage(chatID int64, msgID int) error {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  delete(f.pinned, msgID)
+  return nil
+}
+
+func (f *fakeMessenger) AnswerCallback(callbackID, text string) error {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  f.answers = append(f.answers, text)
+  return nil
+}
+
+func (f *fakeMessenger) SendDocument(chatID int64, name string, data []byte, caption string) (int, error) {
+  return f.add(sentMessage{ChatID: chatID, Text: caption, Document: name, Data: data}), nil
+}
+
+// messages returns copies of the messages sent to chatID so far.
+func (f *fakeMessenger) messages(chatID int64) []sentMessage {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  var list []sentMessage
+  for _, m := range f.sent {
+    if m.ChatID == chatID {
+      list = append(list, *m)
+    }
+  }
+  return list
+}
+
+// last returns the latest message sent to chatID.
+func (f *fakeMessenger) last(t *testing.T, chatID int64) sentMessage {
+  t.Helper()
+  list := f.messages(chatID)
+  if len(list) == 0 {
+    t.Fatalf("nothing sent to chat %d", chatID)
+  }
+  return list[len(list)-1]
+}
+
+// press returns the callback of the button of m labelled label.
+func (m sentMessage) press(t *testing.T, label string) *InCallback {
+  t.Helper()
+  for _, row := range m.Keyboard {
+    for _, b := range row {
+      if b.Text == label {
+        return &InCallback{ID: "cb", ChatID: m.ChatID, UserID: m.ChatID, MessageID: m.ID, Data: b.Data}
+      }
+    }
+  }
+  t.Fatalf("message #%d has no button %q", m.ID, label)
+  return nil
+}
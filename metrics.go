@@ -0,0 +1,169 @@
+package main
+
+import (
+  "fmt"
+  "io"
+  "sort"
+  "strconv"
+  "sync"
+  "time"
+)
+
+// --------- Metrics ---------
+
+// The bot exposes a handful of counters and histograms in the Prometheus
+// text format. They are kept by hand rather than through a client
+// library, the format is simple and this is all the bot needs.
+
+// counterVec is a counter partitioned by one label.
+type counterVec struct {
+  name, help, label string
+  mu                sync.Mutex
+  values            map[string]int
+}
+
+func newCounterVec(name, help, label string) *counterVec {
+  return &counterVec{name: name, help: help, label: label, values: make(map[string]int)}
+}
+
+func (c *counterVec) inc(value string) {
+  c.mu.Lock()
+  c.values[value]++
+  c.mu.Unlock()
+}
+
+func (c *counterVec) write(w io.Writer) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+  for _, v := range sortedKeys(c.values) {
+    fmt.Fprintf(w, "%s{%s=%q} %d\n", c.name, c.label, v, c.values[v])
+  }
+}
+
+// histogram tracks durations in seconds.
+type histogram struct {
+  name, help string
+  bounds     []float64
+  mu         sync.Mutex
+  counts     []uint64 // counts[i] is observations <= bounds[i], not cumulative
+  sum        float64
+  count      uint64
+}
+
+// latencyBuckets suit Bot API calls, handlers and file writes alike.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+func newHistogram(name, help string) *histogram {
+  return &histogram{name: name, help: help, bounds: latencyBuckets, counts: make([]uint64, len(latencyBuckets))}
+}
+
+func (h *histogram) observe(d time.Duration) {
+  s := d.Seconds()
+  h.mu.Lock()
+  defer h.mu.Unlock()
+  h.sum += s
+  h.count++
+  for i, b := range h.bounds {
+    if s <= b {
+      h.counts[i]++
+      return
+    }
+  }
+}
+
+// since observes the time elapsed since start, for use with defer.
+func (h *histogram) since(start time.Time) {
+  h.observe(time.Since(start))
+}
+
+func (h *histogram) write(w io.Writer) {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+  fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+  var cum uint64
+  for i, b := range h.bounds {
+    cum += h.counts[i]
+    fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatFloat(b), cum)
+  }
+  fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+  fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", h.name, formatFloat(h.sum), h.name, h.count)
+}
+
+func writeGauge(w io.Writer, name, help string, values map[string]int, label string) {
+  fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+  if label == "" {
+    fmt.Fprintf(w, "%s %d\n", name, values[""])
+    return
+  }
+  for _, v := range sortedKeys(values) {
+    fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, v, values[v])
+  }
+}
+
+func formatFloat(f float64) string {
+  return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]int) []string {
+  keys := make([]string, 0, len(m))
+  for k := range m {
+    keys = append(keys, k)
+  }
+  sort.Strings(keys)
+  return keys
+}
+
+var metrics = struct {
+  fires            *counterVec
+  deliveryFailures *counterVec
+  updates          *counterVec
+  sendLatency      *histogram
+  updateDuration   *histogram
+  saveDuration     *histogram
+}{
+  fires:            newCounterVec("reminderbot_fires_total", "Reminder notifications fired.", "type"),
+  deliveryFailures: newCounterVec("reminderbot_delivery_failures_total", "Notifications that could not be delivered.", "channel"),
+  updates:          newCounterVec("reminderbot_updates_total", "Updates handled.", "kind"),
+  sendLatency:      newHistogram("reminderbot_send_duration_seconds", "Bot API call latency, rate limit waits excluded."),
+  updateDuration:   newHistogram("reminderbot_update_duration_seconds", "Time to handle one update."),
+  saveDuration:     newHistogram("reminderbot_storage_save_duration_seconds", "Time to write the storage file."),
+}
+
+// writeMetrics writes every metric in the Prometheus text format.
+// Gauges are read from the store and the scheduler when scraped.
+func writeMetrics(w io.Writer) {
+  reminders := map[string]int{"once": 0, "cron": 0}
+  chats := map[string]int{"active": 0, "inactive": 0}
+  store.mu.Lock()
+  for _, ud := range store.Reminder {
+    for _, r := range ud.Reminders {
+      if r.CronExpr != "" {
+        reminders["cron"]++
+      } else {
+        reminders["once"]++
+      }
+    }
+    if ud.InactiveSince != nil {
+      chats["inactive"]++
+    } else {
+      chats["active"]++
+    }
+  }
+  sessions := len(store.Sessions)
+  store.mu.Unlock()
+  schedMu.Lock()
+  timers := map[string]int{"once": len(onceTimers), "cron": len(cronQuitMap)}
+  schedMu.Unlock()
+
+  writeGauge(w, "reminderbot_reminders", "Stored reminders.", reminders, "type")
+  writeGauge(w, "reminderbot_scheduled_jobs", "Pending timers and running cron jobs.", timers, "type")
+  writeGauge(w, "reminderbot_chats", "Known chats.", chats, "state")
+  writeGauge(w, "reminderbot_active_sessions", "Reminder setup wizards in progress.", map[string]int{"": sessions}, "")
+  metrics.fires.write(w)
+  metrics.deliveryFailures.write(w)
+  metrics.updates.write(w)
+  metrics.sendLatency.write(w)
+  metrics.updateDuration.write(w)
+  metrics.saveDuration.write(w)
+}
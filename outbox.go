@@ -0,0 +1,168 @@
+package main
+
+import (
+  "errors"
+  "log/slog"
+  "net"
+  "net/url"
+  "sync"
+  "time"
+
+  tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --------- Outgoing Rate Limiting ---------
+
+// Telegram allows about 30 messages per second overall, roughly one per
+// second in a private chat and 20 per minute in a group.
+const (
+  globalSendRate  = 30.0
+  globalSendBurst = 30.0
+  privateSendRate = 1.0
+  groupSendRate   = 20.0 / 60
+  chatSendBurst   = 3.0
+
+  sendAttempts   = 5
+  sendBackoff    = time.Second
+  maxSendBackoff = 30 * time.Second
+)
+
+// bucket is a token bucket that hands out reservations: a caller takes a
+// token even when none is left and waits for the returned duration, so
+// waiting callers are served in order.
+type bucket struct {
+  rate   float64 // Tokens per second
+  burst  float64
+  tokens float64
+  last   time.Time
+}
+
+func newBucket(rate, burst float64) *bucket {
+  return &bucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *bucket) reserve(now time.Time) time.Duration {
+  b.tokens += now.Sub(b.last).Seconds() * b.rate
+  if b.tokens > b.burst {
+    b.tokens = b.burst
+  }
+  b.last = now
+  b.tokens--
+  if b.tokens >= 0 {
+    return 0
+  }
+  return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// take takes a token if one is available, without reserving one ahead.
+func (b *bucket) take(now time.Time) bool {
+  b.tokens += now.Sub(b.last).Seconds() * b.rate
+  if b.tokens > b.burst {
+    b.tokens = b.burst
+  }
+  b.last = now
+  if b.tokens < 1 {
+    return false
+  }
+  b.tokens--
+  return true
+}
+
+// pause makes the bucket empty for d, after a 429 from Telegram.
+func (b *bucket) pause(now time.Time, d time.Duration) {
+  b.last = now
+  if t := -d.Seconds() * b.rate; t < b.tokens {
+    b.tokens = t
+  }
+}
+
+// outbox paces every outgoing Bot API call and retries the ones that
+// fail for transient reasons.
+type outbox struct {
+  mu     sync.Mutex
+  global *bucket
+  chats  map[int64]*bucket
+}
+
+func newOutbox() *outbox {
+  return &outbox{global: newBucket(globalSendRate, globalSendBurst), chats: make(map[int64]*bucket)}
+}
+
+// chatBucket returns the chat's bucket. o.mu must be held.
+func (o *outbox) chatBucket(chatID int64, now time.Time) *bucket {
+  b, ok := o.chats[chatID]
+  if !ok {
+    if len(o.chats) > 4096 {
+      // Drop buckets that have refilled completely, they carry no state.
+      for id, cb := range o.chats {
+        if now.Sub(cb.last) > time.Minute {
+          delete(o.chats, id)
+        }
+      }
+    }
+    rate := privateSendRate
+    if chatID < 0 {
+      rate = groupSendRate
+    }
+    b = newBucket(rate, chatSendBurst)
+    o.chats[chatID] = b
+  }
+  return b
+}
+
+// wait blocks until chatID may send, first against its own limit and then
+// against the global one.
+func (o *outbox) wait(chatID int64) {
+  o.mu.Lock()
+  d := o.chatBucket(chatID, time.Now()).reserve(time.Now())
+  o.mu.Unlock()
+  time.Sleep(d)
+  o.mu.Lock()
+  d = o.global.reserve(time.Now())
+  o.mu.Unlock()
+  time.Sleep(d)
+}
+
+// do runs call within the rate limits, retrying flood-control (429),
+// server and network errors. Other API errors are returned at once.
+func (o *outbox) do(chatID int64, call func() error) error {
+  backoff := sendBackoff
+  var err error
+  for attempt := 1; attempt <= sendAttempts; attempt++ {
+    o.wait(chatID)
+    if err = call(); err == nil {
+      return nil
+    }
+    var apiErr *tgbotapi.Error
+    var delay time.Duration
+    switch {
+    case errors.As(err, &apiErr) && apiErr.RetryAfter > 0:
+      // The next wait sleeps out retry_after.
+      retryAfter := time.Duration(apiErr.RetryAfter) * time.Second
+      slog.Warn("flood control, retrying", "chat_id", chatID, "retry_in", retryAfter)
+      o.mu.Lock()
+      o.chatBucket(chatID, time.Now()).pause(time.Now(), retryAfter)
+      o.mu.Unlock()
+      continue
+    case errors.As(err, &apiErr) && apiErr.Code >= 500, isNetworkError(err):
+      delay = backoff
+      backoff *= 2
+      if backoff > maxSendBackoff {
+        backoff = maxSendBackoff
+      }
+    default:
+      return err
+    }
+    if attempt < sendAttempts {
+      slog.Warn("send failed, retrying", "chat_id", chatID, "attempt", attempt, "err", err, "retry_in", delay)
+      time.Sleep(delay)
+    }
+  }
+  return err
+}
+
+func isNetworkError(err error) bool {
+  var netErr net.Error
+  var urlErr *url.Error
+  return errors.As(err, &netErr) || errors.As(err, &urlErr)
+}
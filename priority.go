@@ -0,0 +1,169 @@
+package main
+
+import (
+  "log/slog"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// --------- Priorities ---------
+
+// A reminder's priority decides how its notification is sent: low ones
+// without a sound, high and urgent ones pinned in the chat, and urgent
+// ones repeated until Done or Snooze is pressed.
+
+const (
+  priorityLow    = "low"
+  priorityNormal = "normal" // Stored as ""
+  priorityHigh   = "high"
+  priorityUrgent = "urgent"
+
+  maxNags = 3 // Repeats of an urgent notification
+)
+
+var (
+  nagInterval = 5 * time.Minute // Shortened by tests
+
+  priorities = []string{priorityLow, priorityNormal, priorityHigh, priorityUrgent}
+
+  // priorityIcons mark reminders in /list.
+  priorityIcons = map[string]string{priorityLow: "🔹", priorityHigh: "❗", priorityUrgent: "🚨"}
+)
+
+// validPriority reports whether p is a priority; "" is normal.
+func validPriority(p string) bool {
+  return p == "" || indexOf(priorities, p) >= 0
+}
+
+// storedPriority returns p as it is stored on a reminder.
+func storedPriority(p string) string {
+  if p == priorityNormal {
+    return ""
+  }
+  return p
+}
+
+// pinsNotification reports whether r's notifications are pinned.
+func pinsNotification(r Reminder) bool {
+  return r.Priority == priorityHigh || r.Priority == priorityUrgent
+}
+
+// scheduleNag repeats an urgent notification due at scheduled after
+// nagInterval, unless it has been answered by then.
+func scheduleNag(chatID int64, rid int, scheduled time.Time) {
+  time.AfterFunc(nagInterval, func() {
+    if !beginFire() {
+      return
+    }
+    defer endFire()
+    ud := getUserData(chatID)
+    var r Reminder
+    found := false
+    for _, list := range [][]Reminder{ud.Reminders, ud.Archive} {
+      for _, cur := range list {
+        if cur.ID == rid && !found {
+          r, found = cur, true
+        }
+      }
+    }
+    if !found || r.Priority != priorityUrgent || ud.InactiveSince != nil {
+      return
+    }
+    sent := 0
+    for _, h := range r.History {
+      if h.Scheduled.Equal(scheduled) {
+        if h.Action != "" {
+          return
+        }
+        if h.Status == fireDelivered {
+          sent++
+        }
+      }
+    }
+    // Quiet hours stop the repeats; the first notification was delivered.
+    if sent == 0 || sent > maxNags || !overridesDND(ud, r) && ud.Quiet.active(time.Now(), ud.UTC) {
+      return
+    }
+    slog.Info("urgent reminder repeated", "chat_id", chatID, "reminder_id", rid, "repeat", sent)
+    recordFire(chatID, rid, fireReminder(chatID, r, scheduled))
+  })
+}
+
+// priorityKeyboard is the wizard's priority step.
+func priorityKeyboard(chatID int64, lang string) Keyboard {
+  button := func(p string) Button {
+    return callbackButton(chatID, plainText(lang, "priority_"+p), cbPriority, p)
+  }
+  return newKeyboard(
+    newRow(button(priorityLow), button(priorityNormal)),
+    newRow(button(priorityHigh), button(priorityUrgent)),
+  )
+}
+
+// askPriority moves the wizard to the priority step.
+func askPriority(s *Session) {
+  advanceSession(s, StagePriority)
+  sendKeyboard(s.ChatID, priorityKeyboard(s.ChatID, getUserData(s.ChatID).Lang), "prompt_priority")
+}
+
+// handlePriorityCallback takes the priority picked in the wizard and saves
+// the reminder.
+func handlePriorityCallback(q *InCallback, cb callback) error {
+  a := cb.args()
+  p := a.word(priorities...)
+  if err := a.end(); err != nil {
+    return err
+  }
+  s := getSession(q.ChatID)
+  if s.Stage != StagePriority {
+    return errCallbackStale
+  }
+  s.Temp.Priority = storedPriority(p)
+  editText(q.ChatID, q.MessageID, nil, "priority_picked", HTML(tr(getUserData(q.ChatID).Lang, "priority_"+p)))
+  finalizeReminder(s)
+  return nil
+}
+
+// handlePriorityCommand implements /priority <n> <priority>.
+func handlePriorityCommand(chatID int64, args string) {
+  ud := getUserData(chatID)
+  f := strings.Fields(strings.ToLower(args))
+  if len(f) != 2 || indexOf(priorities, f[1]) < 0 {
+    sendText(chatID, "priority_usage")
+    return
+  }
+  idx, err := strconv.Atoi(f[0])
+  if err != nil || idx < 1 || idx > len(ud.Reminders) {
+    sendText(chatID, "invalid_index")
+    return
+  }
+  rid := ud.Reminders[idx-1].ID
+  var r Reminder
+  found := false
+  updateUserData(chatID, func(ud *UserData) {
+    list := make([]Reminder, len(ud.Reminders))
+    for i, cur := range ud.Reminders {
+      if cur.ID == rid {
+        cur.Priority = storedPriority(f[1])
+        r, found = cur, true
+      }
+      list[i] = cur
+    }
+    ud.Reminders = list
+  })
+  if !found {
+    sendText(chatID, "invalid_index")
+    return
+  }
+  sendText(chatID, "priority_set", r.Name, HTML(tr(ud.Lang, "priority_"+f[1])))
+}
+
+// unpinFire unpins the notifications with msgIDs.
+func unpinFire(chatID int64, msgIDs []int) {
+  for _, id := range msgIDs {
+    if err := messenger.UnpinMessage(chatID, id); err != nil {
+      slog.Debug("unpin failed", "chat_id", chatID, "message_id", id, "err", err)
+    }
+  }
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+func TestPriorityLabelIsRendered(t *testing.T) {
+  fm := setupTest(t)
+  const chatID = 61
+  dir := t.TempDir()
+  if err := os.WriteFile(filepath.Join(dir, "en.json"), []byte(`{"priority_high": "<High> & *loud*", "priority_urgent": "<Urgent>"}`), 0644); err != nil {
+    t.Fatal(err)
+  }
+  if _, err := loadLocales(dir); err != nil {
+    t.Fatal(err)
+  }
+  defer loadLocales("")
+  updateUserData(chatID, func(ud *UserData) {
+    ud.Reminders = append(ud.Reminders, Reminder{ID: 401, Name: "Taxes", Date: "01/04/2030", Time: "9:00 am"})
+  })
+
+  send(chatID, "/priority 1 high")
+  text := fm.last(t, chatID).Text
+  if want := render("%s & *loud*", "<High>"); !strings.Contains(text, want) {
+    t.Fatalf("sent %q, want the label as %q", text, want)
+  }
+  send(chatID, "/list urgent")
+  if text := fm.last(t, chatID).Text; !strings.Contains(text, render("%s", "<Urgent>")) {
+    t.Fatalf("unescaped label in %q", text)
+  }
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "log/slog"
+  "strconv"
+  "time"
+)
+
+// --------- Personal Data ---------
+
+// /export sends everything the bot stores about a chat as a JSON document
+// that /import reads back, and /forgetme deletes it.
+
+const (
+  exportSchema  = "reminderbot-export"
+  exportVersion = 1
+)
+
+// dataExport is the /export document. Fields are only ever added within a
+// version; anything else bumps exportVersion.
+type dataExport struct {
+  Schema    string         `json:"schema"`
+  Version   int            `json:"version"`
+  Exported  time.Time      `json:"exported"`
+  ChatID    int64          `json:"chat_id"`
+  Settings  exportSettings `json:"settings"`
+  Reminders []Reminder     `json:"reminders"`
+  Channels  []Channel      `json:"channels,omitempty"`
+  Archive   []Reminder     `json:"archive,omitempty"`    // Delivered one-time reminders, with their history
+  Session   *Session       `json:"session,omitempty"`    // An unfinished /start or /time wizard
+  APITokens []time.Time    `json:"api_tokens,omitempty"` // Creation times; the tokens themselves are not stored
+  Feeds     []time.Time    `json:"feeds,omitempty"`      // Creation times of calendar feeds
+  Held      []HeldFire     `json:"held,omitempty"`       // Notifications held during quiet hours
+}
+
+type exportSettings struct {
+  Lang          string     `json:"lang"`
+  UTC           int        `json:"utc"`
+  InactiveSince *time.Time `json:"inactive_since,omitempty"`
+  Invited       bool       `json:"invited,omitempty"`
+  Digest        *DigestSettings `json:"digest,omitempty"`
+  Quiet         *QuietHours `json:"quiet,omitempty"`
+}
+
+// buildExport collects the chat's stored data.
+func buildExport(chatID int64) dataExport {
+  ud := getUserData(chatID)
+  exp := dataExport{
+    Schema:    exportSchema,
+    Version:   exportVersion,
+    Exported:  time.Now().UTC(),
+    ChatID:    chatID,
+    Settings:  exportSettings{Lang: ud.Lang, UTC: ud.UTC, InactiveSince: ud.InactiveSince, Invited: ud.Invited, Digest: ud.Digest, Quiet: ud.Quiet},
+    Held:      ud.Held,
+    Reminders: ud.Reminders,
+    Channels:  ud.Channels,
+    Archive:   ud.Archive,
+  }
+  store.mu.Lock()
+  if s, ok := store.Sessions[strconv.FormatInt(chatID, 10)]; ok {
+    exp.Session = s.clone()
+  }
+  for _, t := range store.APITokens {
+    if t.ChatID == chatID {
+      exp.APITokens = append(exp.APITokens, t.Created)
+    }
+  }
+  for _, f := range store.Feeds {
+    if f.ChatID == chatID {
+      exp.Feeds = append(exp.Feeds, f.Created)
+    }
+  }
+  store.mu.Unlock()
+  return exp
+}
+
+// sendExport sends the chat's data as reminders.json.
+func sendExport(chatID int64) {
+  data, err := json.MarshalIndent(buildExport(chatID), "", "  ")
+  if err != nil {
+    slog.Error("export failed", "chat_id", chatID, "err", err)
+    return
+  }
+  if _, err := messenger.SendDocument(chatID, "reminders.json", data, tr(getUserData(chatID).Lang, "export_json")); err != nil {
+    slog.Error("send export failed", "chat_id", chatID, "err", err)
+  }
+}
+
+// parseExportImport reads an /export document into import items and the
+// settings and channels to restore with them.
+func parseExportImport(data []byte) ([]importItem, *importRestore, error) {
+  var exp dataExport
+  if err := json.Unmarshal(data, &exp); err != nil {
+    return nil, nil, fmt.Errorf("invalid JSON: %v", err)
+  }
+  switch {
+  case exp.Schema != exportSchema:
+    return nil, nil, errors.New("not a file from /export")
+  case exp.Version < 1 || exp.Version > exportVersion:
+    return nil, nil, fmt.Errorf("export version %d is not supported", exp.Version)
+  }
+  var items []importItem
+  for _, r := range exp.Reminders {
+    a := toAPIReminder(r)
+    in := apiReminderInput{Name: &a.Name, Info: &a.Info, OverrideDND: &a.OverrideDND, Priority: &a.Priority}
+    if a.Cron != "" {
+      in.Cron, in.TZ = &a.Cron, &a.TZ
+    } else {
+      in.Date, in.Time = &a.Date, &a.Time
+    }
+    items = append(items, importItem{Label: r.Name, Input: in, Channels: r.Channels, Paused: r.Paused})
+  }
+  restore := &importRestore{Lang: exp.Settings.Lang, UTC: exp.Settings.UTC, Digest: exp.Settings.Digest, Quiet: exp.Settings.Quiet, Channels: exp.Channels}
+  return items, restore, nil
+}
+
+func handleForgetCommand(chatID int64) {
+  lang := getUserData(chatID).Lang
+  kb := newKeyboard(newRow(
+    callbackButton(chatID, plainText(lang, "btn_forget"), cbForget, "yes"),
+    callbackButton(chatID, plainText(lang, "btn_forget_keep"), cbForget, "no"),
+  ))
+  sendKeyboard(chatID, kb, "forget_prompt")
+}
+
+func handleForgetCallback(q *InCallback, cb callback) error {
+  a := cb.args()
+  answer := a.word("yes", "no")
+  if err := a.end(); err != nil {
+    return err
+  }
+  // Read the language first: anything that reads the chat's data after
+  // purgeChat would store it again.
+  lang := knownLang(q.ChatID)
+  messenger.EditKeyboard(q.ChatID, q.MessageID, nil)
+  if answer == "no" {
+    messenger.SendText(q.ChatID, tr(lang, "forget_kept"))
+    return nil
+  }
+  purgeChat(q.ChatID)
+  q.log().Info("chat data deleted on request")
+  messenger.SendText(q.ChatID, tr(lang, "forget_done"))
+  return nil
+}
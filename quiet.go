@@ -0,0 +1,260 @@
+package main
+
+import (
+  "context"
+  "log/slog"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// --------- Quiet Hours ---------
+
+// A chat can set a nightly window, in its UTC offset, during which
+// notifications are either held back and delivered together when it ends,
+// or sent without a sound. Reminders marked OverrideDND ignore it.
+
+// QuietHours is a chat's do-not-disturb window.
+type QuietHours struct {
+  Start  string `json:"start,omitempty"`  // "15:04" in the chat's offset; off when empty
+  End    string `json:"end,omitempty"`    // May be before Start, the window then spans midnight
+  Silent bool   `json:"silent,omitempty"` // Send silently instead of holding
+}
+
+// HeldFire is a notification held back during quiet hours. A cron
+// reminder due again while held is counted rather than held twice.
+type HeldFire struct {
+  ReminderID int       `json:"reminder_id"`
+  Scheduled  time.Time `json:"scheduled"` // When it was first due
+  Count      int       `json:"count"`
+}
+
+const quietInterval = time.Minute
+
+// active reports whether now falls in the window for a chat at UTC offset
+// utc.
+func (q *QuietHours) active(now time.Time, utc int) bool {
+  if q == nil {
+    return false
+  }
+  start, err1 := time.Parse("15:04", q.Start)
+  end, err2 := time.Parse("15:04", q.End)
+  if err1 != nil || err2 != nil {
+    return false
+  }
+  local := now.UTC().Add(time.Duration(utc) * time.Hour)
+  m := local.Hour()*60 + local.Minute()
+  s, e := start.Hour()*60+start.Minute(), end.Hour()*60+end.Minute()
+  if s <= e {
+    return m >= s && m < e
+  }
+  return m >= s || m < e
+}
+
+// overridesDND reports whether r ignores quiet hours. Cron jobs hold a
+// copy taken at start, so the stored reminder decides.
+func overridesDND(ud UserData, r Reminder) bool {
+  for _, cur := range ud.Reminders {
+    if cur.ID == r.ID {
+      return cur.OverrideDND
+    }
+  }
+  return r.OverrideDND
+}
+
+// sendsSilently reports whether r's notification goes out without a sound
+// right now.
+func sendsSilently(ud UserData, r Reminder) bool {
+  return ud.Quiet != nil && ud.Quiet.Silent && !overridesDND(ud, r) && ud.Quiet.active(time.Now(), ud.UTC)
+}
+
+// holdFire holds back r's notification due at scheduled if the chat is in
+// quiet hours, and reports whether it did.
+func holdFire(chatID int64, r Reminder, scheduled time.Time) bool {
+  ud := getUserData(chatID)
+  if overridesDND(ud, r) || ud.Quiet == nil || ud.Quiet.Silent || !ud.Quiet.active(time.Now(), ud.UTC) {
+    return false
+  }
+  updateUserData(chatID, func(ud *UserData) {
+    list := append([]HeldFire(nil), ud.Held...)
+    // Replaced rather than changed in place, copies may share it.
+    defer func() { ud.Held = list }()
+    for i := range list {
+      if list[i].ReminderID == r.ID {
+        // A one-time reminder rescheduled after a restart is due again at
+        // the same time.
+        if !list[i].Scheduled.Equal(scheduled.UTC()) {
+          list[i].Count++
+        }
+        return
+      }
+    }
+    list = append(list, HeldFire{ReminderID: r.ID, Scheduled: scheduled.UTC(), Count: 1})
+  })
+  slog.Info("notification held for quiet hours", "chat_id", chatID, "reminder_id", r.ID)
+  return true
+}
+
+// runQuietHours delivers held notifications every minute once their
+// chat's quiet hours are over, until ctx is done.
+func runQuietHours(ctx context.Context) {
+  tick := time.NewTicker(quietInterval)
+  defer tick.Stop()
+  for {
+    select {
+    case <-ctx.Done():
+      return
+    case now := <-tick.C:
+      releaseHeld(now.UTC())
+    }
+  }
+}
+
+// releaseHeld delivers the held notifications of every chat no longer in
+// quiet hours at now.
+func releaseHeld(now time.Time) {
+  var chats []int64
+  store.mu.Lock()
+  for key, ud := range store.Reminder {
+    if len(ud.Held) > 0 && ud.InactiveSince == nil && !ud.Quiet.active(now, ud.UTC) {
+      id, _ := strconv.ParseInt(key, 10, 64)
+      chats = append(chats, id)
+    }
+  }
+  store.mu.Unlock()
+
+  for _, chatID := range chats {
+    if !beginFire() {
+      return
+    }
+    var held []HeldFire
+    updateUserData(chatID, func(ud *UserData) {
+      held, ud.Held = ud.Held, nil
+    })
+    deliverHeld(chatID, held)
+    endFire()
+  }
+}
+
+// deliverHeld sends a summary of held, then each notification in it whose
+// reminder still exists.
+func deliverHeld(chatID int64, held []HeldFire) {
+  ud := getUserData(chatID)
+  type due struct {
+    r         Reminder
+    scheduled time.Time
+  }
+  var list []due
+  text := tr(ud.Lang, "quiet_over")
+  for _, h := range held {
+    for _, r := range ud.Reminders {
+      if r.ID == h.ReminderID {
+        list = append(list, due{r, h.Scheduled})
+        text += render("\n• %s", r.Name)
+        if h.Count > 1 {
+          text += " " + tr(ud.Lang, "quiet_held_times", h.Count)
+        }
+      }
+    }
+  }
+  if len(list) == 0 {
+    return
+  }
+  slog.Info("held notifications delivered", "chat_id", chatID, "count", len(list))
+  messenger.SendText(chatID, text)
+  for _, d := range list {
+    r := d.r
+    kind := "cron"
+    if r.CronExpr == "" {
+      kind = "once"
+    }
+    metrics.fires.inc(kind)
+    countFire()
+    rec := fireReminder(chatID, r, d.scheduled)
+    if r.CronExpr == "" && rec.Status == fireDelivered {
+      archiveReminder(chatID, r.ID, rec)
+    } else {
+      recordFire(chatID, r.ID, rec)
+    }
+  }
+}
+
+func handleQuietCommand(chatID int64, args string) {
+  ud := getUserData(chatID)
+  f := strings.Fields(strings.ToLower(args))
+  set := func(fn func(q *QuietHours)) {
+    updateUserData(chatID, func(ud *UserData) {
+      var q QuietHours
+      if ud.Quiet != nil {
+        q = *ud.Quiet
+      }
+      fn(&q)
+      ud.Quiet = &q
+    })
+  }
+  switch {
+  case len(f) == 0:
+  case len(f) == 1 && f[0] == "off":
+    set(func(q *QuietHours) { q.Start, q.End = "", "" })
+  case len(f) == 1 && (f[0] == "hold" || f[0] == "silent"):
+    set(func(q *QuietHours) { q.Silent = f[0] == "silent" })
+  case len(f) == 2 && f[0] == "override":
+    idx, err := strconv.Atoi(f[1])
+    if err != nil || idx < 1 || idx > len(ud.Reminders) {
+      sendText(chatID, "invalid_index")
+      return
+    }
+    toggleOverride(chatID, ud.Reminders[idx-1].ID)
+    return
+  case len(f) == 1:
+    a, b, ok := strings.Cut(f[0], "-")
+    start, err1 := time.Parse("15:04", a)
+    end, err2 := time.Parse("15:04", b)
+    if !ok || err1 != nil || err2 != nil || start.Equal(end) {
+      sendText(chatID, "quiet_usage")
+      return
+    }
+    set(func(q *QuietHours) { q.Start, q.End = start.Format("15:04"), end.Format("15:04") })
+  default:
+    sendText(chatID, "quiet_usage")
+    return
+  }
+
+  ud = getUserData(chatID)
+  window := plainText(ud.Lang, "quiet_off")
+  silent := false
+  if q := ud.Quiet; q != nil {
+    start, err1 := time.Parse("15:04", q.Start)
+    end, err2 := time.Parse("15:04", q.End)
+    if err1 == nil && err2 == nil {
+      window = tr(ud.Lang, "quiet_window", LocalTime(start), LocalTime(end))
+    }
+    silent = q.Silent
+  }
+  mode := "quiet_mode_hold"
+  if silent {
+    mode = "quiet_mode_silent"
+  }
+  sendText(chatID, "quiet_status", HTML(window), HTML(tr(ud.Lang, mode)))
+}
+
+// toggleOverride flips whether the reminder with rid ignores quiet hours.
+func toggleOverride(chatID int64, rid int) {
+  var r Reminder
+  updateUserData(chatID, func(ud *UserData) {
+    list := make([]Reminder, len(ud.Reminders))
+    for i, cur := range ud.Reminders {
+      if cur.ID == rid {
+        cur.OverrideDND = !cur.OverrideDND
+        r = cur
+      }
+      list[i] = cur
+    }
+    ud.Reminders = list
+  })
+  if r.OverrideDND {
+    sendText(chatID, "quiet_override_on", r.Name)
+  } else {
+    sendText(chatID, "quiet_override_off", r.Name)
+  }
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+  "testing"
+  "time"
+)
+
+func TestQuietHoursActive(t *testing.T) {
+  night := &QuietHours{Start: "22:00", End: "07:00"}
+  lunch := &QuietHours{Start: "12:00", End: "13:30"}
+  for _, tc := range []struct {
+    name string
+    q    *QuietHours
+    now  string // UTC
+    utc  int
+    want bool
+  }{
+    {"off", nil, "23:00", 0, false},
+    {"invalid start", &QuietHours{Start: "25:00", End: "07:00"}, "23:00", 0, false},
+    {"invalid end", &QuietHours{Start: "22:00"}, "23:00", 0, false},
+    {"same day before", lunch, "11:59", 0, false},
+    {"same day at start", lunch, "12:00", 0, true},
+    {"same day inside", lunch, "13:29", 0, true},
+    {"same day at end", lunch, "13:30", 0, false},
+    {"overnight before", night, "21:59", 0, false},
+    {"overnight at start", night, "22:00", 0, true},
+    {"overnight at midnight", night, "00:00", 0, true},
+    {"overnight after midnight", night, "06:59", 0, true},
+    {"overnight at end", night, "07:00", 0, false},
+    {"overnight midday", night, "12:00", 0, false},
+    {"east offset crosses into window", night, "14:30", 8, true},    // 22:30 local
+    {"east offset after window", night, "23:30", 8, false},          // 07:30 local the next day
+    {"west offset crosses midnight back", night, "04:00", -5, true}, // 23:00 local the day before
+    {"west offset before window", night, "02:00", -5, false},        // 21:00 local the day before
+    {"empty window", &QuietHours{Start: "09:00", End: "09:00"}, "09:00", 0, false},
+  } {
+    t.Run(tc.name, func(t *testing.T) {
+      clock, err := time.Parse("15:04", tc.now)
+      if err != nil {
+        t.Fatal(err)
+      }
+      now := time.Date(2030, 3, 1, clock.Hour(), clock.Minute(), 0, 0, time.UTC)
+      if got := tc.q.active(now, tc.utc); got != tc.want {
+        t.Fatalf("active at %s UTC%+d = %v, want %v", tc.now, tc.utc, got, tc.want)
+      }
+    })
+  }
+}
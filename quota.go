@@ -0,0 +1,194 @@
+package main
+
+import (
+  "errors"
+  "log/slog"
+  "sync"
+  "time"
+  "unicode/utf8"
+
+  "github.com/gorhill/cronexpr"
+)
+
+// --------- Quotas ---------
+
+// LimitSettings are per-chat limits that keep one chat from hogging the
+// bot. Zero means the default.
+type LimitSettings struct {
+  MaxReminders      int `json:"max_reminders,omitempty"`       // Stored reminders, default 50
+  MaxTextLength     int `json:"max_text_length,omitempty"`     // Characters in a name, extra info or cron text, default 500
+  MinCronInterval   int `json:"min_cron_interval,omitempty"`   // Minutes between two fires of a cron reminder, default 5
+  FiresPerHour      int `json:"fires_per_hour,omitempty"`      // Notifications sent to a chat, default 60
+  CommandsPerMinute int `json:"commands_per_minute,omitempty"` // Messages and button presses handled, default 20
+}
+
+const (
+  defaultMaxReminders      = 50
+  defaultMaxTextLength     = 500
+  defaultMinCronInterval   = 5 * time.Minute
+  defaultFiresPerHour      = 60
+  defaultCommandsPerMinute = 20
+
+  // cronIntervalSamples is how many upcoming fires of a cron expression
+  // are checked against the minimum interval.
+  cronIntervalSamples = 64
+)
+
+func (l LimitSettings) maxReminders() int {
+  if l.MaxReminders <= 0 {
+    return defaultMaxReminders
+  }
+  return l.MaxReminders
+}
+
+func (l LimitSettings) maxTextLength() int {
+  if l.MaxTextLength <= 0 {
+    return defaultMaxTextLength
+  }
+  return l.MaxTextLength
+}
+
+func (l LimitSettings) minCronInterval() time.Duration {
+  if l.MinCronInterval <= 0 {
+    return defaultMinCronInterval
+  }
+  return time.Duration(l.MinCronInterval) * time.Minute
+}
+
+func (l LimitSettings) firesPerHour() int {
+  if l.FiresPerHour <= 0 {
+    return defaultFiresPerHour
+  }
+  return l.FiresPerHour
+}
+
+func (l LimitSettings) commandsPerMinute() int {
+  if l.CommandsPerMinute <= 0 {
+    return defaultCommandsPerMinute
+  }
+  return l.CommandsPerMinute
+}
+
+// checkReminderQuota tells the user and returns false if the chat may not
+// add another reminder.
+func checkReminderQuota(chatID int64) bool {
+  max := config().Limits.maxReminders()
+  if len(getUserData(chatID).Reminders) < max {
+    return true
+  }
+  sendText(chatID, "limit_reminders", Count{max, "reminders"})
+  return false
+}
+
+// checkTextLength tells the user and returns false if text is too long.
+func checkTextLength(chatID int64, text string) bool {
+  max := config().Limits.maxTextLength()
+  n := utf8.RuneCountInString(text)
+  if n <= max {
+    return true
+  }
+  sendText(chatID, "limit_text_length", n, max)
+  return false
+}
+
+// checkCronInterval tells the user and returns false if expr fires more
+// often than allowed.
+func checkCronInterval(chatID int64, expr *cronexpr.Expression, loc *time.Location) bool {
+  if cronIntervalOK(expr, loc) {
+    return true
+  }
+  sendText(chatID, "limit_cron_interval", LocalSpan(config().Limits.minCronInterval()))
+  return false
+}
+
+// cronIntervalOK reports whether expr keeps to the minimum interval.
+func cronIntervalOK(expr *cronexpr.Expression, loc *time.Location) bool {
+  min := config().Limits.minCronInterval()
+  fires := expr.NextN(time.Now().In(loc), cronIntervalSamples)
+  for i := 1; i < len(fires); i++ {
+    if fires[i].Sub(fires[i-1]) < min {
+      return false
+    }
+  }
+  return true
+}
+
+// chatLimiter is a token bucket per chat. A chat that runs dry is warned
+// once until it has tokens again.
+type chatLimiter struct {
+  mu      sync.Mutex
+  buckets map[int64]*bucket
+  warned  map[int64]bool
+}
+
+func newChatLimiter() *chatLimiter {
+  return &chatLimiter{buckets: make(map[int64]*bucket), warned: make(map[int64]bool)}
+}
+
+// allow takes a token for chatID from a bucket holding perPeriod tokens
+// that refill over period. warn reports whether the chat has just run out
+// and should be told.
+func (l *chatLimiter) allow(chatID int64, perPeriod int, period time.Duration) (ok, warn bool) {
+  now := time.Now()
+  l.mu.Lock()
+  defer l.mu.Unlock()
+  rate := float64(perPeriod) / period.Seconds()
+  b, found := l.buckets[chatID]
+  if !found || b.rate != rate {
+    if len(l.buckets) > 4096 {
+      // Drop buckets that have refilled completely, they carry no state.
+      for id, cb := range l.buckets {
+        if now.Sub(cb.last) > period {
+          delete(l.buckets, id)
+          delete(l.warned, id)
+        }
+      }
+    }
+    b = newBucket(rate, float64(perPeriod))
+    l.buckets[chatID] = b
+  }
+  if b.take(now) {
+    l.warned[chatID] = false
+    return true, false
+  }
+  warn = !l.warned[chatID]
+  l.warned[chatID] = true
+  return false, warn
+}
+
+var (
+  commandLimiter = newChatLimiter()
+  fireLimiter    = newChatLimiter()
+)
+
+// allowCommand rate-limits what a user sends. Admins are not limited.
+func allowCommand(chatID, userID int64) bool {
+  if config().isAdmin(userID) {
+    return true
+  }
+  ok, warn := commandLimiter.allow(chatID, config().Limits.commandsPerMinute(), time.Minute)
+  if !ok {
+    slog.Info("command rate limited", "chat_id", chatID, "user_id", userID)
+    if warn {
+      messenger.SendText(chatID, tr(knownLang(chatID), "limit_commands"))
+    }
+  }
+  return ok
+}
+
+// errFireLimit is recorded on reminders skipped by allowFire.
+var errFireLimit = errors.New("fires per hour limit reached")
+
+// allowFire limits how many notifications a chat gets per hour. A skipped
+// fire is recorded on the reminder so /list shows it.
+func allowFire(chatID int64, rid int) bool {
+  perHour := config().Limits.firesPerHour()
+  ok, warn := fireLimiter.allow(chatID, perHour, time.Hour)
+  if !ok {
+    recordFailure(chatID, rid, "chat", errFireLimit)
+    if warn {
+      sendText(chatID, "limit_fires", perHour)
+    }
+  }
+  return ok
+}
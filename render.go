@@ -0,0 +1,115 @@
+package main
+
+import (
+  "fmt"
+  "html"
+  "regexp"
+  "strings"
+)
+
+// --------- Rendering ---------
+
+// Message templates use a small markup: *bold*, `code` and ```pre```
+// blocks. render turns a template into Telegram HTML and escapes every
+// argument, so user-supplied names and texts can never break a message
+// or inject formatting.
+
+// HTML is text that is already rendered. render inserts it as is.
+type HTML string
+
+// render formats tmpl like fmt.Sprintf, with the template's markup turned
+// into HTML and the arguments escaped.
+func render(tmpl string, a ...interface{}) string {
+  args := make([]interface{}, len(a))
+  for i, v := range a {
+    switch v := v.(type) {
+    case HTML:
+      args[i] = string(v)
+    case string:
+      args[i] = html.EscapeString(v)
+    case error:
+      args[i] = html.EscapeString(v.Error())
+    case fmt.Stringer:
+      args[i] = html.EscapeString(v.String())
+    default:
+      args[i] = v
+    }
+  }
+  return fmt.Sprintf(markup(tmpl), args...)
+}
+
+// markup converts template markup to HTML, escaping everything else.
+// Unclosed markers are closed at the end.
+func markup(s string) string {
+  h, _ := scanMarkup(s)
+  return h
+}
+
+// checkMarkup reports an error if s leaves a marker open.
+func checkMarkup(s string) error {
+  if _, open := scanMarkup(s); open != "" {
+    return fmt.Errorf("unclosed %s", open)
+  }
+  return nil
+}
+
+// scanMarkup does the work of markup. open is the first marker left
+// unclosed, if any.
+func scanMarkup(s string) (h, open string) {
+  var b strings.Builder
+  var bold, code, pre bool
+  toggle := func(on *bool, tag string) {
+    if *on {
+      b.WriteString("</" + tag + ">")
+    } else {
+      b.WriteString("<" + tag + ">")
+    }
+    *on = !*on
+  }
+  for i := 0; i < len(s); i++ {
+    switch {
+    case !code && strings.HasPrefix(s[i:], "```"):
+      toggle(&pre, "pre")
+      i += 2
+    case !pre && s[i] == '`':
+      toggle(&code, "code")
+    case !pre && !code && s[i] == '*':
+      toggle(&bold, "b")
+    case s[i] == '&':
+      b.WriteString("&amp;")
+    case s[i] == '<':
+      b.WriteString("&lt;")
+    case s[i] == '>':
+      b.WriteString("&gt;")
+    default:
+      b.WriteByte(s[i])
+    }
+  }
+  switch {
+  case pre:
+    open = "```"
+  case code:
+    open = "`"
+  case bold:
+    open = "*"
+  }
+  if code {
+    toggle(&code, "code")
+  }
+  if bold {
+    toggle(&bold, "b")
+  }
+  if pre {
+    toggle(&pre, "pre")
+  }
+  return b.String(), open
+}
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// stripHTML turns rendered text into plain text, for places that cannot
+// show formatting: other channels, callback toasts, the console, and
+// Telegram itself when it rejects the HTML.
+func stripHTML(s string) string {
+  return html.UnescapeString(htmlTag.ReplaceAllString(s, ""))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+  "sync"
+  "time"
+
+  "github.com/gorhill/cronexpr"
+)
+
+// --------- Job Tracking ---------
+
+var (
+  schedMu      sync.Mutex
+  onceTimers   = make(map[int]*time.Timer) // Pending one-time reminders by ID
+  schedStopped bool                        // Set on shutdown, no new fires start after it
+  firesWG      sync.WaitGroup              // Notifications currently being sent
+)
+
+// startCronJob launches the goroutine for a cron reminder and registers
+// its quit channel, stopping any job already running for it.
+func startCronJob(chatID int64, r Reminder, expr *cronexpr.Expression, loc *time.Location) {
+  quit := make(chan struct{})
+  schedMu.Lock()
+  stopJobLocked(r.ID)
+  cronQuitMap[r.ID] = quit
+  schedMu.Unlock()
+  go runExprJob(chatID, r, expr, loc, quit)
+}
+
+// scheduleReminder starts the job for a stored reminder, cron or one-time.
+func scheduleReminder(chatID int64, r Reminder) {
+  if r.Paused {
+    return
+  }
+  if r.CronExpr == "" {
+    // Undelivered one-time reminders stay listed until cancelled.
+    if len(r.Failures) == 0 {
+      scheduleOnce(chatID, r)
+    }
+    return
+  }
+  loc, err := time.LoadLocation(r.TZ)
+  if err != nil {
+    return
+  }
+  expr, err := cronexpr.Parse(r.CronOriginal)
+  if err != nil {
+    return
+  }
+  startCronJob(chatID, r, expr, loc)
+}
+
+// fireStatsDays is how many days of fire counts /stats keeps.
+const fireStatsDays = 30
+
+// countFire adds a notification to today's count in the store. It is
+// saved with the next write.
+func countFire() {
+  now := time.Now().UTC()
+  cutoff := now.AddDate(0, 0, -fireStatsDays).Format("2006-01-02")
+  store.mu.Lock()
+  defer store.mu.Unlock()
+  store.Fires[now.Format("2006-01-02")]++
+  for day := range store.Fires {
+    if day < cutoff {
+      delete(store.Fires, day)
+    }
+  }
+}
+
+// cancelJob stops whatever is scheduled for reminder id.
+func cancelJob(id int) {
+  schedMu.Lock()
+  defer schedMu.Unlock()
+  stopJobLocked(id)
+}
+
+// stopJobLocked is cancelJob with schedMu held.
+func stopJobLocked(id int) {
+  if quit, ok := cronQuitMap[id]; ok {
+    close(quit)
+    delete(cronQuitMap, id)
+  }
+  if t, ok := onceTimers[id]; ok {
+    t.Stop()
+    delete(onceTimers, id)
+  }
+}
+
+// beginFire must be called before a reminder notification is sent. It
+// returns false once the scheduler is stopped; otherwise endFire must be
+// called when sending is done.
+func beginFire() bool {
+  schedMu.Lock()
+  defer schedMu.Unlock()
+  if schedStopped {
+    return false
+  }
+  firesWG.Add(1)
+  return true
+}
+
+func endFire() {
+  firesWG.Done()
+}
+
+// stopScheduler cancels every timer and cron goroutine and waits for
+// notifications already being sent, up to the deadline of done.
+func stopScheduler(done <-chan struct{}) bool {
+  schedMu.Lock()
+  schedStopped = true
+  for id, quit := range cronQuitMap {
+    close(quit)
+    delete(cronQuitMap, id)
+  }
+  for id, t := range onceTimers {
+    t.Stop()
+    delete(onceTimers, id)
+  }
+  schedMu.Unlock()
+
+  drained := make(chan struct{})
+  go func() {
+    firesWG.Wait()
+    close(drained)
+  }()
+  select {
+  case <-drained:
+    return true
+  case <-done:
+    return false
+  }
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+  "testing"
+  "time"
+
+  "github.com/gorhill/cronexpr"
+)
+
+// pastReminder is a one-time reminder long due, which fires a second
+// after it is scheduled.
+func pastReminder(chatID int64, id int) Reminder {
+  r := Reminder{ID: id, Name: "overdue", Date: "01/01/2020", Time: "9:00 am"}
+  updateUserData(chatID, func(ud *UserData) {
+    ud.Reminders = append(ud.Reminders, r)
+  })
+  return r
+}
+
+// waitMessages waits up to timeout for chatID to receive n messages and
+// returns what it received by then.
+func waitMessages(fm *fakeMessenger, chatID int64, n int, timeout time.Duration) []sentMessage {
+  deadline := time.Now().Add(timeout)
+  for {
+    list := fm.messages(chatID)
+    if len(list) >= n || time.Now().After(deadline) {
+      return list
+    }
+    time.Sleep(20 * time.Millisecond)
+  }
+}
+
+func TestScheduleOnceReplacesPendingTimer(t *testing.T) {
+  fm := setupTest(t)
+  const chatID = 11
+  r := pastReminder(chatID, 101)
+  defer cancelJob(r.ID)
+
+  scheduleOnce(chatID, r)
+  scheduleOnce(chatID, r)
+  if got := waitMessages(fm, chatID, 1, 3*time.Second); len(got) != 1 {
+    t.Fatalf("%d notifications, want 1", len(got))
+  }
+  // The replaced timer would have fired by now.
+  time.Sleep(1500 * time.Millisecond)
+  if got := fm.messages(chatID); len(got) != 1 {
+    t.Fatalf("%d notifications after scheduling twice, want 1", len(got))
+  }
+}
+
+func TestCancelJobStopsOnce(t *testing.T) {
+  fm := setupTest(t)
+  const chatID = 12
+  r := pastReminder(chatID, 102)
+
+  scheduleOnce(chatID, r)
+  cancelJob(r.ID)
+  schedMu.Lock()
+  _, pending := onceTimers[r.ID]
+  schedMu.Unlock()
+  if pending {
+    t.Fatal("timer still tracked after cancelJob")
+  }
+  time.Sleep(1500 * time.Millisecond)
+  if got := fm.messages(chatID); len(got) != 0 {
+    t.Fatalf("cancelled reminder sent %d notifications", len(got))
+  }
+}
+
+func TestStartCronJobReplacesRunningJob(t *testing.T) {
+  setupTest(t)
+  const chatID = 13
+  r := Reminder{ID: 103, CronExpr: "0 0 1 1 *", CronOriginal: "0 0 1 1 *", TZ: "UTC"}
+  quitOf := func() chan struct{} {
+    schedMu.Lock()
+    defer schedMu.Unlock()
+    return cronQuitMap[r.ID]
+  }
+  closed := func(ch chan struct{}) bool {
+    select {
+    case <-ch:
+      return true
+    default:
+      return false
+    }
+  }
+
+  // Each job needs its own expression, Next changes it.
+  startCronJob(chatID, r, cronexpr.MustParse(r.CronOriginal), time.UTC)
+  first := quitOf()
+  startCronJob(chatID, r, cronexpr.MustParse(r.CronOriginal), time.UTC)
+  second := quitOf()
+  if first == nil || second == nil || first == second {
+    t.Fatal("second start did not register a new job")
+  }
+  if !closed(first) {
+    t.Fatal("first job still running after the second start")
+  }
+  if closed(second) {
+    t.Fatal("second job stopped")
+  }
+
+  cancelJob(r.ID)
+  if !closed(second) || quitOf() != nil {
+    t.Fatal("job still running after cancelJob")
+  }
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+  "context"
+  "log/slog"
+  "strconv"
+  "time"
+)
+
+// --------- Session ---------
+type Stage int
+
+const (
+  StageIdle Stage = iota
+  StageName
+  StageDate
+  StageTime
+  StageAskInfo
+  StageOptInfo
+  StageUTC
+  StagePriority
+)
+
+// Session is the state of a chat's /start or /time wizard. Sessions live
+// in the store, so a restart in the middle of a wizard resumes it.
+// Handlers work on a private copy from getSession; stored sessions are
+// only ever replaced under store.mu, never modified in place.
+type Session struct {
+  Stage   Stage     `json:"stage"`
+  Temp    Reminder  `json:"temp"`
+  ChatID  int64     `json:"chat_id"`
+  History []Stage   `json:"history,omitempty"` // Previous stages, for /back
+  EditID  int       `json:"edit_id,omitempty"` // Reminder the wizard replaces, 0 for a new one
+  Updated time.Time `json:"updated"`
+}
+
+// stageTimeouts is how long a session may sit idle in each stage before
+// it expires.
+var stageTimeouts = map[Stage]time.Duration{
+  StageName:    30 * time.Minute,
+  StageDate:    30 * time.Minute,
+  StageTime:    30 * time.Minute,
+  StageAskInfo: 15 * time.Minute,
+  StageOptInfo: 15 * time.Minute,
+  StageUTC:     10 * time.Minute,
+  StagePriority: 15 * time.Minute,
+}
+
+const sessionSweepInterval = time.Minute
+
+// getSession returns a copy of the chat's session. Idle sessions are not
+// stored until advanceSession starts a wizard.
+func getSession(chatID int64) *Session {
+  store.mu.Lock()
+  defer store.mu.Unlock()
+  if s, ok := store.Sessions[strconv.FormatInt(chatID, 10)]; ok {
+    return s.clone()
+  }
+  return &Session{Stage: StageIdle, ChatID: chatID}
+}
+
+func (s *Session) clone() *Session {
+  cp := *s
+  cp.History = append([]Stage(nil), s.History...)
+  return &cp
+}
+
+// putSession stores a copy of s and saves the store.
+func putSession(s *Session) {
+  store.mu.Lock()
+  store.Sessions[strconv.FormatInt(s.ChatID, 10)] = s.clone()
+  store.mu.Unlock()
+  saveStorage()
+}
+
+// advanceSession moves s to the next stage and persists it.
+func advanceSession(s *Session, next Stage) {
+  if s.Stage != StageIdle {
+    s.History = append(s.History, s.Stage)
+  }
+  s.Stage = next
+  s.Updated = time.Now()
+  putSession(s)
+}
+
+// resetSession ends the wizard and drops the session from storage.
+func resetSession(s *Session) {
+  wasActive := s.Stage != StageIdle
+  s.Stage = StageIdle
+  s.Temp = Reminder{}
+  s.History = nil
+  s.EditID = 0
+  if !wasActive {
+    return
+  }
+  store.mu.Lock()
+  delete(store.Sessions, strconv.FormatInt(s.ChatID, 10))
+  store.mu.Unlock()
+  saveStorage()
+}
+
+// expired reports whether s has been idle longer than its stage allows.
+func (s *Session) expired(now time.Time) bool {
+  timeout, ok := stageTimeouts[s.Stage]
+  return ok && now.Sub(s.Updated) > timeout
+}
+
+// expireSession drops the chat's session and tells the user if it has
+// timed out.
+func expireSession(chatID int64) bool {
+  key := strconv.FormatInt(chatID, 10)
+  store.mu.Lock()
+  s, ok := store.Sessions[key]
+  expired := ok && s.expired(time.Now())
+  if expired {
+    delete(store.Sessions, key)
+  }
+  store.mu.Unlock()
+  if !expired {
+    return false
+  }
+  saveStorage()
+  sendText(chatID, "setup_expired")
+  return true
+}
+
+// sweepSessions expires abandoned sessions until ctx is done.
+func sweepSessions(ctx context.Context) {
+  tick := time.NewTicker(sessionSweepInterval)
+  defer tick.Stop()
+  for {
+    select {
+    case <-ctx.Done():
+      return
+    case <-tick.C:
+      store.mu.Lock()
+      var stale []int64
+      for _, s := range store.Sessions {
+        if s.expired(time.Now()) {
+          stale = append(stale, s.ChatID)
+        }
+      }
+      store.mu.Unlock()
+      for _, chatID := range stale {
+        // Re-checked under the lock, the user may have just come back.
+        if expireSession(chatID) {
+          slog.Info("session expired", "chat_id", chatID)
+        }
+      }
+    }
+  }
+}
+
+// backSession undoes the last wizard step and prompts for it again.
+func backSession(s *Session) {
+  chatID := s.ChatID
+  ud := getUserData(chatID)
+  if s.Stage == StageIdle {
+    sendText(chatID, "back_nothing")
+    return
+  }
+  if len(s.History) == 0 {
+    resetSession(s)
+    sendText(chatID, "cancelled")
+    return
+  }
+  prev := s.History[len(s.History)-1]
+  s.History = s.History[:len(s.History)-1]
+  s.Stage = prev
+  s.Updated = time.Now()
+  putSession(s)
+
+  switch prev {
+  case StageName:
+    sendText(chatID, "prompt_name")
+  case StageDate:
+    sendKeyboard(chatID, CreateCalendar(chatID, time.Now().Year(), int(time.Now().Month())), "prompt_date")
+  case StageTime:
+    at, err := reminderWallClock(Reminder{Date: s.Temp.Date, Time: "12:00 am"})
+    if err != nil {
+      sendKeyboard(chatID, CreateClock(chatID, 12, 0, "am"), "prompt_time", s.Temp.Date)
+    } else {
+      sendKeyboard(chatID, CreateClock(chatID, 12, 0, "am"), "prompt_time", LocalDate(at))
+    }
+  case StageAskInfo:
+    at, _ := time.Parse("3:04 pm", s.Temp.Time)
+    sendKeyboard(chatID, askExtraKeyboard(chatID, ud.Lang), "ask_extra", LocalTime(at))
+  case StageOptInfo:
+    sendText(chatID, "prompt_optinfo")
+  }
+}
+
+func askExtraKeyboard(chatID int64, lang string) Keyboard {
+  return newKeyboard(
+    newRow(
+      callbackButton(chatID, plainText(lang, "btn_yes"), cbAskInfo, "yes"),
+      callbackButton(chatID, plainText(lang, "btn_no"), cbAskInfo, "no"),
+    ),
+  )
+}
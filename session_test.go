@@ -0,0 +1,62 @@
+package main
+
+import (
+  "testing"
+  "time"
+)
+
+func TestSessionLifecycle(t *testing.T) {
+  setupTest(t)
+  const chatID = 7
+
+  s := getSession(chatID)
+  if s.Stage != StageIdle || len(store.Sessions) != 0 {
+    t.Fatalf("idle session %+v stored", s)
+  }
+
+  s.Temp.Name = "dentist"
+  advanceSession(s, StageName)
+  advanceSession(s, StageDate)
+  got := getSession(chatID)
+  if got.Stage != StageDate || got.Temp.Name != "dentist" || len(got.History) != 1 || got.History[0] != StageName {
+    t.Fatalf("stored session %+v", got)
+  }
+
+  // Sessions are copies, like UserData.
+  got.History[0] = StageTime
+  got.Temp.Name = "changed"
+  if again := getSession(chatID); again.History[0] != StageName || again.Temp.Name != "dentist" {
+    t.Fatalf("changing a copy changed the store: %+v", again)
+  }
+
+  resetSession(s)
+  if s.Stage != StageIdle || s.Temp.Name != "" || s.History != nil {
+    t.Fatalf("reset left %+v", s)
+  }
+  if got := getSession(chatID); got.Stage != StageIdle {
+    t.Fatalf("session still stored after reset: %+v", got)
+  }
+}
+
+func TestExpireSession(t *testing.T) {
+  fm := setupTest(t)
+  const chatID = 7
+
+  s := getSession(chatID)
+  advanceSession(s, StageName)
+  if expireSession(chatID) {
+    t.Fatal("fresh session expired")
+  }
+
+  s.Updated = time.Now().Add(-stageTimeouts[StageName] - time.Minute)
+  putSession(s)
+  if !expireSession(chatID) {
+    t.Fatal("stale session not expired")
+  }
+  if got := getSession(chatID); got.Stage != StageIdle {
+    t.Fatalf("expired session still stored: %+v", got)
+  }
+  if m := fm.last(t, chatID); m.Text != tr("en", "setup_expired") {
+    t.Fatalf("sent %q, want the expiry notice", m.Text)
+  }
+}
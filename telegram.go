@@ -0,0 +1,232 @@
+package main
+
+import (
+  "errors"
+  "fmt"
+  "io"
+  "log/slog"
+  "net/http"
+  "strings"
+  "time"
+
+  tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --------- Telegram Adapter ---------
+
+// telegramMessenger implements Messenger on top of the Bot API client.
+// Every call goes through the outbox, which applies Telegram's rate
+// limits and retries transient failures.
+type telegramMessenger struct {
+  api *tgbotapi.BotAPI
+  out *outbox
+}
+
+func newTelegramMessenger(api *tgbotapi.BotAPI) *telegramMessenger {
+  return &telegramMessenger{api: api, out: newOutbox()}
+}
+
+func (t *telegramMessenger) send(chatID int64, c tgbotapi.Chattable) (int, error) {
+  var sent tgbotapi.Message
+  err := t.out.do(chatID, func() error {
+    var err error
+    defer metrics.sendLatency.since(time.Now())
+    sent, err = t.api.Send(c)
+    return err
+  })
+  if chatGone(err) {
+    deactivateChat(chatID, err)
+  }
+  return sent.MessageID, err
+}
+
+func (t *telegramMessenger) request(chatID int64, c tgbotapi.Chattable) error {
+  err := t.out.do(chatID, func() error {
+    defer metrics.sendLatency.since(time.Now())
+    _, err := t.api.Request(c)
+    return err
+  })
+  if chatGone(err) {
+    deactivateChat(chatID, err)
+  }
+  return err
+}
+
+// chatGone reports whether err means the bot can no longer reach the chat
+// at all: 403 (blocked, kicked, user deactivated) or a chat that no longer
+// exists.
+func chatGone(err error) bool {
+  var apiErr *tgbotapi.Error
+  if !errors.As(err, &apiErr) {
+    return false
+  }
+  if apiErr.Code == 403 {
+    return true
+  }
+  msg := strings.ToLower(apiErr.Message)
+  return apiErr.Code == 400 && (strings.Contains(msg, "chat not found") || strings.Contains(msg, "chat was deactivated"))
+}
+
+func toInlineKeyboard(kb Keyboard) tgbotapi.InlineKeyboardMarkup {
+  // Telegram rejects a null keyboard, an empty one removes it.
+  rows := [][]tgbotapi.InlineKeyboardButton{}
+  for _, r := range kb {
+    var row []tgbotapi.InlineKeyboardButton
+    for _, b := range r {
+      row = append(row, tgbotapi.NewInlineKeyboardButtonData(b.Text, b.Data))
+    }
+    rows = append(rows, row)
+  }
+  return tgbotapi.InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+// entitiesRejected reports whether Telegram refused the message's HTML.
+func entitiesRejected(err error) bool {
+  var apiErr *tgbotapi.Error
+  return errors.As(err, &apiErr) && apiErr.Code == 400 && strings.Contains(apiErr.Message, "can't parse entities")
+}
+
+func (t *telegramMessenger) SendText(chatID int64, text string) (int, error) {
+  return t.sendHTML(chatID, tgbotapi.NewMessage(chatID, text))
+}
+
+func (t *telegramMessenger) SendKeyboard(chatID int64, text string, kb Keyboard) (int, error) {
+  m := tgbotapi.NewMessage(chatID, text)
+  m.ReplyMarkup = toInlineKeyboard(kb)
+  return t.sendHTML(chatID, m)
+}
+
+func (t *telegramMessenger) SendSilent(chatID int64, text string, kb Keyboard) (int, error) {
+  m := tgbotapi.NewMessage(chatID, text)
+  m.ReplyMarkup = toInlineKeyboard(kb)
+  m.DisableNotification = true
+  return t.sendHTML(chatID, m)
+}
+
+// sendHTML sends m as HTML, and again as plain text if Telegram rejects
+// the HTML.
+func (t *telegramMessenger) sendHTML(chatID int64, m tgbotapi.MessageConfig) (int, error) {
+  m.ParseMode = tgbotapi.ModeHTML
+  id, err := t.send(chatID, m)
+  if entitiesRejected(err) {
+    slog.Warn("message rejected, sending as plain text", "chat_id", chatID, "err", err)
+    m.Text, m.ParseMode = stripHTML(m.Text), ""
+    return t.send(chatID, m)
+  }
+  return id, err
+}
+
+func (t *telegramMessenger) EditMessage(chatID int64, msgID int, text string, kb Keyboard) error {
+  edit := tgbotapi.NewEditMessageText(chatID, msgID, text)
+  edit.ParseMode = tgbotapi.ModeHTML
+  if kb != nil {
+    markup := toInlineKeyboard(kb)
+    edit.ReplyMarkup = &markup
+  }
+  err := t.request(chatID, edit)
+  if entitiesRejected(err) {
+    slog.Warn("message rejected, sending as plain text", "chat_id", chatID, "err", err)
+    edit.Text, edit.ParseMode = stripHTML(text), ""
+    return t.request(chatID, edit)
+  }
+  return err
+}
+
+func (t *telegramMessenger) EditKeyboard(chatID int64, msgID int, kb Keyboard) error {
+  return t.request(chatID, tgbotapi.NewEditMessageReplyMarkup(chatID, msgID, toInlineKeyboard(kb)))
+}
+
+func (t *telegramMessenger) SendDocument(chatID int64, name string, data []byte, caption string) (int, error) {
+  doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: name, Bytes: data})
+  doc.Caption = caption
+  doc.ParseMode = tgbotapi.ModeHTML
+  return t.send(chatID, doc)
+}
+
+func (t *telegramMessenger) PinMessage(chatID int64, msgID int) error {
+  return t.request(chatID, tgbotapi.PinChatMessageConfig{ChatID: chatID, MessageID: msgID, DisableNotification: true})
+}
+
+func (t *telegramMessenger) UnpinMessage(chatID int64, msgID int) error {
+  return t.request(chatID, tgbotapi.UnpinChatMessageConfig{ChatID: chatID, MessageID: msgID})
+}
+
+// AnswerCallback is not a chat message and is not rate limited.
+func (t *telegramMessenger) AnswerCallback(callbackID, text string) error {
+  _, err := t.api.Request(tgbotapi.NewCallback(callbackID, text))
+  return err
+}
+
+// fileHTTP downloads files users send to the bot.
+var fileHTTP = &http.Client{Timeout: 30 * time.Second}
+
+// downloadFile fetches a file from Telegram's servers, up to maxImportSize
+// bytes.
+func downloadFile(fileID string) ([]byte, error) {
+  url, err := bot.GetFileDirectURL(fileID)
+  if err != nil {
+    return nil, err
+  }
+  resp, err := fileHTTP.Get(url)
+  if err != nil {
+    // The error carries the URL, which contains the bot token.
+    return nil, errors.New("file download failed")
+  }
+  defer resp.Body.Close()
+  if resp.StatusCode != http.StatusOK {
+    return nil, fmt.Errorf("file download failed: %s", resp.Status)
+  }
+  return io.ReadAll(io.LimitReader(resp.Body, maxImportSize+1))
+}
+
+// updateChatID returns the chat an update belongs to, 0 if none.
+func updateChatID(upd tgbotapi.Update) int64 {
+  if upd.Message != nil {
+    return upd.Message.Chat.ID
+  }
+  if upd.CallbackQuery != nil && upd.CallbackQuery.Message != nil {
+    return upd.CallbackQuery.Message.Chat.ID
+  }
+  return 0
+}
+
+// dispatchUpdate converts one Telegram update and routes it to its
+// handler, whatever the delivery mode.
+func dispatchUpdate(upd tgbotapi.Update) {
+  defer metrics.updateDuration.since(time.Now())
+  switch {
+  case upd.Message != nil:
+    metrics.updates.inc("message")
+  case upd.CallbackQuery != nil:
+    metrics.updates.inc("callback")
+  default:
+    metrics.updates.inc("other")
+  }
+  if m := upd.Message; m != nil {
+    in := &InMessage{ChatID: m.Chat.ID, MessageID: m.MessageID, Text: m.Text}
+    if m.From != nil {
+      in.UserID = m.From.ID
+    }
+    if d := m.Document; d != nil {
+      in.Document = &InDocument{Name: d.FileName, Size: d.FileSize, Fetch: func() ([]byte, error) {
+        return downloadFile(d.FileID)
+      }}
+    }
+    in.Log = slog.With("update_id", upd.UpdateID, "chat_id", in.ChatID, "user_id", in.UserID)
+    in.Log.Debug("message received", "text", userText(in.Text))
+    handleMessage(in)
+  }
+  if q := upd.CallbackQuery; q != nil {
+    // Callbacks from inline-mode messages carry no chat and are not ours.
+    if q.Message == nil {
+      return
+    }
+    in := &InCallback{ID: q.ID, ChatID: q.Message.Chat.ID, MessageID: q.Message.MessageID, Data: q.Data}
+    if q.From != nil {
+      in.UserID = q.From.ID
+    }
+    in.Log = slog.With("update_id", upd.UpdateID, "chat_id", in.ChatID, "user_id", in.UserID)
+    in.Log.Debug("callback received", "data", in.Data)
+    handleCallback(in)
+  }
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+  "context"
+  "crypto/subtle"
+  "fmt"
+  "log/slog"
+  "net/http"
+  "net/url"
+  "strings"
+  "time"
+
+  tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --------- Webhook ---------
+
+// WebhookSettings configures webhook delivery mode.
+type WebhookSettings struct {
+  URL            string `json:"url"`             // Public HTTPS URL Telegram posts updates to
+  Listen         string `json:"listen"`          // Local listen address, e.g. ":8080"
+  SecretToken    string `json:"secret_token"`    // Checked against X-Telegram-Bot-Api-Secret-Token
+  CertFile       string `json:"cert_file"`       // Serve HTTPS directly when set with KeyFile
+  KeyFile        string `json:"key_file"`
+  UploadCert     bool   `json:"upload_cert"`     // Send CertFile to Telegram (self-signed certificates)
+  MaxConnections int    `json:"max_connections"`
+}
+
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+func (w *WebhookSettings) validate() error {
+  if w.URL == "" {
+    return fieldErrorf("webhook.url", "webhook.url 为空")
+  }
+  u, err := url.Parse(w.URL)
+  if err != nil || u.Scheme != "https" || u.Host == "" {
+    return fieldErrorf("webhook.url", "webhook.url 必须是 https 地址: %q", w.URL)
+  }
+  if w.Listen == "" {
+    w.Listen = ":8080"
+  }
+  if (w.CertFile == "") != (w.KeyFile == "") {
+    field := "webhook.cert_file"
+    if w.CertFile != "" {
+      field = "webhook.key_file"
+    }
+    return fieldErrorf(field, "webhook.cert_file 和 webhook.key_file 必须同时设置")
+  }
+  if w.UploadCert && w.CertFile == "" {
+    return fieldErrorf("webhook.upload_cert", "webhook.upload_cert 需要 webhook.cert_file")
+  }
+  // Telegram only accepts A-Z, a-z, 0-9, _ and - in the secret, 1-256 chars.
+  if len(w.SecretToken) > 256 || strings.TrimFunc(w.SecretToken, isSecretTokenRune) != "" {
+    return fieldErrorf("webhook.secret_token", "webhook.secret_token 只能包含 A-Z a-z 0-9 _ -，且不超过 256 个字符")
+  }
+  return nil
+}
+
+func isSecretTokenRune(r rune) bool {
+  return r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-'
+}
+
+// webhookServer receives updates over HTTP and forwards them to Updates.
+type webhookServer struct {
+  cfg     WebhookSettings
+  srv     *http.Server
+  closing chan struct{} // Closed by Stop, so blocked handlers give up
+  Updates chan tgbotapi.Update
+}
+
+// startWebhook registers the webhook with Telegram and starts serving it.
+func startWebhook(cfg WebhookSettings) (*webhookServer, error) {
+  u, _ := url.Parse(cfg.URL)
+  path := u.Path
+  if path == "" {
+    path = "/"
+  }
+
+  ws := &webhookServer{cfg: cfg, closing: make(chan struct{}), Updates: make(chan tgbotapi.Update, bot.Buffer)}
+  mux := http.NewServeMux()
+  mux.HandleFunc(path, ws.handle)
+  ws.srv = &http.Server{
+    Addr:              cfg.Listen,
+    Handler:           mux,
+    ReadHeaderTimeout: 10 * time.Second,
+  }
+
+  go func() {
+    var err error
+    if cfg.CertFile != "" {
+      err = ws.srv.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
+    } else {
+      // Plain HTTP, TLS is terminated by a reverse proxy.
+      err = ws.srv.ListenAndServe()
+    }
+    if err != nil && err != http.ErrServerClosed {
+      fatal("webhook server failed", "err", err)
+    }
+  }()
+
+  if err := ws.register(); err != nil {
+    ws.srv.Close()
+    return nil, err
+  }
+  slog.Info("webhook registered", "url", cfg.URL, "listen", cfg.Listen)
+  return ws, nil
+}
+
+func (ws *webhookServer) register() error {
+  params := tgbotapi.Params{}
+  params["url"] = ws.cfg.URL
+  params.AddNonEmpty("secret_token", ws.cfg.SecretToken)
+  params.AddNonZero("max_connections", ws.cfg.MaxConnections)
+  params.AddInterface("allowed_updates", []string{"message", "callback_query"})
+
+  var err error
+  if ws.cfg.UploadCert {
+    files := []tgbotapi.RequestFile{{Name: "certificate", Data: tgbotapi.FilePath(ws.cfg.CertFile)}}
+    _, err = bot.UploadFiles("setWebhook", params, files)
+  } else {
+    _, err = bot.MakeRequest("setWebhook", params)
+  }
+  if err != nil {
+    return fmt.Errorf("setWebhook: %w", err)
+  }
+  return nil
+}
+
+func (ws *webhookServer) handle(w http.ResponseWriter, r *http.Request) {
+  if ws.cfg.SecretToken != "" {
+    got := r.Header.Get(secretTokenHeader)
+    if subtle.ConstantTimeCompare([]byte(got), []byte(ws.cfg.SecretToken)) != 1 {
+      http.Error(w, "forbidden", http.StatusForbidden)
+      return
+    }
+  }
+  upd, err := bot.HandleUpdate(r)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+  select {
+  case ws.Updates <- *upd:
+    w.WriteHeader(http.StatusOK)
+  case <-r.Context().Done():
+    // Telegram retries undelivered updates.
+    http.Error(w, "busy", http.StatusServiceUnavailable)
+  case <-ws.closing:
+    // Nothing reads Updates any more once shutdown has begun.
+    http.Error(w, "shutting down", http.StatusServiceUnavailable)
+  }
+}
+
+// Stop deregisters the webhook and shuts the HTTP server down. Handlers
+// still waiting to queue an update answer 503, and Updates is closed only
+// once none is left that could send on it.
+func (ws *webhookServer) Stop(ctx context.Context) {
+  if _, err := bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+    slog.Warn("deleteWebhook failed", "err", err)
+  }
+  close(ws.closing)
+  if err := ws.srv.Shutdown(ctx); err != nil {
+    slog.Warn("webhook server shutdown failed", "err", err)
+    return
+  }
+  close(ws.Updates)
+}
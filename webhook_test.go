@@ -0,0 +1,41 @@
+package main
+
+import (
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "time"
+
+  tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestWebhookHandlerGivesUpWhenClosing(t *testing.T) {
+  ws := &webhookServer{closing: make(chan struct{}), Updates: make(chan tgbotapi.Update, 1)}
+  post := func() *httptest.ResponseRecorder {
+    w := httptest.NewRecorder()
+    ws.handle(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"update_id":1}`)))
+    return w
+  }
+  if w := post(); w.Code != http.StatusOK {
+    t.Fatalf("first update answered %d", w.Code)
+  }
+
+  // The queue is full and nothing reads it, as during shutdown.
+  done := make(chan int)
+  go func() { done <- post().Code }()
+  select {
+  case code := <-done:
+    t.Fatalf("update queued past a full buffer, answered %d", code)
+  case <-time.After(100 * time.Millisecond):
+  }
+  close(ws.closing)
+  select {
+  case code := <-done:
+    if code != http.StatusServiceUnavailable {
+      t.Fatalf("blocked handler answered %d, want 503", code)
+    }
+  case <-time.After(2 * time.Second):
+    t.Fatal("handler still blocked after closing")
+  }
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+  "sync"
+
+  tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --------- Update Workers ---------
+
+const (
+  defaultWorkers   = 8
+  workerQueueDepth = 64
+)
+
+// updatePool handles updates on a fixed set of workers. Updates are
+// sharded by chat ID, so one chat's updates are handled in order while
+// different chats proceed in parallel.
+type updatePool struct {
+  shards []chan tgbotapi.Update
+  wg     sync.WaitGroup
+}
+
+// newUpdatePool starts workers that pass each update to handle,
+// dispatchUpdate outside of tests.
+func newUpdatePool(workers int, handle func(tgbotapi.Update)) *updatePool {
+  if workers <= 0 {
+    workers = defaultWorkers
+  }
+  p := &updatePool{shards: make([]chan tgbotapi.Update, workers)}
+  for i := range p.shards {
+    ch := make(chan tgbotapi.Update, workerQueueDepth)
+    p.shards[i] = ch
+    p.wg.Add(1)
+    go func() {
+      defer p.wg.Done()
+      for upd := range ch {
+        handle(upd)
+      }
+    }()
+  }
+  return p
+}
+
+// Submit queues upd on its chat's worker. It blocks while that worker's
+// queue is full.
+func (p *updatePool) Submit(upd tgbotapi.Update) {
+  id := updateChatID(upd)
+  if id < 0 {
+    id = -id
+  }
+  p.shards[id%int64(len(p.shards))] <- upd
+}
+
+// Close stops accepting updates and waits for queued ones to be handled.
+func (p *updatePool) Close() {
+  for _, ch := range p.shards {
+    close(ch)
+  }
+  p.wg.Wait()
+}
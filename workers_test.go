@@ -0,0 +1,79 @@
+package main
+
+import (
+  "sync"
+  "testing"
+  "time"
+
+  tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func messageUpdate(chatID int64, msgID int) tgbotapi.Update {
+  return tgbotapi.Update{Message: &tgbotapi.Message{MessageID: msgID, Chat: &tgbotapi.Chat{ID: chatID}}}
+}
+
+func TestUpdatePoolKeepsChatOrder(t *testing.T) {
+  const chats, perChat = 20, 200
+  var mu sync.Mutex
+  got := make(map[int64][]int)
+  p := newUpdatePool(4, func(upd tgbotapi.Update) {
+    mu.Lock()
+    got[upd.Message.Chat.ID] = append(got[upd.Message.Chat.ID], upd.Message.MessageID)
+    mu.Unlock()
+  })
+
+  var wg sync.WaitGroup
+  for c := 1; c <= chats; c++ {
+    chatID := int64(c)
+    if c%2 == 0 {
+      chatID = -1000 * chatID // Groups have negative IDs
+    }
+    wg.Add(1)
+    go func(chatID int64) {
+      defer wg.Done()
+      for i := 0; i < perChat; i++ {
+        p.Submit(messageUpdate(chatID, i))
+      }
+    }(chatID)
+  }
+  wg.Wait()
+  p.Close()
+
+  if len(got) != chats {
+    t.Fatalf("updates of %d chats handled, want %d", len(got), chats)
+  }
+  for chatID, ids := range got {
+    if len(ids) != perChat {
+      t.Fatalf("chat %d: %d updates handled, want %d", chatID, len(ids), perChat)
+    }
+    for i, id := range ids {
+      if id != i {
+        t.Fatalf("chat %d: update %d handled at position %d", chatID, id, i)
+      }
+    }
+  }
+}
+
+func TestUpdatePoolChatsDoNotBlockEachOther(t *testing.T) {
+  release := make(chan struct{})
+  done := make(chan int64, 1)
+  p := newUpdatePool(2, func(upd tgbotapi.Update) {
+    if upd.Message.Chat.ID == 1 {
+      <-release
+    }
+    done <- upd.Message.Chat.ID
+  })
+  defer p.Close()
+  defer close(release)
+
+  p.Submit(messageUpdate(1, 1))
+  p.Submit(messageUpdate(2, 1))
+  select {
+  case id := <-done:
+    if id != 2 {
+      t.Fatalf("chat %d handled first", id)
+    }
+  case <-time.After(2 * time.Second):
+    t.Fatal("a busy chat held up another chat's update")
+  }
+}